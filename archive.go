@@ -0,0 +1,323 @@
+package bypasser
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// maxArchiveEntry caps each file read back from an archive; real configs
+// are a few KiB.
+const maxArchiveEntry = 1 << 20
+
+// ExportVPN writes a tar stream holding vpn's config as "<vpn>.conf" and each
+// of its peer files as "peers/<peer>.conf", byte for byte, so the managed
+// metadata travels with them. The archive is NOT encrypted: it contains the
+// server and client private keys and must be handled like the configs, or
+// written with ExportVPNEncrypted instead.
+func (m *Manager) ExportVPN(ctx context.Context, vpn string, w io.Writer) error {
+	if err := ValidateName("vpn", vpn); err != nil {
+		return err
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vpnPath := m.cfg.VPNConfigPath(vpn)
+	vpnBytes, err := os.ReadFile(vpnPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return vpnNotFound(vpn, vpnPath)
+		}
+		return err
+	}
+	peers, err := m.ListPeers()
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	modTime := m.now()
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0o600,
+			Size:     int64(len(data)),
+			ModTime:  modTime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(vpn+".conf", vpnBytes); err != nil {
+		return err
+	}
+	for _, p := range peers {
+		if p.VPN != vpn {
+			continue
+		}
+		b, err := os.ReadFile(m.cfg.PeerConfigPath(p.VPN, p.Peer))
+		if err != nil {
+			return err
+		}
+		if err := add("peers/"+p.Peer+".conf", b); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ImportVPN restores an archive written by ExportVPN. Nothing is written when
+// the vpn, any of its peers, its ListenPort or its subnet already exists here.
+func (m *Manager) ImportVPN(ctx context.Context, r io.Reader) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	vpn, vpnConf, peers, err := readVPNArchive(r)
+	if err != nil {
+		return rep, err
+	}
+
+	if err := m.ensureDir(m.cfg.WireGuardDir, &rep); err != nil {
+		return rep, err
+	}
+	if err := m.ensureDir(m.cfg.PeersDir(), &rep); err != nil {
+		return rep, err
+	}
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	vpnPath := m.cfg.VPNConfigPath(vpn)
+	if _, err := os.Stat(vpnPath); err == nil {
+		return rep, vpnExists(vpn, vpnPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return rep, err
+	}
+	for _, p := range peers {
+		ref := PeerRef{VPN: vpn, Peer: p.name}
+		peerPath := m.cfg.PeerConfigPath(vpn, p.name)
+		if _, err := os.Stat(peerPath); err == nil {
+			return rep, peerExists(ref, peerPath)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return rep, err
+		}
+	}
+	vpnDoc := parseINI(string(vpnConf))
+	portStr := vpnDoc.First("Interface", "ListenPort")
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return rep, errorf(ErrValidation, "archived vpn config has invalid ListenPort %q", portStr)
+	}
+	if err := m.checkRequestedPort(ctx, port); err != nil {
+		return rep, err
+	}
+	for _, addr := range strings.Split(vpnDoc.First("Interface", "Address"), ",") {
+		addr = strings.TrimSpace(addr)
+		if strings.Contains(addr, ".") {
+			if err := m.checkSubnetFree("", addr); err != nil {
+				return rep, err
+			}
+		}
+	}
+
+	if err := m.writeFile(vpnPath, vpnConf, &rep); err != nil {
+		return rep, err
+	}
+	for _, p := range peers {
+		if err := m.writeFile(m.cfg.PeerConfigPath(vpn, p.name), p.data, &rep); err != nil {
+			return rep, err
+		}
+	}
+	m.maybeVPNRestart(ctx, &rep, vpn)
+	m.updateInventory(&rep)
+	return rep, nil
+}
+
+type archivedPeer struct {
+	name string
+	data []byte
+}
+
+// readVPNArchive reads and checks a whole archive before anything is written.
+func readVPNArchive(r io.Reader) (vpn string, vpnConf []byte, peers []archivedPeer, err error) {
+	tr := tar.NewReader(r)
+	seen := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", nil, nil, errorf(ErrValidation, "invalid vpn archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return "", nil, nil, errorf(ErrValidation, "invalid vpn archive: %s is not a regular file", hdr.Name)
+		}
+		if seen[hdr.Name] {
+			return "", nil, nil, errorf(ErrValidation, "invalid vpn archive: duplicate entry %s", hdr.Name)
+		}
+		seen[hdr.Name] = true
+		data, err := io.ReadAll(io.LimitReader(tr, maxArchiveEntry+1))
+		if err != nil {
+			return "", nil, nil, errorf(ErrValidation, "invalid vpn archive: %w", err)
+		}
+		if len(data) > maxArchiveEntry {
+			return "", nil, nil, errorf(ErrValidation, "invalid vpn archive: %s is larger than %d bytes", hdr.Name, maxArchiveEntry)
+		}
+
+		dir, file := path.Split(hdr.Name)
+		name, ok := strings.CutSuffix(file, ".conf")
+		switch {
+		case ok && dir == "":
+			if vpn != "" {
+				return "", nil, nil, errorf(ErrValidation, "invalid vpn archive: more than one vpn config")
+			}
+			if err := ValidateName("vpn", name); err != nil {
+				return "", nil, nil, err
+			}
+			vpn, vpnConf = name, data
+		case ok && dir == "peers/":
+			if err := ValidateName("peer", name); err != nil {
+				return "", nil, nil, err
+			}
+			peers = append(peers, archivedPeer{name: name, data: data})
+		default:
+			return "", nil, nil, errorf(ErrValidation, "invalid vpn archive: unexpected entry %s", hdr.Name)
+		}
+	}
+	if vpn == "" {
+		return "", nil, nil, errorf(ErrValidation, "invalid vpn archive: no vpn config")
+	}
+	for _, p := range peers {
+		meta := parseManagedMeta(firstLine(p.data))
+		if meta != nil && meta["vpn"] != vpn {
+			return "", nil, nil, errorf(ErrValidation, "invalid vpn archive: peer %s belongs to vpn %q, not %q", p.name, meta["vpn"], vpn)
+		}
+	}
+	return vpn, vpnConf, peers, nil
+}
+
+func firstLine(b []byte) string {
+	line, _, _ := bytes.Cut(b, []byte("\n"))
+	return string(line)
+}
+
+// Encrypted archives are an ExportVPN tar sealed with AES-256-GCM under a
+// scrypt-derived key:
+//
+//	"BPVA" | version (1 byte) | salt (16) | nonce (12) | ciphertext
+//
+// The header up to the nonce is authenticated as additional data. The
+// version selects the KDF parameters so they can be raised later.
+const (
+	archiveMagic     = "BPVA"
+	archiveVersion1  = 1
+	archiveSaltSize  = 16
+	archiveHeaderLen = len(archiveMagic) + 1 + archiveSaltSize
+
+	// maxEncryptedArchive bounds what ImportVPNEncrypted reads into memory.
+	maxEncryptedArchive = 64 << 20
+)
+
+// ExportVPNEncrypted is ExportVPN with the archive encrypted under passphrase.
+func (m *Manager) ExportVPNEncrypted(ctx context.Context, vpn string, w io.Writer, passphrase string) error {
+	if passphrase == "" {
+		return errorf(ErrValidation, "archive passphrase must not be empty")
+	}
+	var plain bytes.Buffer
+	if err := m.ExportVPN(ctx, vpn, &plain); err != nil {
+		return err
+	}
+
+	header := make([]byte, archiveHeaderLen)
+	copy(header, archiveMagic)
+	header[len(archiveMagic)] = archiveVersion1
+	salt := header[len(archiveMagic)+1:]
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := archiveCipher(archiveVersion1, passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out := append(header, nonce...)
+	out = aead.Seal(out, nonce, plain.Bytes(), header)
+	_, err = w.Write(out)
+	return err
+}
+
+// ImportVPNEncrypted decrypts an ExportVPNEncrypted archive and restores it
+// like ImportVPN. A wrong passphrase or tampered archive fails with
+// ErrArchiveAuth before anything is written.
+func (m *Manager) ImportVPNEncrypted(ctx context.Context, r io.Reader, passphrase string) (Report, error) {
+	var rep Report
+	data, err := io.ReadAll(io.LimitReader(r, maxEncryptedArchive+1))
+	if err != nil {
+		return rep, err
+	}
+	if len(data) > maxEncryptedArchive {
+		return rep, errorf(ErrValidation, "encrypted vpn archive is larger than %d bytes", maxEncryptedArchive)
+	}
+	if len(data) < archiveHeaderLen || string(data[:len(archiveMagic)]) != archiveMagic {
+		return rep, errorf(ErrValidation, "not an encrypted vpn archive")
+	}
+	version := data[len(archiveMagic)]
+	if version != archiveVersion1 {
+		return rep, errorf(ErrValidation, "unsupported encrypted vpn archive version %d", version)
+	}
+	header, rest := data[:archiveHeaderLen], data[archiveHeaderLen:]
+	aead, err := archiveCipher(version, passphrase, header[len(archiveMagic)+1:])
+	if err != nil {
+		return rep, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return rep, errorf(ErrValidation, "encrypted vpn archive is truncated")
+	}
+	nonce, sealed := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		return rep, errorf(ErrArchiveAuth, "cannot decrypt vpn archive: wrong passphrase or corrupted archive")
+	}
+	return m.ImportVPN(ctx, bytes.NewReader(plain))
+}
+
+func archiveCipher(version byte, passphrase string, salt []byte) (cipher.AEAD, error) {
+	if version != archiveVersion1 {
+		return nil, fmt.Errorf("unsupported archive version %d", version)
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,186 @@
+package bypasser
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExportImportVPN(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	src := newTestManager(t, Config{})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := src.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	for _, ref := range []PeerRef{{"home", "laptop"}, {"home", "phone"}, {"work", "desk"}} {
+		if _, err := src.AddPeer(ctx, ref.VPN, ref.Peer); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := src.ExportVPN(ctx, "home", &buf); err != nil {
+		t.Fatalf("ExportVPN returned error: %v", err)
+	}
+	archive := buf.Bytes()
+
+	dst := newTestManager(t, Config{})
+	if _, err := dst.ImportVPN(ctx, bytes.NewReader(archive)); err != nil {
+		t.Fatalf("ImportVPN returned error: %v", err)
+	}
+	srcCfg, dstCfg := src.Config(), dst.Config()
+	for _, pair := range [][2]string{
+		{srcCfg.VPNConfigPath("home"), dstCfg.VPNConfigPath("home")},
+		{srcCfg.PeerConfigPath("home", "laptop"), dstCfg.PeerConfigPath("home", "laptop")},
+		{srcCfg.PeerConfigPath("home", "phone"), dstCfg.PeerConfigPath("home", "phone")},
+	} {
+		if got, want := readTestFile(t, pair[1]), readTestFile(t, pair[0]); got != want {
+			t.Fatalf("%s differs from the exported file:\n%s", pair[1], got)
+		}
+	}
+	if _, err := os.Stat(dstCfg.PeerConfigPath("work", "desk")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("peer of another vpn was exported: %v", err)
+	}
+
+	if _, err := dst.ImportVPN(ctx, bytes.NewReader(archive)); !errors.Is(err, ErrVPNExists) {
+		t.Fatalf("expected ErrVPNExists, got %v", err)
+	}
+	if err := os.Remove(dstCfg.VPNConfigPath("home")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.ImportVPN(ctx, bytes.NewReader(archive)); !errors.Is(err, ErrPeerExists) {
+		t.Fatalf("expected ErrPeerExists, got %v", err)
+	}
+
+	if err := src.ExportVPN(ctx, "missing", &buf); !errors.Is(err, ErrVPNNotFound) {
+		t.Fatalf("expected ErrVPNNotFound, got %v", err)
+	}
+}
+
+func TestImportVPNCollisions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	src := newTestManager(t, Config{})
+	if _, err := src.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := src.ExportVPN(ctx, "home", &buf); err != nil {
+		t.Fatalf("ExportVPN returned error: %v", err)
+	}
+
+	// office takes the same port and subnet home used on the source.
+	dst := newTestManager(t, Config{})
+	if _, err := dst.AddVPN(ctx, "office"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := dst.ImportVPN(ctx, bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected ErrAlreadyExists, got %v", err)
+	}
+	if _, err := os.Stat(dst.Config().VPNConfigPath("home")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("vpn was written despite the collision: %v", err)
+	}
+}
+
+func TestImportVPNRejectsBadArchives(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		entries map[string]string
+	}{
+		{"empty", nil},
+		{"no vpn", map[string]string{"peers/laptop.conf": "[Interface]\n"}},
+		{"path traversal", map[string]string{"../home.conf": "[Interface]\n"}},
+		{"invalid name", map[string]string{"Home.conf": "[Interface]\n"}},
+		{"foreign peer", map[string]string{
+			"home.conf":         "[Interface]\nListenPort = 55107\n",
+			"peers/laptop.conf": "# bp-managed: vpn=work,peer=laptop\n[Interface]\n",
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for name, body := range tc.entries {
+				if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o600, Size: int64(len(body))}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := tw.Write([]byte(body)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			mgr := newTestManager(t, Config{})
+			if _, err := mgr.ImportVPN(context.Background(), &buf); !errors.Is(err, ErrValidation) {
+				t.Fatalf("expected ErrValidation, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExportImportVPNEncrypted(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	src := newTestManager(t, Config{})
+	if _, err := src.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := src.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+
+	if err := src.ExportVPNEncrypted(ctx, "home", io.Discard, ""); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected empty passphrase to be rejected, got %v", err)
+	}
+	var buf bytes.Buffer
+	if err := src.ExportVPNEncrypted(ctx, "home", &buf, "correct horse"); err != nil {
+		t.Fatalf("ExportVPNEncrypted returned error: %v", err)
+	}
+	sealed := buf.Bytes()
+	priv := firstSectionValue(readTestFile(t, src.Config().VPNConfigPath("home")), "Interface", "PrivateKey")
+	if !bytes.HasPrefix(sealed, []byte("BPVA\x01")) || bytes.Contains(sealed, []byte(priv)) {
+		t.Fatalf("archive is not encrypted: %q", sealed[:16])
+	}
+
+	dst := newTestManager(t, Config{})
+	if _, err := dst.ImportVPNEncrypted(ctx, bytes.NewReader(sealed), "wrong"); !errors.Is(err, ErrArchiveAuth) {
+		t.Fatalf("expected ErrArchiveAuth for a wrong passphrase, got %v", err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := dst.ImportVPNEncrypted(ctx, bytes.NewReader(tampered), "correct horse"); !errors.Is(err, ErrArchiveAuth) {
+		t.Fatalf("expected ErrArchiveAuth for a tampered archive, got %v", err)
+	}
+	future := bytes.Clone(sealed)
+	future[4] = 9
+	if _, err := dst.ImportVPNEncrypted(ctx, bytes.NewReader(future), "correct horse"); err == nil || !strings.Contains(err.Error(), "version 9") {
+		t.Fatalf("expected unsupported version error, got %v", err)
+	}
+	if _, err := os.Stat(dst.Config().VPNConfigPath("home")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("failed imports wrote the vpn: %v", err)
+	}
+
+	if _, err := dst.ImportVPNEncrypted(ctx, bytes.NewReader(sealed), "correct horse"); err != nil {
+		t.Fatalf("ImportVPNEncrypted returned error: %v", err)
+	}
+	if got, want := readTestFile(t, dst.Config().PeerConfigPath("home", "laptop")), readTestFile(t, src.Config().PeerConfigPath("home", "laptop")); got != want {
+		t.Fatalf("imported peer differs:\n%s", got)
+	}
+}
@@ -0,0 +1,52 @@
+package bypasser
+
+import (
+	"os"
+	"path/filepath"
+)
+
+func writeAll(f *os.File, data []byte) error {
+	_, err := f.Write(data)
+	return err
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into
+// place, so readers only ever see the old or the new content. os.Rename
+// replaces an existing target on Windows as well (MoveFileEx with
+// MOVEFILE_REPLACE_EXISTING).
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomicWith(path, data, perm, writeAll)
+}
+
+func writeFileAtomicWith(path string, data []byte, perm os.FileMode, write func(*os.File, []byte) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	ok := false
+	defer func() {
+		if !ok {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if err := write(f, data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	ok = true
+	return nil
+}
@@ -0,0 +1,51 @@
+package bypasser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicLeavesTargetIntactOnFailure(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bp-home.conf")
+	writeTestFile(t, path, "old content\n")
+
+	crash := func(f *os.File, data []byte) error {
+		if _, err := f.Write(data[:len(data)/2]); err != nil {
+			return err
+		}
+		return errors.New("killed")
+	}
+
+	if err := writeFileAtomicWith(path, []byte("new content that is longer\n"), 0o600, crash); err == nil {
+		t.Fatal("expected write error")
+	}
+	if got := readTestFile(t, path); got != "old content\n" {
+		t.Fatalf("target was modified: %q", got)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temp file left behind: %v", entries)
+	}
+
+	if err := writeFileAtomic(path, []byte("new content\n"), 0o600); err != nil {
+		t.Fatalf("writeFileAtomic returned error: %v", err)
+	}
+	if got := readTestFile(t, path); got != "new content\n" {
+		t.Fatalf("unexpected content: %q", got)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected perms: %v", info.Mode().Perm())
+	}
+}
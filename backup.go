@@ -0,0 +1,128 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// backup copies the files a destructive operation is about to change into a
+// new timestamped directory under Config.BackupDir, mirroring their layout
+// below WireGuardDir. It does nothing when BackupDir is unset.
+func (m *Manager) backup(rep *Report, label string, paths ...string) error {
+	if m.cfg.BackupDir == "" {
+		return nil
+	}
+	base := filepath.Join(m.cfg.BackupDir, m.now().UTC().Format("20060102T150405Z")+"-"+label)
+	if m.cfg.DryRun {
+		rep.addChange("would-back-up", base)
+		return nil
+	}
+	if err := os.MkdirAll(m.cfg.BackupDir, m.cfg.DirPerm); err != nil {
+		return err
+	}
+	dir := base
+	for i := 2; ; i++ {
+		err := os.Mkdir(dir, m.cfg.DirPerm)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		dir = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(m.cfg.WireGuardDir, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			return fmt.Errorf("cannot back up %s: not under %s", path, m.cfg.WireGuardDir)
+		}
+		dst := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), m.cfg.DirPerm); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(dst, b, m.cfg.FilePerm); err != nil {
+			return err
+		}
+	}
+	rep.addChange("backed-up", dir)
+	return nil
+}
+
+// RestoreBackup copies the files of a backup (the directory name recorded in a
+// "backed-up" change) back into WireGuardDir and restarts the affected vpns.
+func (m *Manager) RestoreBackup(name string) (Report, error) {
+	var rep Report
+	ctx := context.Background()
+	if m.cfg.BackupDir == "" {
+		return rep, errors.New("no backup directory configured")
+	}
+	if name == "" || !filepath.IsLocal(name) || filepath.Base(name) != name {
+		return rep, errorf(ErrValidation, "invalid backup name %q", name)
+	}
+	dir := filepath.Join(m.cfg.BackupDir, name)
+	if _, err := os.Stat(dir); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, errorf(ErrNotFound, "backup %q does not exist (%s)", name, dir)
+		}
+		return rep, err
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	var vpns []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(m.cfg.WireGuardDir, rel)
+		if err := m.ensureDir(filepath.Dir(target), &rep); err != nil {
+			return err
+		}
+		if err := m.writeFile(target, b, &rep); err != nil {
+			return err
+		}
+		if filepath.Dir(rel) == "." && strings.HasSuffix(rel, ".conf") {
+			if m.cfg.InterfaceNameTemplate != "" {
+				if vpn := vpnMeta(parseINI(string(b)))["vpn"]; vpn != "" {
+					vpns = append(vpns, vpn)
+				}
+			} else if strings.HasPrefix(rel, m.cfg.InterfacePrefix) {
+				vpns = append(vpns, strings.TrimSuffix(strings.TrimPrefix(rel, m.cfg.InterfacePrefix), ".conf"))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return rep, err
+	}
+	for _, vpn := range vpns {
+		m.maybeVPNRestart(ctx, &rep, vpn)
+	}
+	m.updateInventory(&rep)
+	return rep, nil
+}
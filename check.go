@@ -0,0 +1,187 @@
+package bypasser
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// peerCheckTimeout bounds each reachability probe.
+const peerCheckTimeout = 2 * time.Second
+
+// PeerCheck is the result of probing one peer's tunnel address. RTT is set
+// when the peer answered; Error says why it did not.
+type PeerCheck struct {
+	Peer      string        `json:"peer"`
+	Address   string        `json:"address"`
+	Reachable bool          `json:"reachable"`
+	RTT       time.Duration `json:"rtt,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// CheckPeers sends one echo request to the tunnel address of every peer of
+// vpn. As root it uses a raw ICMP socket; otherwise (or inside Config.NetNS)
+// it runs ping. Unreachable peers are reported in the results, not as errors.
+func (m *Manager) CheckPeers(ctx context.Context, vpn string) ([]PeerCheck, error) {
+	if err := ValidateName("vpn", vpn); err != nil {
+		return nil, err
+	}
+	vpnPath := m.cfg.VPNConfigPath(vpn)
+	if _, err := os.Stat(vpnPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, vpnNotFound(vpn, vpnPath)
+		}
+		return nil, err
+	}
+
+	probe := m.pingCommand
+	switch {
+	case m.sys.IsRoot() && m.cfg.NetNS == "":
+		probe = m.pingICMP
+	case !m.sys.HasCommand("ping"):
+		return nil, errors.New("checking peers needs root privileges or the ping command")
+	}
+
+	peers, err := m.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+	var checks []PeerCheck
+	for _, p := range peers {
+		if p.VPN != vpn {
+			continue
+		}
+		c := PeerCheck{Peer: p.Peer}
+		addr, err := m.PeerAddress(p.VPN, p.Peer)
+		if err == nil {
+			c.Address, _, _ = strings.Cut(strings.TrimSpace(strings.Split(addr, ",")[0]), "/")
+			if net.ParseIP(c.Address) == nil {
+				err = fmt.Errorf("invalid address %q", addr)
+			}
+		}
+		if err != nil {
+			c.Error = err.Error()
+		}
+		checks = append(checks, c)
+	}
+
+	var wg sync.WaitGroup
+	for i := range checks {
+		c := &checks[i]
+		if c.Error != "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtt, err := probe(ctx, net.ParseIP(c.Address))
+			if err != nil {
+				c.Error = err.Error()
+				return
+			}
+			c.Reachable, c.RTT = true, rtt
+		}()
+	}
+	wg.Wait()
+	return checks, nil
+}
+
+var pingTimeRE = regexp.MustCompile(`time[=<]([0-9.]+) ?ms`)
+
+func (m *Manager) pingCommand(ctx context.Context, ip net.IP) (time.Duration, error) {
+	secs := strconv.Itoa(int(peerCheckTimeout / time.Second))
+	cmd := m.netnsCommand("ping", "-c", "1", "-W", secs, ip.String())
+	ctx, cancel := context.WithTimeout(ctx, peerCheckTimeout+time.Second)
+	defer cancel()
+	out, err := m.sys.Output(ctx, cmd[0], cmd[1:]...)
+	if err != nil {
+		return 0, fmt.Errorf("no reply from %s: %w", ip, err)
+	}
+	match := pingTimeRE.FindStringSubmatch(out)
+	if match == nil {
+		return 0, fmt.Errorf("no reply from %s", ip)
+	}
+	ms, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected ping output %q", match[0])
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+var icmpSeq atomic.Uint32
+
+// pingICMP sends an ICMP (or ICMPv6) echo request over a raw socket and waits
+// for the matching reply.
+func (m *Manager) pingICMP(ctx context.Context, ip net.IP) (time.Duration, error) {
+	network, laddr, echoType, replyType := "ip4:icmp", "0.0.0.0", byte(8), byte(0)
+	if ip.To4() == nil {
+		// The kernel fills in the ICMPv6 checksum on raw sockets.
+		network, laddr, echoType, replyType = "ip6:ipv6-icmp", "::", 128, 129
+	}
+	conn, err := m.net.ListenPacket(ctx, network, laddr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	id := uint16(os.Getpid())
+	seq := uint16(icmpSeq.Add(1))
+	msg := []byte{echoType, 0, 0, 0, 0, 0, 0, 0, 'b', 'p'}
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	if echoType == 8 {
+		binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	}
+
+	deadline := time.Now().Add(peerCheckTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if _, err := conn.WriteTo(msg, &net.IPAddr{IP: ip}); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return 0, fmt.Errorf("no reply from %s within %s", ip, peerCheckTimeout)
+			}
+			return 0, err
+		}
+		if a, ok := from.(*net.IPAddr); !ok || !a.IP.Equal(ip) || n < 8 {
+			continue
+		}
+		if buf[0] == replyType && binary.BigEndian.Uint16(buf[4:]) == id && binary.BigEndian.Uint16(buf[6:]) == seq {
+			return time.Since(start), nil
+		}
+	}
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
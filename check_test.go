@@ -0,0 +1,121 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPeersWithPing(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sys := &FakeSystem{
+		Commands: map[string]bool{"ping": true},
+		Outputs: map[string]string{
+			"ping -c 1 -W 2 69.0.1.2": "64 bytes from 69.0.1.2: icmp_seq=1 ttl=64 time=12.5 ms",
+		},
+		Errors: map[string]error{
+			"ping -c 1 -W 2 69.0.1.3": errors.New("exit status 1"),
+		},
+	}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointHost: "203.0.113.7"}, Dependencies{System: sys, Keys: &fakeKeys{}})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	for _, peer := range []string{"laptop", "phone"} {
+		if _, err := mgr.AddPeer(ctx, "home", peer); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+	}
+
+	checks, err := mgr.CheckPeers(ctx, "home")
+	if err != nil {
+		t.Fatalf("CheckPeers returned error: %v", err)
+	}
+	want := []PeerCheck{
+		{Peer: "laptop", Address: "69.0.1.2", Reachable: true, RTT: 12500 * time.Microsecond},
+		{Peer: "phone", Address: "69.0.1.3"},
+	}
+	if len(checks) != len(want) {
+		t.Fatalf("checks = %#v", checks)
+	}
+	for i, c := range checks {
+		if c.Peer != want[i].Peer || c.Address != want[i].Address || c.Reachable != want[i].Reachable || c.RTT != want[i].RTT {
+			t.Fatalf("check %d = %#v, want %#v", i, c, want[i])
+		}
+	}
+	if !strings.Contains(checks[1].Error, "no reply from 69.0.1.3") {
+		t.Fatalf("unexpected error for phone: %q", checks[1].Error)
+	}
+
+	if _, err := mgr.CheckPeers(ctx, "work"); !errors.Is(err, ErrVPNNotFound) {
+		t.Fatalf("expected ErrVPNNotFound, got %v", err)
+	}
+	sys.Commands = nil
+	if _, err := mgr.CheckPeers(ctx, "home"); err == nil || !strings.Contains(err.Error(), "ping command") {
+		t.Fatalf("expected missing ping error, got %v", err)
+	}
+}
+
+// echoPacketConn answers every ICMP echo request with a reply from the target.
+type echoPacketConn struct {
+	net.PacketConn
+	reply []byte
+	from  net.Addr
+}
+
+func (c *echoPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if icmpChecksum(b) != 0 {
+		return 0, errors.New("bad checksum")
+	}
+	c.reply = append([]byte{0, 0, 0, 0}, b[4:]...)
+	c.from = addr
+	return len(b), nil
+}
+
+func (c *echoPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return copy(b, c.reply), c.from, nil
+}
+
+func (c *echoPacketConn) SetDeadline(time.Time) error { return nil }
+func (c *echoPacketConn) Close() error                { return nil }
+
+type icmpNetwork struct{ fakeNetwork }
+
+func (icmpNetwork) ListenPacket(_ context.Context, network, _ string) (net.PacketConn, error) {
+	if network != "ip4:icmp" {
+		return nil, errors.New("unexpected network " + network)
+	}
+	return &echoPacketConn{}, nil
+}
+
+func TestCheckPeersWithRawICMP(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sys := &FakeSystem{RootValue: true}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointHost: "203.0.113.7"}, Dependencies{System: sys, Keys: &fakeKeys{}, Net: icmpNetwork{}})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+
+	checks, err := mgr.CheckPeers(ctx, "home")
+	if err != nil {
+		t.Fatalf("CheckPeers returned error: %v", err)
+	}
+	if len(checks) != 1 || !checks[0].Reachable || checks[0].Error != "" {
+		t.Fatalf("checks = %#v", checks)
+	}
+	for _, call := range sys.Calls() {
+		if strings.HasPrefix(call, "ping ") {
+			t.Fatalf("ping was run as root: %v", sys.Calls())
+		}
+	}
+}
@@ -3,12 +3,16 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tavocg/bypasser"
 )
@@ -16,10 +20,17 @@ import (
 type actionKind string
 
 const (
-	actionNone   actionKind = ""
-	actionAdd    actionKind = "add"
-	actionDelete actionKind = "del"
-	actionServer actionKind = "server"
+	actionNone    actionKind = ""
+	actionAdd     actionKind = "add"
+	actionDelete  actionKind = "del"
+	actionServer  actionKind = "server"
+	actionList    actionKind = "list"
+	actionStatus  actionKind = "status"
+	actionShow    actionKind = "show"
+	actionCheck   actionKind = "check"
+	actionMetrics actionKind = "metrics"
+	actionNext    actionKind = "next"
+	actionDump    actionKind = "dump"
 )
 
 type targetKind string
@@ -30,10 +41,25 @@ const (
 )
 
 type options struct {
-	Action actionKind
-	Target targetKind
-	Name   string
-	Help   bool
+	Action    actionKind
+	Target    targetKind
+	Name      string
+	Help      bool
+	QR        bool
+	Redact    bool
+	DryRun    bool
+	NoRuntime bool
+	JSON      bool
+	Port      int
+	Batch     bool
+	Force     bool
+	Verbose   bool
+	Config    string
+	// Dir replaces WireGuardDir and turns runtime helpers off, for
+	// generating configs on one machine and copying them to a server.
+	Dir string
+	// Legacy is the deprecated dash flag that selected Action, if any.
+	Legacy string
 }
 
 func main() {
@@ -41,34 +67,110 @@ func main() {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error:", err)
 		printUsage(os.Stderr)
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 	if opts.Help || opts.Action == actionNone {
 		printUsage(os.Stdout)
 		return
 	}
 
-	mgr := bypasser.NewManager(bypasser.DefaultConfig(), bypasser.Dependencies{})
+	cfg := bypasser.DefaultConfig()
+	if opts.Config != "" {
+		var err error
+		cfg, err = bypasser.LoadConfig(opts.Config)
+		exitOnErr(err)
+	}
+	cfg.DryRun = opts.DryRun
+	if opts.Dir != "" {
+		cfg.WireGuardDir = opts.Dir
+		cfg.NoRuntime = true
+	}
+	if opts.NoRuntime {
+		cfg.NoRuntime = true
+	}
+	var deps bypasser.Dependencies
+	if opts.Verbose {
+		deps.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	cmd := lookupCommand(opts.Action)
+	// Only commands that generate or derive keys probe for a key generator.
+	var mgr *bypasser.Manager
+	if cmd.keys {
+		mgr, err = bypasser.NewManagerWithError(cfg, deps)
+	} else {
+		err = cfg.Validate()
+		mgr = bypasser.NewManager(cfg, deps)
+	}
+	exitOnErr(err)
+	if opts.Legacy != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s is deprecated and will be removed in the next release; use 'bp %s ...' instead\n", opts.Legacy, opts.Action)
+	}
 	ctx := context.Background()
 	reader := bufio.NewReader(os.Stdin)
+	cmd.run(ctx, mgr, reader, opts)
+}
 
-	switch opts.Action {
-	case actionServer:
-		rep, err := mgr.SetupServer(ctx)
-		exitOnErr(err)
-		fmt.Println("Server base files prepared (directories + forwarding sysctl config).")
-		printReport(rep)
-		return
-	case actionAdd:
-		handleAdd(ctx, mgr, reader, opts)
-		return
-	case actionDelete:
-		handleDelete(ctx, mgr, reader, opts)
+// command is one verb of the CLI. Every verb is reachable both as a
+// subcommand word (bp add peer home:laptop) and through its legacy dash
+// flags (bp -a -n home:laptop), which are kept for one release.
+type command struct {
+	action actionKind
+	words  []string
+	flags  []string
+	// keys marks verbs that generate or derive WireGuard keys.
+	keys bool
+	run  func(context.Context, *bypasser.Manager, *bufio.Reader, options)
+}
+
+var commands = []command{
+	{action: actionAdd, words: []string{"add"}, flags: []string{"-a", "-add", "--add"}, keys: true, run: handleAdd},
+	{action: actionDelete, words: []string{"del", "delete"}, flags: []string{"-d", "-del", "--del"}, keys: true, run: handleDelete},
+	{action: actionList, words: []string{"list", "ls"}, flags: []string{"-l", "-list", "--list"}, run: handleList},
+	{action: actionStatus, words: []string{"status"}, flags: []string{"-status", "--status"}, run: handleStatus},
+	{action: actionShow, words: []string{"show"}, flags: []string{"-show", "--show"}, run: handleShow},
+	{action: actionCheck, words: []string{"check"}, flags: []string{"-check", "--check"}, run: handleCheck},
+	{action: actionServer, words: []string{"server"}, flags: []string{"-server", "--server"}, run: handleServer},
+	{action: actionMetrics, words: []string{"metrics"}, flags: []string{"-metrics", "--metrics"}, run: handleMetrics},
+	{action: actionNext, words: []string{"next"}, flags: []string{"-next", "--next"}, run: handleNext},
+	{action: actionDump, words: []string{"dump"}, flags: []string{"-dump", "--dump"}, run: handleDump},
+}
+
+func lookupCommand(a actionKind) command {
+	for _, c := range commands {
+		if c.action == a {
+			return c
+		}
+	}
+	panic("unknown action " + string(a))
+}
+
+func commandForWord(word string) (command, bool) {
+	for _, c := range commands {
+		if slices.Contains(c.words, word) {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func commandForFlag(flag string) (command, bool) {
+	for _, c := range commands {
+		if slices.Contains(c.flags, flag) {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func handleServer(ctx context.Context, mgr *bypasser.Manager, _ *bufio.Reader, opts options) {
+	rep, err := mgr.SetupServer(ctx)
+	exitOnErr(err)
+	if opts.JSON {
+		printJSON(rep)
 		return
-	default:
-		fmt.Fprintln(os.Stderr, "Error: unsupported action")
-		os.Exit(2)
 	}
+	fmt.Println("Server base files prepared (directories + forwarding sysctl config).")
+	printReport(rep)
 }
 
 func handleAdd(ctx context.Context, mgr *bypasser.Manager, reader *bufio.Reader, opts options) {
@@ -80,24 +182,47 @@ func handleAdd(ctx context.Context, mgr *bypasser.Manager, reader *bufio.Reader,
 		} else {
 			exitOnErr(bypasser.ValidateName("vpn", name))
 		}
-		res, err := mgr.AddVPN(ctx, name)
+		res, err := mgr.AddVPNWithOptions(ctx, name, bypasser.AddVPNOptions{Port: opts.Port})
+		if errors.Is(err, bypasser.ErrVPNExists) && !opts.JSON {
+			fmt.Fprintf(os.Stderr, "Config: %s\n", res.ConfigPath)
+		}
 		exitOnErr(err)
+		if opts.JSON {
+			printJSON(res)
+			return
+		}
 		fmt.Printf("Created VPN %q (%s)\n", res.VPN, res.Interface)
 		fmt.Printf("Config: %s\n", res.ConfigPath)
 		printReport(res.Report)
 	case targetPeer:
 		ref := mustResolvePeerRefForAdd(reader, opts.Name)
 		res, err := mgr.AddPeer(ctx, ref.VPN, ref.Peer)
+		if errors.Is(err, bypasser.ErrPeerExists) && !opts.JSON {
+			fmt.Fprintf(os.Stderr, "Client config: %s\n", res.PeerConfigPath)
+		}
 		exitOnErr(err)
+		if opts.Redact {
+			res.PeerConfig = bypasser.RedactConfig(res.PeerConfig)
+		}
+		if opts.JSON {
+			printJSON(res)
+			return
+		}
 		fmt.Printf("Created peer %q\n", res.PeerRef.String())
 		fmt.Printf("Client config: %s\n", res.PeerConfigPath)
 		printReport(res.Report)
 		fmt.Println()
 		fmt.Println("Client configuration:")
 		fmt.Println(res.PeerConfig)
+		if opts.QR {
+			code, err := res.QRCode()
+			exitOnErr(err)
+			fmt.Println("Client configuration QR code:")
+			fmt.Println(code)
+		}
 	default:
 		fmt.Fprintln(os.Stderr, "Error: unsupported target")
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 }
 
@@ -112,23 +237,217 @@ func handleDelete(ctx context.Context, mgr *bypasser.Manager, reader *bufio.Read
 		} else {
 			exitOnErr(bypasser.ValidateName("vpn", name))
 		}
-		rep, err := mgr.DeleteVPN(ctx, name)
+		rep, err := mgr.DeleteVPNWithOptions(ctx, name, bypasser.DeleteVPNOptions{Cascade: opts.Force})
 		exitOnErr(err)
+		if opts.JSON {
+			printJSON(rep)
+			return
+		}
 		fmt.Printf("Deleted VPN %q\n", name)
 		printReport(rep)
 	case targetPeer:
-		ref, err := resolvePeerRefForDelete(reader, mgr, opts.Name)
+		ref, err := resolvePeerRef(reader, mgr, opts.Name, "delete")
 		exitOnErr(err)
 		rep, err := mgr.DeletePeer(ctx, ref.VPN, ref.Peer)
 		exitOnErr(err)
+		if opts.JSON {
+			printJSON(rep)
+			return
+		}
 		fmt.Printf("Deleted peer %q\n", ref.String())
 		printReport(rep)
 	default:
 		fmt.Fprintln(os.Stderr, "Error: unsupported target")
-		os.Exit(2)
+		os.Exit(exitUsage)
+	}
+}
+
+func handleList(_ context.Context, mgr *bypasser.Manager, _ *bufio.Reader, opts options) {
+	all, err := mgr.ListAll()
+	exitOnErr(err)
+	if opts.JSON {
+		printJSON(all)
+		return
+	}
+
+	switch opts.Target {
+	case targetVPN:
+		found := false
+		for _, s := range all {
+			if s.Orphaned {
+				continue
+			}
+			if !found {
+				fmt.Println("VPNs:")
+				found = true
+			}
+			fmt.Printf("  - %s (%s) port %d, address %s, %d peer(s)\n", s.Name, s.Interface, s.ListenPort, s.Address, len(s.Peers))
+		}
+		if !found {
+			fmt.Println("No VPNs found.")
+		}
+	case targetPeer:
+		found := false
+		for _, s := range all {
+			if len(s.Peers) == 0 {
+				continue
+			}
+			found = true
+			if s.Orphaned {
+				fmt.Printf("%s (orphaned: vpn config missing):\n", s.Name)
+			} else {
+				fmt.Printf("%s:\n", s.Name)
+			}
+			for _, peer := range s.Peers {
+				addr, err := mgr.PeerAddress(s.Name, peer)
+				if err != nil {
+					addr = "unknown address"
+				}
+				fmt.Printf("  - %s %s\n", peer, addr)
+			}
+		}
+		if !found {
+			fmt.Println("No peers found.")
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "Error: unsupported target")
+		os.Exit(exitUsage)
+	}
+}
+
+func handleStatus(ctx context.Context, mgr *bypasser.Manager, reader *bufio.Reader, opts options) {
+	name := opts.Name
+	if name == "" {
+		name = promptValidatedName(reader, "vpn")
+	} else {
+		exitOnErr(bypasser.ValidateName("vpn", name))
+	}
+	st, err := mgr.Status(ctx, name)
+	exitOnErr(err)
+	if opts.JSON {
+		printJSON(st)
+		return
+	}
+
+	if !st.Up {
+		fmt.Printf("VPN %q (%s) is down: %s\n", st.VPN, st.Interface, st.Message)
+	} else {
+		fmt.Printf("VPN %q (%s) is up, port %d\n", st.VPN, st.Interface, st.ListenPort)
+	}
+	if len(st.Peers) == 0 {
+		fmt.Println("No peers found.")
+		return
+	}
+	now := time.Now()
+	for _, p := range st.Peers {
+		name := p.Peer
+		if name == "" {
+			name = "(unknown " + p.PublicKey + ")"
+		}
+		handshake := "never"
+		if !p.LatestHandshake.IsZero() {
+			handshake = now.Sub(p.LatestHandshake).Round(time.Second).String() + " ago"
+		}
+		fmt.Printf("  - %s %s handshake %s, rx %s, tx %s\n", name, p.AllowedIPs, handshake, formatBytes(p.TransferRx), formatBytes(p.TransferTx))
 	}
 }
 
+func handleShow(_ context.Context, mgr *bypasser.Manager, reader *bufio.Reader, opts options) {
+	ref, err := resolvePeerRef(reader, mgr, opts.Name, "show")
+	exitOnErr(err)
+	conf, err := mgr.PeerConfig(ref.VPN, ref.Peer)
+	exitOnErr(err)
+	if opts.Redact {
+		conf = bypasser.RedactConfig(conf)
+	}
+	if opts.JSON {
+		printJSON(struct {
+			Peer   bypasser.PeerRef `json:"peer"`
+			Config string           `json:"config"`
+		}{ref, conf})
+		return
+	}
+	fmt.Print(conf)
+	if opts.QR {
+		code, err := bypasser.EncodeQR(conf)
+		exitOnErr(err)
+		fmt.Println()
+		fmt.Println("Client configuration QR code:")
+		fmt.Println(code)
+	}
+}
+
+func handleCheck(ctx context.Context, mgr *bypasser.Manager, reader *bufio.Reader, opts options) {
+	name := opts.Name
+	if name == "" {
+		name = promptValidatedName(reader, "vpn")
+	} else {
+		exitOnErr(bypasser.ValidateName("vpn", name))
+	}
+	checks, err := mgr.CheckPeers(ctx, name)
+	exitOnErr(err)
+	down := 0
+	for _, c := range checks {
+		if !c.Reachable {
+			down++
+		}
+	}
+	if opts.JSON {
+		printJSON(checks)
+	} else if len(checks) == 0 {
+		fmt.Println("No peers found.")
+	} else {
+		for _, c := range checks {
+			if c.Reachable {
+				fmt.Printf("  - %s %s reachable, rtt %s\n", c.Peer, c.Address, c.RTT.Round(time.Microsecond))
+			} else {
+				fmt.Printf("  - %s %s unreachable: %s\n", c.Peer, c.Address, c.Error)
+			}
+		}
+	}
+	if down > 0 {
+		os.Exit(exitError)
+	}
+}
+
+func handleMetrics(ctx context.Context, mgr *bypasser.Manager, _ *bufio.Reader, _ options) {
+	out, err := mgr.PrometheusMetrics(ctx)
+	exitOnErr(err)
+	fmt.Print(out)
+}
+
+func handleNext(_ context.Context, mgr *bypasser.Manager, _ *bufio.Reader, opts options) {
+	port, octet, err := mgr.NextAllocations()
+	exitOnErr(err)
+	if opts.JSON {
+		printJSON(struct {
+			ListenPort  int `json:"listen_port"`
+			SubnetOctet int `json:"subnet_octet"`
+		}{port, octet})
+		return
+	}
+	fmt.Printf("Next vpn: port %d, subnet octet %d\n", port, octet)
+}
+
+func handleDump(_ context.Context, mgr *bypasser.Manager, _ *bufio.Reader, _ options) {
+	out, err := mgr.DumpServerConfigs(true)
+	exitOnErr(err)
+	fmt.Print(out)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func parseArgs(args []string) (options, error) {
 	opts := options{Target: targetPeer}
 
@@ -137,18 +456,28 @@ func parseArgs(args []string) (options, error) {
 		switch {
 		case arg == "-h" || arg == "--help" || arg == "help":
 			opts.Help = true
-		case arg == "-a" || arg == "-add" || arg == "--add":
-			if err := setAction(&opts, actionAdd); err != nil {
-				return opts, err
-			}
-		case arg == "-d" || arg == "-del" || arg == "--del":
-			if err := setAction(&opts, actionDelete); err != nil {
-				return opts, err
-			}
-		case arg == "-server" || arg == "--server":
-			if err := setAction(&opts, actionServer); err != nil {
+		case isActionFlag(arg):
+			c, _ := commandForFlag(arg)
+			if err := setAction(&opts, c.action); err != nil {
 				return opts, err
 			}
+			opts.Legacy = arg
+		case arg == "-qr" || arg == "--qr":
+			opts.QR = true
+		case arg == "-redact" || arg == "--redact":
+			opts.Redact = true
+		case arg == "-dry-run" || arg == "--dry-run":
+			opts.DryRun = true
+		case arg == "-no-runtime" || arg == "--no-runtime":
+			opts.NoRuntime = true
+		case arg == "-json" || arg == "--json":
+			opts.JSON = true
+		case arg == "-batch" || arg == "--batch" || arg == "-non-interactive" || arg == "--non-interactive":
+			opts.Batch = true
+		case arg == "-v" || arg == "-verbose" || arg == "--verbose":
+			opts.Verbose = true
+		case arg == "-force" || arg == "--force":
+			opts.Force = true
 		case arg == "vpn":
 			opts.Target = targetVPN
 		case arg == "peer":
@@ -159,6 +488,39 @@ func parseArgs(args []string) (options, error) {
 			}
 			i++
 			opts.Name = args[i]
+		case arg == "-config" || arg == "--config":
+			if i+1 >= len(args) {
+				return opts, errors.New("missing value for -config")
+			}
+			i++
+			opts.Config = args[i]
+		case strings.HasPrefix(arg, "-config=") || strings.HasPrefix(arg, "--config="):
+			_, opts.Config, _ = strings.Cut(arg, "=")
+		case arg == "-dir" || arg == "--dir":
+			if i+1 >= len(args) {
+				return opts, errors.New("missing value for -dir")
+			}
+			i++
+			opts.Dir = args[i]
+		case strings.HasPrefix(arg, "-dir=") || strings.HasPrefix(arg, "--dir="):
+			_, opts.Dir, _ = strings.Cut(arg, "=")
+		case arg == "-port" || arg == "--port":
+			if i+1 >= len(args) {
+				return opts, errors.New("missing value for -port")
+			}
+			i++
+			port, err := parsePort(args[i])
+			if err != nil {
+				return opts, err
+			}
+			opts.Port = port
+		case strings.HasPrefix(arg, "-port=") || strings.HasPrefix(arg, "--port="):
+			_, v, _ := strings.Cut(arg, "=")
+			port, err := parsePort(v)
+			if err != nil {
+				return opts, err
+			}
+			opts.Port = port
 		case strings.HasPrefix(arg, "-n="):
 			opts.Name = strings.TrimPrefix(arg, "-n=")
 		case strings.HasPrefix(arg, "-n:"):
@@ -166,6 +528,10 @@ func parseArgs(args []string) (options, error) {
 		case strings.HasPrefix(arg, "-"):
 			return opts, fmt.Errorf("unknown flag %q", arg)
 		default:
+			if c, ok := commandForWord(arg); ok && opts.Action == actionNone {
+				opts.Action = c.action
+				continue
+			}
 			if opts.Name != "" {
 				return opts, fmt.Errorf("unexpected extra argument %q", arg)
 			}
@@ -173,12 +539,58 @@ func parseArgs(args []string) (options, error) {
 		}
 	}
 
-	if opts.Action == actionServer && opts.Name != "" {
-		return opts, errors.New("-server does not take a name")
+	if (opts.Action == actionServer || opts.Action == actionList || opts.Action == actionMetrics || opts.Action == actionNext || opts.Action == actionDump) && opts.Name != "" {
+		return opts, fmt.Errorf("%s does not take a name", opts.Action)
+	}
+	if opts.Action == actionShow && opts.Target != targetPeer {
+		return opts, errors.New("show only supports peers")
+	}
+	if (opts.Action == actionNext || opts.Action == actionDump) && opts.Target != targetVPN {
+		return opts, fmt.Errorf("%s only supports vpns", opts.Action)
+	}
+	if opts.QR && !((opts.Action == actionAdd || opts.Action == actionShow) && opts.Target == targetPeer) {
+		return opts, errors.New("-qr is only supported when adding or showing a peer")
+	}
+	if opts.Batch && opts.Name == "" {
+		switch opts.Action {
+		case actionAdd, actionDelete, actionStatus, actionShow, actionCheck:
+			return opts, fmt.Errorf("a name is required with -batch")
+		}
+	}
+	if opts.Force && (opts.Action != actionDelete || opts.Target != targetVPN) {
+		return opts, errors.New("-force is only supported when deleting a vpn")
+	}
+	if opts.Port != 0 && (opts.Action != actionAdd || opts.Target != targetVPN) {
+		return opts, errors.New("-port is only supported when adding a vpn")
+	}
+	if opts.Dir != "" && opts.Action == actionServer {
+		return opts, errors.New("-dir cannot be combined with server, which prepares the local host")
+	}
+	if opts.Redact && !((opts.Action == actionAdd || opts.Action == actionShow) && opts.Target == targetPeer) {
+		return opts, errors.New("-redact is only supported when adding or showing a peer")
+	}
+	if opts.Redact && opts.QR {
+		return opts, errors.New("-redact cannot be combined with -qr, which encodes the secrets")
+	}
+	if opts.QR && opts.JSON {
+		return opts, errors.New("-qr cannot be combined with -json")
 	}
 	return opts, nil
 }
 
+func parsePort(raw string) (int, error) {
+	port, err := strconv.Atoi(raw)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", raw)
+	}
+	return port, nil
+}
+
+func isActionFlag(arg string) bool {
+	_, ok := commandForFlag(arg)
+	return ok
+}
+
 func setAction(opts *options, a actionKind) error {
 	if opts.Action != actionNone && opts.Action != a {
 		return fmt.Errorf("conflicting actions %q and %q", opts.Action, a)
@@ -206,11 +618,11 @@ func mustResolvePeerRefForAdd(reader *bufio.Reader, raw string) bypasser.PeerRef
 	}
 }
 
-func resolvePeerRefForDelete(reader *bufio.Reader, mgr *bypasser.Manager, raw string) (bypasser.PeerRef, error) {
+func resolvePeerRef(reader *bufio.Reader, mgr *bypasser.Manager, raw, verb string) (bypasser.PeerRef, error) {
 	if raw != "" {
 		return bypasser.ParsePeerRef(raw)
 	}
-	return selectPeer(reader, mgr)
+	return selectPeer(reader, mgr, verb)
 }
 
 func promptValidatedName(reader *bufio.Reader, kind string) string {
@@ -254,7 +666,7 @@ func selectVPN(reader *bufio.Reader, mgr *bypasser.Manager) (string, error) {
 	}
 }
 
-func selectPeer(reader *bufio.Reader, mgr *bypasser.Manager) (bypasser.PeerRef, error) {
+func selectPeer(reader *bufio.Reader, mgr *bypasser.Manager, verb string) (bypasser.PeerRef, error) {
 	peers, err := mgr.ListPeers()
 	if err != nil {
 		return bypasser.PeerRef{}, err
@@ -262,7 +674,7 @@ func selectPeer(reader *bufio.Reader, mgr *bypasser.Manager) (bypasser.PeerRef,
 	if len(peers) == 0 {
 		return bypasser.PeerRef{}, errors.New("no peers found")
 	}
-	fmt.Println("Select peer to delete:")
+	fmt.Printf("Select peer to %s:\n", verb)
 	for i, p := range peers {
 		fmt.Printf("  %d. %s\n", i+1, p.String())
 	}
@@ -315,6 +727,8 @@ func printReport(rep bypasser.Report) {
 			switch a.Status {
 			case "executed":
 				fmt.Printf("  - executed: %s (%s)\n", a.Command, a.Description)
+			case "planned":
+				fmt.Printf("  - planned: %s (%s)\n", a.Command, a.Description)
 			default:
 				msg := a.Message
 				if msg == "" {
@@ -326,24 +740,89 @@ func printReport(rep bypasser.Report) {
 	}
 }
 
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	exitOnErr(enc.Encode(v))
+}
+
 func printUsage(w *os.File) {
 	fmt.Fprintln(w, "Usage:")
-	fmt.Fprintln(w, "  bp [-a|-add|-d|-del|-server] [vpn|peer] [-n name]")
+	fmt.Fprintln(w, "  bp <add|del|list|status|show|check|metrics|next|dump|server> [vpn|peer] [name] [-port n] [-qr] [-redact] [-force] [-dry-run] [-no-runtime] [-json] [-batch] [-v] [-config file] [-dir path]")
 	fmt.Fprintln(w, "  If target is omitted, 'peer' is assumed.")
-	fmt.Fprintln(w, "  For peer operations, name must be 'vpn:peer'.")
+	fmt.Fprintln(w, "  For peer operations, name must be 'vpn:peer'; it may also be given as -n name.")
+	fmt.Fprintln(w, "  The dash forms (-a|-add, -d|-del, -l|-list, -status, -show, -check, -server, -metrics, -next, -dump) still work but are deprecated.")
+	fmt.Fprintln(w, "  status shows live handshakes and transfer per peer of a vpn (name is the vpn).")
+	fmt.Fprintln(w, "  show reprints an existing peer's client config (combine with -qr for a QR code).")
+	fmt.Fprintln(w, "  check pings each peer's tunnel address of a vpn and exits 1 if any is unreachable.")
+	fmt.Fprintln(w, "  metrics prints Prometheus gauges (vpns, peers, ports, handshake ages) for a textfile collector.")
+	fmt.Fprintln(w, "  next vpn prints the port and subnet octet the next 'add vpn' would assign, without creating anything.")
+	fmt.Fprintln(w, "  dump vpn prints every vpn config under a header, with private and preshared keys redacted, for review.")
+	fmt.Fprintln(w, "  -port pins the ListenPort of a new vpn instead of auto-assigning one.")
+	fmt.Fprintln(w, "  -qr prints the peer's client config as a QR code.")
+	fmt.Fprintln(w, "  -redact masks the keys in the printed client config; the file written keeps the real ones.")
+	fmt.Fprintln(w, "  -force also deletes a vpn's peer files when deleting the vpn.")
+	fmt.Fprintln(w, "  -dry-run reports planned file changes and commands without applying them.")
+	fmt.Fprintln(w, "  -no-runtime writes files but never runs systemctl, wg-quick or sysctl.")
+	fmt.Fprintln(w, "  -json prints the result as JSON instead of text.")
+	fmt.Fprintln(w, "  -batch never prompts; a name is then mandatory for add, del, status, show and check.")
+	fmt.Fprintln(w, "  -config reads settings from a TOML file; BP_* environment variables still take precedence.")
+	fmt.Fprintln(w, "  -dir writes configs under path instead of BP_WG_DIR and implies -no-runtime, e.g. to generate them on a workstation.")
+	fmt.Fprintln(w, "  -v traces interface and endpoint detection to stderr.")
 	fmt.Fprintln(w)
 	fmt.Fprintln(w, "Examples:")
-	fmt.Fprintln(w, "  bp -server")
-	fmt.Fprintln(w, "  bp -a vpn -n home")
-	fmt.Fprintln(w, "  bp -a -n home:laptop")
-	fmt.Fprintln(w, "  bp -d vpn")
-	fmt.Fprintln(w, "  bp -d")
+	fmt.Fprintln(w, "  bp server")
+	fmt.Fprintln(w, "  bp -config /etc/bp.toml add vpn home")
+	fmt.Fprintln(w, "  bp add vpn home")
+	fmt.Fprintln(w, "  bp add vpn home -dry-run")
+	fmt.Fprintln(w, "  bp add vpn home -no-runtime")
+	fmt.Fprintln(w, "  bp add peer home:laptop -dir ./out")
+	fmt.Fprintln(w, "  bp add vpn office -port 55150")
+	fmt.Fprintln(w, "  bp add peer home:laptop")
+	fmt.Fprintln(w, "  bp add peer home:laptop -qr")
+	fmt.Fprintln(w, "  bp add peer home:laptop -v")
+	fmt.Fprintln(w, "  bp list vpn")
+	fmt.Fprintln(w, "  bp list")
+	fmt.Fprintln(w, "  bp status vpn home")
+	fmt.Fprintln(w, "  bp check vpn home -json")
+	fmt.Fprintln(w, "  bp show peer home:laptop -qr")
+	fmt.Fprintln(w, "  bp add peer home:laptop -redact")
+	fmt.Fprintln(w, "  bp metrics > /var/lib/node_exporter/bp.prom")
+	fmt.Fprintln(w, "  bp next vpn -json")
+	fmt.Fprintln(w, "  bp dump vpn > audit.txt")
+	fmt.Fprintln(w, "  bp del vpn")
+	fmt.Fprintln(w, "  bp del vpn home -force")
+	fmt.Fprintln(w, "  bp del")
 }
 
+const (
+	exitError         = 1
+	exitUsage         = 2
+	exitAlreadyExists = 3
+	exitNotFound      = 4
+	exitValidation    = 5
+	exitPermission    = 6
+)
+
 func exitOnErr(err error) {
 	if err == nil {
 		return
 	}
 	fmt.Fprintln(os.Stderr, "Error:", err)
-	os.Exit(1)
+	os.Exit(exitCode(err))
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, bypasser.ErrAlreadyExists):
+		return exitAlreadyExists
+	case errors.Is(err, bypasser.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, bypasser.ErrValidation):
+		return exitValidation
+	case errors.Is(err, bypasser.ErrPermission):
+		return exitPermission
+	default:
+		return exitError
+	}
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		args []string
+		want options
+	}{
+		{[]string{"add", "vpn", "home"}, options{Action: actionAdd, Target: targetVPN, Name: "home"}},
+		{[]string{"-a", "vpn", "-n", "home"}, options{Action: actionAdd, Target: targetVPN, Name: "home", Legacy: "-a"}},
+		{[]string{"--add", "vpn", "-n=home"}, options{Action: actionAdd, Target: targetVPN, Name: "home", Legacy: "--add"}},
+		{[]string{"add", "peer", "home:laptop", "-qr"}, options{Action: actionAdd, Target: targetPeer, Name: "home:laptop", QR: true}},
+		{[]string{"-add", "-n:home:laptop"}, options{Action: actionAdd, Target: targetPeer, Name: "home:laptop", Legacy: "-add"}},
+		{[]string{"delete", "vpn", "home", "-force"}, options{Action: actionDelete, Target: targetVPN, Name: "home", Force: true}},
+		{[]string{"-d", "vpn", "home", "--force"}, options{Action: actionDelete, Target: targetVPN, Name: "home", Force: true, Legacy: "-d"}},
+		{[]string{"ls"}, options{Action: actionList, Target: targetPeer}},
+		{[]string{"-l"}, options{Action: actionList, Target: targetPeer, Legacy: "-l"}},
+		{[]string{"check", "vpn", "home", "-json"}, options{Action: actionCheck, Target: targetVPN, Name: "home", JSON: true}},
+		{[]string{"-check", "vpn", "-n", "home"}, options{Action: actionCheck, Target: targetVPN, Name: "home", Legacy: "-check"}},
+		{[]string{"next", "vpn"}, options{Action: actionNext, Target: targetVPN}},
+		{[]string{"-next", "vpn"}, options{Action: actionNext, Target: targetVPN, Legacy: "-next"}},
+		{[]string{"-metrics"}, options{Action: actionMetrics, Target: targetPeer, Legacy: "-metrics"}},
+		{[]string{"-dump", "vpn"}, options{Action: actionDump, Target: targetVPN, Legacy: "-dump"}},
+		{[]string{"add", "vpn", "home", "-port=51820"}, options{Action: actionAdd, Target: targetVPN, Name: "home", Port: 51820}},
+		{[]string{"add", "vpn", "home", "--port", "51820"}, options{Action: actionAdd, Target: targetVPN, Name: "home", Port: 51820}},
+		{[]string{"list", "-config=/etc/bp.toml", "--dir=/tmp/wg"}, options{Action: actionList, Target: targetPeer, Config: "/etc/bp.toml", Dir: "/tmp/wg"}},
+		{[]string{"list", "--config", "/etc/bp.toml", "-dir", "/tmp/wg"}, options{Action: actionList, Target: targetPeer, Config: "/etc/bp.toml", Dir: "/tmp/wg"}},
+		{[]string{"show", "home:laptop", "-redact"}, options{Action: actionShow, Target: targetPeer, Name: "home:laptop", Redact: true}},
+		{[]string{"help"}, options{Target: targetPeer, Help: true}},
+	}
+	for _, tt := range tests {
+		got, err := parseArgs(tt.args)
+		if err != nil {
+			t.Fatalf("parseArgs(%q) returned error: %v", tt.args, err)
+		}
+		if got != tt.want {
+			t.Fatalf("parseArgs(%q) = %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestParseArgsRejects(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"show", "home:laptop", "-redact", "-qr"}, "-redact cannot be combined with -qr"},
+		{[]string{"server", "-dir", "/tmp/wg"}, "-dir cannot be combined with server"},
+		{[]string{"-server", "--dir=/tmp/wg"}, "-dir cannot be combined with server"},
+		{[]string{"add", "peer", "home:laptop", "-qr", "-json"}, "-qr cannot be combined with -json"},
+		{[]string{"list", "-redact"}, "-redact is only supported"},
+		{[]string{"add", "-d"}, `conflicting actions "add" and "del"`},
+		{[]string{"-a", "-l"}, `conflicting actions "add" and "list"`},
+		{[]string{"del", "peer", "home:laptop", "-force"}, "-force is only supported"},
+		{[]string{"add", "peer", "home:laptop", "-port", "51820"}, "-port is only supported"},
+		{[]string{"add", "vpn", "home", "-port=0"}, `invalid port "0"`},
+		{[]string{"next", "peer"}, "next only supports vpns"},
+		{[]string{"list", "home"}, "list does not take a name"},
+		{[]string{"add", "vpn", "home", "work"}, `unexpected extra argument "work"`},
+		{[]string{"-n"}, "missing value for -n"},
+		{[]string{"list", "-config"}, "missing value for -config"},
+		{[]string{"-x"}, `unknown flag "-x"`},
+		{[]string{"del", "-batch"}, "a name is required with -batch"},
+	}
+	for _, tt := range tests {
+		_, err := parseArgs(tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Fatalf("parseArgs(%q): expected error containing %q, got %v", tt.args, tt.wantErr, err)
+		}
+	}
+}
@@ -1,49 +1,295 @@
 package bypasser
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	EndpointFamilyAuto = "auto"
+	EndpointFamilyV4   = "v4"
+	EndpointFamilyV6   = "v6"
+)
+
+// Endpoint sources for detecting the server's public address when
+// Config.EndpointHost is empty.
+const (
+	EndpointSourceLocal         = "local"
+	EndpointSourceCloudMetadata = "cloud-metadata"
+	EndpointSourceHTTP          = "http"
+)
+
+// Peer file layouts under Config.PeersSubdir.
+const (
+	PeerLayoutFlat   = "flat"   // peers/<interface>-<peer>.conf
+	PeerLayoutNested = "nested" // peers/<vpn>/<peer>.conf
 )
 
 type Config struct {
-	WireGuardDir    string
-	PeersSubdir     string
+	WireGuardDir string
+	PeersSubdir  string
+	// PeerLayout is PeerLayoutFlat (default) or PeerLayoutNested; see
+	// Manager.MigratePeerLayout to move existing peer files.
+	PeerLayout      string
 	InterfacePrefix string
-	SysctlFile      string
+	// InterfaceNameTemplate, when set, replaces InterfacePrefix: interfaces
+	// are named by executing it with InterfaceNameData, e.g. "wg{{.Index}}".
+	// Vpns are then recognised by their "# bp-managed:" header instead of the
+	// file name.
+	InterfaceNameTemplate string
+	SysctlFile            string
 
 	MinPort int
 	MaxPort int
+	// CheckPortInUse makes AddVPN skip ports that another process already has bound.
+	CheckPortInUse bool
+
+	// SubnetPrefix is one or two octets. With two ("69.0") each vpn gets a /24
+	// and peers a single host octet; with one ("10") each vpn gets a /16 and
+	// peers are numbered across the last two octets. An InterfaceMask of 0
+	// follows the prefix.
+	SubnetPrefix  string
+	InterfaceMask int
+	PeerMask      int
+
+	// IPv6Prefix enables dual-stack addressing; the vpn and host numbers are
+	// mirrored into it, e.g. fd00:6900 gives fd00:6900:<vpn>::<host>.
+	IPv6Prefix        string
+	IPv6InterfaceMask int
+	IPv6PeerMask      int
 
-	SubnetPrefix    string
-	InterfaceMask   int
-	PeerMask        int
 	PublicInterface string
 	EndpointHost    string
+	// EndpointHosts lists failover endpoints; client configs use EndpointHost
+	// (or the first entry when it is empty) and record every entry in a
+	// "# bp-endpoints:" comment for client tooling to rotate through.
+	EndpointHosts  []string
+	EndpointFamily string
+	// EndpointSource picks how the endpoint is detected: EndpointSourceLocal
+	// (the default) uses the outbound route and interface addresses, while
+	// EndpointSourceCloudMetadata first asks the AWS, GCP or Azure metadata
+	// service for the instance's public IPv4 address. EndpointSourceHTTP asks
+	// PublicIPService, a third party, when the local address is not public.
+	EndpointSource  string
+	PublicIPService string
+	// EndpointPort, when nonzero, replaces the vpn's ListenPort in client
+	// Endpoints, for servers behind a router forwarding a different port.
+	// One forwarded port reaches one vpn, so it requires MinPort == MaxPort.
+	EndpointPort int
+	NetNS        string
+	// InventoryFile, when set, is rewritten with WriteInventory after every
+	// method that changes a vpn or peer config.
+	InventoryFile string
+	// BindAddress restricts the listen port to one local address on
+	// multi-homed servers. WireGuard always binds every address, so this is
+	// enforced by a drop rule in PostUp for packets to any other address.
+	BindAddress string
+
+	// FirewallBackend picks the default PostUp/PostDown rules (FirewallIPTables
+	// or FirewallNFTables). PostUpTemplate/PostDownTemplate are text/template
+	// strings executed with FirewallRuleData that override those defaults.
+	FirewallBackend  string
+	PostUpTemplate   string
+	PostDownTemplate string
+
+	// ObfuscationEndpoint points client Endpoints at a local UDP obfuscation
+	// client (udp2raw, wstunnel) instead of the server, e.g. "127.0.0.1:51820";
+	// a host alone keeps the vpn's port. The real server endpoint and
+	// ObfuscationTool are kept in a "# bp-obfs:" comment for client tooling.
+	// ObfuscationPostUp/ObfuscationPostDown are FirewallRuleData templates
+	// appended to the server's PostUp/PostDown, e.g. to run the tunnel's
+	// server side.
+	ObfuscationEndpoint string
+	ObfuscationTool     string
+	ObfuscationPostUp   string
+	ObfuscationPostDown string
+
+	// MTU is written to server and client [Interface] sections; 0 omits it.
+	MTU int
+	// ServerTable and ClientTable set wg-quick's Table in the server and
+	// client [Interface] sections: "off" installs no routes, "auto" is
+	// wg-quick's default and a number picks a routing table. Empty omits it.
+	ServerTable string
+	ClientTable string
+	// FwMark marks the server interface's outgoing packets for policy
+	// routing or firewall rules; ClientFwMark does the same in client
+	// configs. Either accepts hex ("0xca6c") or decimal and is written in
+	// hex; empty omits it.
+	FwMark       string
+	ClientFwMark string
+
+	// DisablePresharedKey omits the generated PresharedKey of new peers from
+	// both the server block and the client config.
+	DisablePresharedKey bool
+	// RequireClientPublicKey keeps client private keys off the server: AddPeer
+	// requires AddPeerOptions.PublicKey instead of generating a key pair and
+	// RegenerateKeys is refused.
+	RequireClientPublicKey bool
+
+	// PersistentKeepalive is written to client configs; 0 omits the line.
+	// DefaultConfig sets 25.
+	PersistentKeepalive int
+	ClientDNS           []string
+	// ClientAllowedIPs is routed through the tunnel by clients, e.g. "0.0.0.0/0, ::/0"
+	// for a full tunnel; empty keeps the mesh CIDR only.
+	ClientAllowedIPs string
 
 	FilePerm os.FileMode
 	DirPerm  os.FileMode
+
+	NormalizeOnWrite bool
+	// ValidateBeforeWrite runs ValidateWGConfig on every WireGuard config
+	// before it is written and refuses malformed ones.
+	ValidateBeforeWrite bool
+	// StripSaveConfig drops "SaveConfig = true" from configs bp writes;
+	// otherwise wg-quick down rewrites them without bp's peer comments.
+	StripSaveConfig bool
+	DryRun          bool
+	// NoRuntime still writes files but never runs systemctl, wg-quick or
+	// sysctl; the commands are only reported as suggestions.
+	NoRuntime           bool
+	ConfigSizeWarnBytes int64
+	// LockTimeout bounds how long mutations wait for another bp process.
+	LockTimeout time.Duration
+	// CommandTimeout bounds each runtime helper command (systemctl, wg-quick, ip, wg);
+	// 0 means no timeout.
+	CommandTimeout time.Duration
+	// BackupDir receives a timestamped copy of the files that deletes and key
+	// rotations are about to change; empty disables backups.
+	BackupDir string
 }
 
 func DefaultConfig() Config {
+	return builtinConfig().withEnv()
+}
+
+func builtinConfig() Config {
 	return Config{
-		WireGuardDir:    envOr("BP_WG_DIR", defaultWireGuardDir()),
+		WireGuardDir:    defaultWireGuardDir(),
 		PeersSubdir:     "peers",
+		PeerLayout:      PeerLayoutFlat,
 		InterfacePrefix: "bp-",
-		SysctlFile:      envOr("SYSCTL_CONF_FILE", defaultSysctlFile()),
-		MinPort:         envInt("BP_WG_DEFAULT_MIN_PORT", 55107),
-		MaxPort:         envInt("BP_WG_DEFAULT_MAX_PORT", 55207),
+		SysctlFile:      defaultSysctlFile(),
+		MinPort:         55107,
+		MaxPort:         55207,
 		SubnetPrefix:    "69.0",
-		InterfaceMask:   24,
 		PeerMask:        32,
-		PublicInterface: os.Getenv("BP_PUBLIC_IFACE"),
-		EndpointHost:    os.Getenv("BP_ENDPOINT_HOST"),
-		FilePerm:        0o600,
-		DirPerm:         0o700,
+
+		IPv6InterfaceMask: 64,
+		IPv6PeerMask:      128,
+
+		EndpointFamily:  EndpointFamilyAuto,
+		PublicIPService: "https://api.ipify.org",
+		FirewallBackend: FirewallIPTables,
+
+		PersistentKeepalive: 25,
+
+		FilePerm: 0o600,
+		DirPerm:  0o700,
+
+		ConfigSizeWarnBytes: 1 << 20,
+		LockTimeout:         10 * time.Second,
+		CommandTimeout:      30 * time.Second,
 	}
 }
 
+// withEnv overrides c with any BP_* environment variables that are set.
+func (c Config) withEnv() Config {
+	c.WireGuardDir = envOr("BP_WG_DIR", c.WireGuardDir)
+	c.PeerLayout = envOr("BP_PEER_LAYOUT", c.PeerLayout)
+	c.SysctlFile = envOr("SYSCTL_CONF_FILE", c.SysctlFile)
+	c.MinPort = envInt("BP_WG_DEFAULT_MIN_PORT", c.MinPort)
+	c.MaxPort = envInt("BP_WG_DEFAULT_MAX_PORT", c.MaxPort)
+	if v := os.Getenv("BP_CHECK_PORT_IN_USE"); v != "" {
+		c.CheckPortInUse = v == "1"
+	}
+
+	c.IPv6Prefix = envOr("BP_IPV6_PREFIX", c.IPv6Prefix)
+
+	c.PublicInterface = envOr("BP_PUBLIC_IFACE", c.PublicInterface)
+	c.EndpointHost = envOr("BP_ENDPOINT_HOST", c.EndpointHost)
+	if hosts := envList("BP_ENDPOINT_HOSTS"); hosts != nil {
+		c.EndpointHosts = hosts
+	}
+	c.EndpointFamily = envOr("BP_ENDPOINT_FAMILY", c.EndpointFamily)
+	c.EndpointSource = envOr("BP_ENDPOINT_SOURCE", c.EndpointSource)
+	c.PublicIPService = envOr("BP_PUBLIC_IP_SERVICE", c.PublicIPService)
+	c.EndpointPort = envInt("BP_ENDPOINT_PORT", c.EndpointPort)
+	c.ObfuscationEndpoint = envOr("BP_OBFS_ENDPOINT", c.ObfuscationEndpoint)
+	c.ObfuscationTool = envOr("BP_OBFS_TOOL", c.ObfuscationTool)
+	c.ObfuscationPostUp = envOr("BP_OBFS_POSTUP", c.ObfuscationPostUp)
+	c.ObfuscationPostDown = envOr("BP_OBFS_POSTDOWN", c.ObfuscationPostDown)
+	c.NetNS = envOr("BP_NETNS", c.NetNS)
+	c.BindAddress = envOr("BP_BIND_ADDRESS", c.BindAddress)
+	c.InventoryFile = envOr("BP_INVENTORY_FILE", c.InventoryFile)
+
+	c.FirewallBackend = envOr("BP_FIREWALL_BACKEND", c.FirewallBackend)
+
+	c.MTU = envInt("BP_MTU", c.MTU)
+	c.ServerTable = envOr("BP_SERVER_TABLE", c.ServerTable)
+	c.ClientTable = envOr("BP_CLIENT_TABLE", c.ClientTable)
+	c.FwMark = envOr("BP_FWMARK", c.FwMark)
+	c.ClientFwMark = envOr("BP_CLIENT_FWMARK", c.ClientFwMark)
+
+	if v := os.Getenv("BP_USE_PRESHARED_KEY"); v != "" {
+		c.DisablePresharedKey = v == "0"
+	}
+	if v := os.Getenv("BP_SERVER_GENERATES_CLIENT_KEYS"); v != "" {
+		c.RequireClientPublicKey = v == "0"
+	}
+	c.PersistentKeepalive = envInt("BP_PERSISTENT_KEEPALIVE", c.PersistentKeepalive)
+	if dns := envList("BP_CLIENT_DNS"); dns != nil {
+		c.ClientDNS = dns
+	}
+	c.ClientAllowedIPs = envOr("BP_CLIENT_ALLOWED_IPS", c.ClientAllowedIPs)
+
+	c.LockTimeout = envSeconds("BP_LOCK_TIMEOUT", c.LockTimeout)
+	c.CommandTimeout = envSeconds("BP_COMMAND_TIMEOUT", c.CommandTimeout)
+	c.BackupDir = envOr("BP_BACKUP_DIR", c.BackupDir)
+	if v := os.Getenv("BP_VALIDATE_BEFORE_WRITE"); v != "" {
+		c.ValidateBeforeWrite = v == "1"
+	}
+	if v := os.Getenv("BP_STRIP_SAVE_CONFIG"); v != "" {
+		c.StripSaveConfig = v == "1"
+	}
+	return c
+}
+
+// LoadConfig reads a TOML file whose keys are Config field names, e.g.
+// SubnetPrefix = "10.8" or LockTimeout = "30s". Unset keys keep their
+// defaults and BP_* environment variables still override the file.
+func LoadConfig(path string) (Config, error) {
+	c := builtinConfig()
+	md, err := toml.DecodeFile(path, &c)
+	if err != nil {
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			return Config{}, errorf(ErrValidation, "config file %s: %s", path, perr.ErrorWithPosition())
+		}
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			return Config{}, fmt.Errorf("config file: %w", err)
+		}
+		return Config{}, errorf(ErrValidation, "config file %s: %w", path, err)
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		return Config{}, errorf(ErrValidation, "config file %s: unknown key %q", path, keys[0].String())
+	}
+	return c.withEnv().normalized(), nil
+}
+
 func (c Config) normalized() Config {
 	d := DefaultConfig()
 	if c.WireGuardDir == "" {
@@ -52,6 +298,9 @@ func (c Config) normalized() Config {
 	if c.PeersSubdir == "" {
 		c.PeersSubdir = d.PeersSubdir
 	}
+	if c.PeerLayout == "" {
+		c.PeerLayout = d.PeerLayout
+	}
 	if c.InterfacePrefix == "" {
 		c.InterfacePrefix = d.InterfacePrefix
 	}
@@ -68,20 +317,339 @@ func (c Config) normalized() Config {
 		c.SubnetPrefix = d.SubnetPrefix
 	}
 	if c.InterfaceMask == 0 {
-		c.InterfaceMask = d.InterfaceMask
+		c.InterfaceMask = 32 - 8*c.hostOctets()
 	}
 	if c.PeerMask == 0 {
 		c.PeerMask = d.PeerMask
 	}
+	if c.IPv6InterfaceMask == 0 {
+		c.IPv6InterfaceMask = d.IPv6InterfaceMask
+	}
+	if c.IPv6PeerMask == 0 {
+		c.IPv6PeerMask = d.IPv6PeerMask
+	}
+	if c.EndpointHost == "" && len(c.EndpointHosts) > 0 {
+		c.EndpointHost = c.EndpointHosts[0]
+	}
+	if c.EndpointFamily == "" {
+		c.EndpointFamily = d.EndpointFamily
+	}
+	if c.PublicIPService == "" {
+		c.PublicIPService = d.PublicIPService
+	}
+	if c.FirewallBackend == "" {
+		c.FirewallBackend = d.FirewallBackend
+	}
 	if c.FilePerm == 0 {
 		c.FilePerm = d.FilePerm
 	}
 	if c.DirPerm == 0 {
 		c.DirPerm = d.DirPerm
 	}
+	if c.ConfigSizeWarnBytes == 0 {
+		c.ConfigSizeWarnBytes = d.ConfigSizeWarnBytes
+	}
+	if c.LockTimeout == 0 {
+		c.LockTimeout = d.LockTimeout
+	}
 	return c
 }
 
+const (
+	minMTU = 576
+	maxMTU = 1500
+)
+
+func (c Config) mtuLine() string {
+	if c.MTU == 0 {
+		return ""
+	}
+	return fmt.Sprintf("MTU = %d\n", c.MTU)
+}
+
+func tableLine(table string) string {
+	if table == "" {
+		return ""
+	}
+	return "Table = " + table + "\n"
+}
+
+func validTable(table string) bool {
+	if table == "off" || table == "auto" {
+		return true
+	}
+	_, err := strconv.ParseUint(table, 10, 32)
+	return err == nil
+}
+
+// parseFwMark accepts a 32-bit mark in hex ("0xca6c") or decimal.
+func parseFwMark(mark string) (uint32, error) {
+	n, err := strconv.ParseUint(mark, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid fwmark %q: expected a 32-bit hex or decimal value", mark)
+	}
+	return uint32(n), nil
+}
+
+func fwMarkLine(mark string) string {
+	if mark == "" {
+		return ""
+	}
+	// validate has already checked the mark.
+	n, _ := parseFwMark(mark)
+	return fmt.Sprintf("FwMark = %#x\n", n)
+}
+
+var netnsRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Validate reports settings that would produce broken or insecure configs;
+// zero values are checked as their defaults.
+func (c Config) Validate() error {
+	c = c.normalized()
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return c.validatePerms()
+}
+
+func (c Config) validate() error {
+	if err := c.validateFields(); err != nil {
+		return &kindError{kind: ErrValidation, err: err}
+	}
+	return nil
+}
+
+// validatePerms is checked once by NewManagerWithError rather than by every
+// operation, so a manager built with NewManager keeps working with the
+// permissions it was given.
+func (c Config) validatePerms() error {
+	if c.FilePerm&0o077 != 0 {
+		return errorf(ErrValidation, "file permissions %#o expose private keys to group/other: use 0600 or stricter", c.FilePerm)
+	}
+	if c.DirPerm&0o077 != 0 {
+		return errorf(ErrValidation, "directory permissions %#o expose private keys to group/other: use 0700 or stricter", c.DirPerm)
+	}
+	return nil
+}
+
+func (c Config) validateFields() error {
+	if c.MinPort < 1 || c.MaxPort > 65535 {
+		return fmt.Errorf("invalid port range %d-%d: ports must be between 1 and 65535", c.MinPort, c.MaxPort)
+	}
+	if c.MinPort > c.MaxPort {
+		return fmt.Errorf("invalid port range: min port %d is above max port %d", c.MinPort, c.MaxPort)
+	}
+	if err := validateSubnetPrefix(c.SubnetPrefix); err != nil {
+		return err
+	}
+	for name, mask := range map[string]int{"interface": c.InterfaceMask, "peer": c.PeerMask} {
+		if mask < 0 || mask > 32 {
+			return fmt.Errorf("invalid %s mask /%d: must be between 0 and 32", name, mask)
+		}
+	}
+	for name, mask := range map[string]int{"ipv6 interface": c.IPv6InterfaceMask, "ipv6 peer": c.IPv6PeerMask} {
+		if mask < 0 || mask > 128 {
+			return fmt.Errorf("invalid %s mask /%d: must be between 0 and 128", name, mask)
+		}
+	}
+	if c.InterfaceMask > 32-8*c.hostOctets() {
+		return fmt.Errorf("interface mask /%d is too narrow for subnet prefix %q: use /%d or wider", c.InterfaceMask, c.SubnetPrefix, 32-8*c.hostOctets())
+	}
+	if c.NetNS != "" && (len(c.NetNS) > 255 || !netnsRE.MatchString(c.NetNS)) {
+		return fmt.Errorf("invalid network namespace %q: use letters, numbers, '.', '_' or '-'", c.NetNS)
+	}
+	if _, err := endpointFamilies(c.EndpointFamily); err != nil {
+		return err
+	}
+	switch c.EndpointSource {
+	case "", EndpointSourceLocal, EndpointSourceCloudMetadata, EndpointSourceHTTP:
+	default:
+		return fmt.Errorf("invalid endpoint source %q: use %s, %s or %s", c.EndpointSource, EndpointSourceLocal, EndpointSourceCloudMetadata, EndpointSourceHTTP)
+	}
+	if u, err := url.Parse(c.PublicIPService); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid public ip service %q: expected an http or https url", c.PublicIPService)
+	}
+	if c.EndpointPort < 0 || c.EndpointPort > 65535 {
+		return fmt.Errorf("invalid endpoint port %d: must be between 1 and 65535", c.EndpointPort)
+	}
+	if c.EndpointPort != 0 && c.MinPort != c.MaxPort {
+		return fmt.Errorf("endpoint port %d would be shared by every vpn in ports %d-%d: set min and max port to the one forwarded listen port", c.EndpointPort, c.MinPort, c.MaxPort)
+	}
+	if c.InterfaceNameTemplate != "" {
+		if err := c.validateInterfaceNameTemplate(); err != nil {
+			return err
+		}
+	}
+	if c.BindAddress != "" && net.ParseIP(c.BindAddress) == nil {
+		return fmt.Errorf("invalid bind address %q: expected an ip address", c.BindAddress)
+	}
+	if c.PersistentKeepalive < 0 || c.PersistentKeepalive > 65535 {
+		return fmt.Errorf("invalid persistent keepalive %d: use 1-65535 seconds, or 0 to omit it", c.PersistentKeepalive)
+	}
+	if c.MTU != 0 && (c.MTU < minMTU || c.MTU > maxMTU) {
+		return fmt.Errorf("invalid mtu %d: must be between %d and %d", c.MTU, minMTU, maxMTU)
+	}
+	for _, mark := range []string{c.FwMark, c.ClientFwMark} {
+		if mark == "" {
+			continue
+		}
+		if _, err := parseFwMark(mark); err != nil {
+			return err
+		}
+	}
+	for name, table := range map[string]string{"server": c.ServerTable, "client": c.ClientTable} {
+		if table == "" {
+			continue
+		}
+		if !validTable(table) {
+			return fmt.Errorf("invalid %s table %q: use off, auto or a routing table number", name, table)
+		}
+	}
+	for _, dns := range c.ClientDNS {
+		if net.ParseIP(dns) == nil {
+			return fmt.Errorf("invalid client dns server %q: expected an ip address", dns)
+		}
+	}
+	switch c.PeerLayout {
+	case "", PeerLayoutFlat, PeerLayoutNested:
+	default:
+		return fmt.Errorf("invalid peer layout %q: use %s or %s", c.PeerLayout, PeerLayoutFlat, PeerLayoutNested)
+	}
+	switch c.FirewallBackend {
+	case "", FirewallIPTables, FirewallNFTables:
+	default:
+		return fmt.Errorf("invalid firewall backend %q: use %s or %s", c.FirewallBackend, FirewallIPTables, FirewallNFTables)
+	}
+	upTmpl, downTmpl := c.ruleTemplates()
+	for name, text := range map[string]string{"PostUp": upTmpl, "PostDown": downTmpl, "ObfuscationPostUp": c.ObfuscationPostUp, "ObfuscationPostDown": c.ObfuscationPostDown} {
+		if _, err := template.New(name).Parse(text); err != nil {
+			return fmt.Errorf("invalid %s template: %w", name, err)
+		}
+	}
+	if c.ObfuscationEndpoint != "" {
+		if _, err := obfuscationEndpoint(c.ObfuscationEndpoint, 1); err != nil {
+			return err
+		}
+	}
+	if c.ObfuscationTool != "" && !labelRE.MatchString(c.ObfuscationTool) {
+		return fmt.Errorf("invalid obfuscation tool %q: use letters, digits, '.', '_', '/' or '-'", c.ObfuscationTool)
+	}
+	if c.ClientAllowedIPs != "" {
+		if err := validateCIDRList(c.ClientAllowedIPs); err != nil {
+			return fmt.Errorf("invalid client allowed ips %q: %w", c.ClientAllowedIPs, err)
+		}
+	}
+	if c.IPv6Prefix != "" {
+		ip := net.ParseIP(c.ipv6Addr(254, c.maxPeerHost()))
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid ipv6 prefix %q: expected up to three hex groups like fd00:6900", c.IPv6Prefix)
+		}
+	}
+	return nil
+}
+
+func validateSubnetPrefix(prefix string) error {
+	parts := strings.Split(prefix, ".")
+	if len(parts) > 2 {
+		return fmt.Errorf("invalid subnet prefix %q: expected one or two octets like 10 or 69.0", prefix)
+	}
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || n > 255 || strings.TrimSpace(p) != p || strings.HasPrefix(p, "+") {
+			return fmt.Errorf("invalid subnet prefix %q: expected one or two octets like 10 or 69.0", prefix)
+		}
+	}
+	return nil
+}
+
+// withPrefix returns c numbered under prefix; an InterfaceMask left at the
+// old prefix's natural size follows it (/24 for "69.0", /16 for "10").
+func (c Config) withPrefix(prefix string) Config {
+	if c.InterfaceMask == 32-8*c.hostOctets() {
+		c.InterfaceMask = 32 - 8*prefixHostOctets(prefix)
+	}
+	c.SubnetPrefix = prefix
+	return c
+}
+
+// hostOctets is the number of address octets after the vpn octet.
+func (c Config) hostOctets() int {
+	return prefixHostOctets(c.SubnetPrefix)
+}
+
+func prefixHostOctets(prefix string) int {
+	return 2 - strings.Count(prefix, ".")
+}
+
+// maxPeerHost is the highest host number a peer can get; 1 is the server.
+func (c Config) maxPeerHost() int {
+	return 1<<(8*c.hostOctets()) - 2
+}
+
+func (c Config) ipv4Addr(vpnOctet, host int) string {
+	if c.hostOctets() == 2 {
+		return fmt.Sprintf("%s.%d.%d.%d", c.SubnetPrefix, vpnOctet, host>>8, host&0xff)
+	}
+	return fmt.Sprintf("%s.%d.%d", c.SubnetPrefix, vpnOctet, host)
+}
+
+func (c Config) ipv6Addr(vpnOctet, host int) string {
+	if c.hostOctets() == 2 {
+		return fmt.Sprintf("%s:%d::%d:%d", c.IPv6Prefix, vpnOctet, host>>8, host&0xff)
+	}
+	return fmt.Sprintf("%s:%d::%d", c.IPv6Prefix, vpnOctet, host)
+}
+
+func (c Config) meshCIDR4(vpnOctet int) string {
+	return fmt.Sprintf("%s/%d", c.ipv4Addr(vpnOctet, 0), c.InterfaceMask)
+}
+
+func (c Config) meshCIDR6(vpnOctet int) string {
+	if c.IPv6Prefix == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d::/%d", c.IPv6Prefix, vpnOctet, c.IPv6InterfaceMask)
+}
+
+func (c Config) meshCIDRs(vpnOctet int) string {
+	return joinAddrs(c.meshCIDR4(vpnOctet), c.meshCIDR6(vpnOctet))
+}
+
+func (c Config) serverAddrs(vpnOctet int) string {
+	v4 := fmt.Sprintf("%s/%d", c.ipv4Addr(vpnOctet, 1), c.InterfaceMask)
+	if c.IPv6Prefix == "" {
+		return v4
+	}
+	return joinAddrs(v4, fmt.Sprintf("%s/%d", c.ipv6Addr(vpnOctet, 1), c.IPv6InterfaceMask))
+}
+
+func (c Config) peerAddrs(vpnOctet, host int) string {
+	v4 := fmt.Sprintf("%s/%d", c.ipv4Addr(vpnOctet, host), c.PeerMask)
+	if c.IPv6Prefix == "" {
+		return v4
+	}
+	return joinAddrs(v4, fmt.Sprintf("%s/%d", c.ipv6Addr(vpnOctet, host), c.IPv6PeerMask))
+}
+
+func validateCIDRList(list string) error {
+	for _, cidr := range strings.Split(list, ",") {
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func joinAddrs(addrs ...string) string {
+	out := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if a != "" {
+			out = append(out, a)
+		}
+	}
+	return strings.Join(out, ", ")
+}
+
 func (c Config) PeersDir() string {
 	c = c.normalized()
 	return filepath.Join(c.WireGuardDir, c.PeersSubdir)
@@ -89,6 +657,9 @@ func (c Config) PeersDir() string {
 
 func (c Config) InterfaceName(vpn string) string {
 	c = c.normalized()
+	if c.InterfaceNameTemplate != "" {
+		return c.templateInterfaceName(vpn)
+	}
 	return c.InterfacePrefix + vpn
 }
 
@@ -99,9 +670,25 @@ func (c Config) VPNConfigPath(vpn string) string {
 
 func (c Config) PeerConfigPath(vpn, peer string) string {
 	c = c.normalized()
+	if c.PeerLayout == PeerLayoutNested {
+		return filepath.Join(c.PeersDir(), vpn, peer+".conf")
+	}
 	return filepath.Join(c.PeersDir(), c.InterfaceName(vpn)+"-"+peer+".conf")
 }
 
+func endpointFamilies(family string) ([]string, error) {
+	switch family {
+	case EndpointFamilyAuto, "":
+		return []string{EndpointFamilyV4, EndpointFamilyV6}, nil
+	case EndpointFamilyV4:
+		return []string{EndpointFamilyV4}, nil
+	case EndpointFamilyV6:
+		return []string{EndpointFamilyV6}, nil
+	default:
+		return nil, fmt.Errorf("invalid endpoint family %q: use %s, %s or %s", family, EndpointFamilyV4, EndpointFamilyV6, EndpointFamilyAuto)
+	}
+}
+
 func envOr(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -109,6 +696,10 @@ func envOr(key, fallback string) string {
 	return fallback
 }
 
+func envSeconds(key string, fallback time.Duration) time.Duration {
+	return time.Duration(envInt(key, int(fallback/time.Second))) * time.Second
+}
+
 func envInt(key string, fallback int) int {
 	v := os.Getenv(key)
 	if v == "" {
@@ -121,6 +712,16 @@ func envInt(key string, fallback int) int {
 	return n
 }
 
+func envList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func defaultWireGuardDir() string {
 	switch runtime.GOOS {
 	case "linux":
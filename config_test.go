@@ -0,0 +1,127 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("BP_WG_DEFAULT_MAX_PORT", "56000")
+
+	path := filepath.Join(t.TempDir(), "bp.toml")
+	writeTestFile(t, path, `# per-host settings
+WireGuardDir = "/srv/wg"
+SubnetPrefix = "10.8"
+MinPort = 51000
+MaxPort = 51100
+ClientDNS = ["10.8.1.1"]
+LockTimeout = "30s"
+FilePerm = 0o400
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+	if cfg.WireGuardDir != "/srv/wg" || cfg.SubnetPrefix != "10.8" || cfg.MinPort != 51000 {
+		t.Fatalf("file values not applied: %+v", cfg)
+	}
+	if cfg.MaxPort != 56000 {
+		t.Fatalf("MaxPort = %d, want env override 56000", cfg.MaxPort)
+	}
+	if len(cfg.ClientDNS) != 1 || cfg.ClientDNS[0] != "10.8.1.1" {
+		t.Fatalf("ClientDNS = %v", cfg.ClientDNS)
+	}
+	if cfg.LockTimeout != 30*time.Second || cfg.FilePerm != 0o400 {
+		t.Fatalf("LockTimeout = %v, FilePerm = %o", cfg.LockTimeout, cfg.FilePerm)
+	}
+	if cfg.PeersSubdir != "peers" || cfg.PersistentKeepalive != 25 || cfg.InterfaceMask != 24 {
+		t.Fatalf("defaults not kept: %+v", cfg)
+	}
+}
+
+func TestLoadConfigDerivesInterfaceMask(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bp.toml")
+	writeTestFile(t, path, "SubnetPrefix = \"10\"\n")
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+	if cfg.InterfaceMask != 16 {
+		t.Fatalf("InterfaceMask = %d, want 16 for a one-octet prefix", cfg.InterfaceMask)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate returned error: %v", err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := LoadConfig(filepath.Join(dir, "missing.toml")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+
+	unknown := filepath.Join(dir, "unknown.toml")
+	writeTestFile(t, unknown, "SubnetPrefx = \"10.8\"\n")
+	if _, err := LoadConfig(unknown); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for unknown key, got %v", err)
+	}
+
+	bad := filepath.Join(dir, "bad.toml")
+	writeTestFile(t, bad, "MinPort = \"many\"\n")
+	if _, err := LoadConfig(bad); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for bad type, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	if err := (Config{}).Validate(); err != nil {
+		t.Fatalf("default config rejected: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"port order", Config{MinPort: 55300, MaxPort: 55200}},
+		{"port range", Config{MinPort: 60000, MaxPort: 70000}},
+		{"interface mask", Config{InterfaceMask: 40}},
+		{"negative interface mask", Config{InterfaceMask: -1}},
+		{"peer mask", Config{PeerMask: 33}},
+		{"ipv6 mask", Config{IPv6PeerMask: 129}},
+		{"prefix letters", Config{SubnetPrefix: "ten.0"}},
+		{"prefix octet", Config{SubnetPrefix: "69.256"}},
+		{"prefix too long", Config{SubnetPrefix: "10.1.2"}},
+		{"prefix sign", Config{SubnetPrefix: "+10"}},
+		{"file perm", Config{FilePerm: 0o644}},
+		{"dir perm", Config{DirPerm: 0o750}},
+		{"bind address", Config{BindAddress: "eth1"}},
+		{"endpoint port", Config{EndpointPort: 70000}},
+		{"shared endpoint port", Config{EndpointPort: 51820}},
+		{"endpoint source", Config{EndpointSource: "magic"}},
+		{"endpoint family", Config{EndpointFamily: "ipv6"}},
+		{"public ip service", Config{PublicIPService: "ftp://ip.example.com"}},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.Validate()
+		if !errors.Is(err, ErrValidation) {
+			t.Fatalf("%s: expected ErrValidation, got %v", tt.name, err)
+		}
+		if _, err := NewManagerWithError(tt.cfg, Dependencies{Keys: &fakeKeys{}}); !errors.Is(err, ErrValidation) {
+			t.Fatalf("%s: NewManagerWithError: expected ErrValidation, got %v", tt.name, err)
+		}
+	}
+
+	// Permissions are a construction-time check; NewManager callers that
+	// chose looser modes are not refused on every operation.
+	mgr := newTestManager(t, Config{FilePerm: 0o640, DirPerm: 0o750})
+	if _, err := mgr.AddVPN(context.Background(), "home"); err != nil {
+		t.Fatalf("AddVPN with loose permissions returned error: %v", err)
+	}
+}
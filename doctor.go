@@ -0,0 +1,136 @@
+package bypasser
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Path     string `json:"path"`
+}
+
+func (m *Manager) Doctor(ctx context.Context) ([]Diagnostic, error) {
+	var diags []Diagnostic
+	add := func(severity, path, format string, args ...any) {
+		diags = append(diags, Diagnostic{Severity: severity, Message: fmt.Sprintf(format, args...), Path: path})
+	}
+
+	vpns, err := m.ListVPNs()
+	if err != nil {
+		return nil, err
+	}
+	peers, err := m.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	peersByVPN := make(map[string][]string)
+	for _, p := range peers {
+		peersByVPN[p.VPN] = append(peersByVPN[p.VPN], p.Peer)
+	}
+
+	portOwner := make(map[string]string)
+	for _, vpn := range vpns {
+		path := m.cfg.VPNConfigPath(vpn)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		content := string(b)
+		doc := parseINI(content)
+
+		if doc.First("Interface", "PrivateKey") == "" {
+			add(SeverityError, path, "vpn %q is missing Interface.PrivateKey", vpn)
+		}
+		if port := doc.First("Interface", "ListenPort"); port != "" {
+			if other, ok := portOwner[port]; ok {
+				add(SeverityError, path, "vpn %q uses ListenPort %s already used by vpn %q", vpn, port, other)
+			} else {
+				portOwner[port] = vpn
+			}
+		}
+
+		peerAddrs := make(map[string]string)
+		for _, peer := range peersByVPN[vpn] {
+			pb, err := os.ReadFile(m.cfg.PeerConfigPath(vpn, peer))
+			if err != nil {
+				return nil, err
+			}
+			peerAddrs[peer] = compactList(normalizeCIDR(firstSectionValue(string(pb), "Interface", "Address"), m.cfg.PeerMask))
+		}
+
+		matched := make(map[string]bool)
+		allowedOwner := make(map[string]string)
+		for i, block := range peerBlocks(content) {
+			label := fmt.Sprintf("block #%d", i+1)
+			if block.Meta["peer"] != "" {
+				label = PeerRef{VPN: vpn, Peer: block.Meta["peer"]}.String()
+			}
+			for _, ip := range strings.Split(block.AllowedIPs, ",") {
+				ip = strings.TrimSpace(ip)
+				if ip == "" {
+					continue
+				}
+				if other, ok := allowedOwner[ip]; ok {
+					add(SeverityError, path, "AllowedIPs %s is used by both %s and %s", ip, other, label)
+				} else {
+					allowedOwner[ip] = label
+				}
+			}
+
+			owner := ""
+			if name := block.Meta["peer"]; name != "" {
+				if _, ok := peerAddrs[name]; ok {
+					owner = name
+				}
+			} else {
+				for name, addr := range peerAddrs {
+					if addr != "" && addr == compactList(block.AllowedIPs) {
+						owner = name
+						break
+					}
+				}
+			}
+			if owner == "" {
+				add(SeverityWarning, path, "server peer %s has no matching peer file", label)
+				continue
+			}
+			matched[owner] = true
+		}
+
+		for _, peer := range peersByVPN[vpn] {
+			if !matched[peer] {
+				add(SeverityError, m.cfg.PeerConfigPath(vpn, peer), "peer %q has no [Peer] block in %s", PeerRef{VPN: vpn, Peer: peer}.String(), path)
+			}
+		}
+		delete(peersByVPN, vpn)
+	}
+
+	for _, p := range peers {
+		if _, orphaned := peersByVPN[p.VPN]; orphaned {
+			add(SeverityError, m.cfg.PeerConfigPath(p.VPN, p.Peer), "peer %q belongs to vpn %q which has no config", p.String(), p.VPN)
+		}
+	}
+
+	overlaps, err := m.DetectOverlaps()
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range overlaps {
+		add(SeverityError, m.cfg.VPNConfigPath(o.B.VPN), "vpn %q subnet %s overlaps vpn %q subnet %s", o.B.VPN, o.B.CIDR, o.A.VPN, o.A.CIDR)
+	}
+	return diags, nil
+}
+
+func compactList(s string) string {
+	return strings.ReplaceAll(s, " ", "")
+}
@@ -0,0 +1,37 @@
+package bypasser
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DumpServerConfigs concatenates every vpn config, each under an
+// "===== <interface> =====" header, for review. With redact the
+// PrivateKey and PresharedKey values are replaced by "<redacted>" so the
+// result can be shared without the server's secrets.
+func (m *Manager) DumpServerConfigs(redact bool) (string, error) {
+	vpns, err := m.ListVPNs()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, vpn := range vpns {
+		data, err := os.ReadFile(m.cfg.VPNConfigPath(vpn))
+		if err != nil {
+			return "", err
+		}
+		content := string(data)
+		if redact {
+			content = redactKeys(content, "PrivateKey", "PresharedKey")
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "===== %s =====\n%s", m.cfg.InterfaceName(vpn), content)
+		if !strings.HasSuffix(content, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String(), nil
+}
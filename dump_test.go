@@ -0,0 +1,51 @@
+package bypasser
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDumpServerConfigs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if out, err := mgr.DumpServerConfigs(true); err != nil || out != "" {
+		t.Fatalf("DumpServerConfigs on an empty dir = %q, %v", out, err)
+	}
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	home := readTestFile(t, mgr.Config().VPNConfigPath("home"))
+	work := readTestFile(t, mgr.Config().VPNConfigPath("work"))
+
+	plain, err := mgr.DumpServerConfigs(false)
+	if err != nil {
+		t.Fatalf("DumpServerConfigs returned error: %v", err)
+	}
+	if want := "===== bp-home =====\n" + home + "\n===== bp-work =====\n" + work; plain != want {
+		t.Fatalf("DumpServerConfigs(false) =\n%s\nwant\n%s", plain, want)
+	}
+
+	out, err := mgr.DumpServerConfigs(true)
+	if err != nil {
+		t.Fatalf("DumpServerConfigs returned error: %v", err)
+	}
+	server := parseINI(home).First("Interface", "PrivateKey")
+	psk := parseINI(home).First("Peer", "PresharedKey")
+	if strings.Contains(out, server) || strings.Contains(out, psk) {
+		t.Fatalf("secrets leaked into redacted dump:\n%s", out)
+	}
+	if strings.Count(out, "PrivateKey = <redacted>\n") != 2 || !strings.Contains(out, "PresharedKey = <redacted>\n") {
+		t.Fatalf("expected redacted keys:\n%s", out)
+	}
+	if !strings.Contains(out, "PublicKey = "+parseINI(home).First("Peer", "PublicKey")+"\n") {
+		t.Fatalf("public key should not be redacted:\n%s", out)
+	}
+}
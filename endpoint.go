@@ -0,0 +1,132 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SetEndpoint points every client of vpn at host, e.g. after the server's
+// public IP changed. Each [Peer].Endpoint keeps its port, and the entry for the
+// old host in a "# bp-endpoints:" comment is replaced too. Clients behind an
+// obfuscation tunnel keep their local Endpoint; the server in their
+// "# bp-obfs:" comment is replaced instead. The server config has no endpoint
+// and is left alone.
+func (m *Manager) SetEndpoint(ctx context.Context, vpn, host string) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("vpn", vpn); err != nil {
+		return rep, err
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(host), "["), "]")
+	if host == "" {
+		return rep, errorf(ErrValidation, "endpoint host must not be empty")
+	}
+	if net.ParseIP(host) == nil {
+		if strings.Contains(host, ":") {
+			return rep, errorf(ErrValidation, "invalid endpoint %q: give the host without a port", host)
+		}
+		if _, err := m.net.LookupHost(ctx, host); err != nil {
+			return rep, errorf(ErrValidation, "endpoint host %q does not resolve: %w", host, err)
+		}
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	vpnPath := m.cfg.VPNConfigPath(vpn)
+	if _, err := os.Stat(vpnPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, vpnNotFound(vpn, vpnPath)
+		}
+		return rep, err
+	}
+	peers, err := m.ListPeers()
+	if err != nil {
+		return rep, err
+	}
+
+	updated := make(map[string]string)
+	var paths []string
+	for _, p := range peers {
+		if p.VPN != vpn {
+			continue
+		}
+		path := m.cfg.PeerConfigPath(p.VPN, p.Peer)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return rep, err
+		}
+		old := firstSectionValue(string(b), "Peer", "Endpoint")
+		obfs := obfsServer(string(b))
+		if obfs != "" {
+			old = obfs
+		}
+		_, port, err := net.SplitHostPort(old)
+		if err != nil {
+			rep.warnf("peer file %s has no usable [Peer] Endpoint %q; left unchanged", path, old)
+			continue
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			rep.warnf("peer file %s has no usable [Peer] Endpoint %q; left unchanged", path, old)
+			continue
+		}
+		endpoint := formatEndpoint(host, n)
+		var conf string
+		if obfs != "" {
+			conf = setObfsServer(string(b), endpoint)
+		} else {
+			conf, _ = setSectionValue(string(b), "Peer", "Endpoint", endpoint)
+		}
+		conf = replaceListedEndpoint(conf, old, endpoint)
+		if conf != string(b) {
+			updated[path] = conf
+			paths = append(paths, path)
+		}
+	}
+
+	if err := m.backup(&rep, "set-endpoint-"+vpn, paths...); err != nil {
+		return rep, err
+	}
+	for _, path := range paths {
+		if err := m.writeFile(path, []byte(updated[path]), &rep); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// replaceListedEndpoint swaps old for endpoint in a "# bp-endpoints:" comment,
+// dropping it if endpoint is already listed.
+func replaceListedEndpoint(content, old, endpoint string) string {
+	lines := splitLines(content)
+	for i, raw := range lines {
+		rest, ok := strings.CutPrefix(strings.TrimSpace(raw), "# bp-endpoints:")
+		if !ok {
+			continue
+		}
+		var out []string
+		seen := make(map[string]bool)
+		for _, ep := range strings.Split(rest, ",") {
+			ep = strings.TrimSpace(ep)
+			if ep == old {
+				ep = endpoint
+			}
+			if ep != "" && !seen[ep] {
+				seen[ep] = true
+				out = append(out, ep)
+			}
+		}
+		lines[i] = "# bp-endpoints: " + strings.Join(out, ", ")
+	}
+	return strings.Join(lines, "\n")
+}
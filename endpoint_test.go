@@ -0,0 +1,76 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSetEndpoint(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	netw := fakeNetwork{hosts: map[string][]string{"vpn.example.com": {"198.51.100.9"}}}
+	mgr := NewManager(Config{
+		WireGuardDir:    t.TempDir(),
+		PublicInterface: "eth0",
+		EndpointHosts:   []string{"203.0.113.7", "backup.example.com"},
+	}, Dependencies{System: &FakeSystem{}, Keys: &fakeKeys{}, Net: netw})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	for _, ref := range []PeerRef{{"home", "laptop"}, {"home", "phone"}, {"work", "desk"}} {
+		if _, err := mgr.AddPeer(ctx, ref.VPN, ref.Peer); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+	}
+	cfg := mgr.Config()
+	vpnBefore := readTestFile(t, cfg.VPNConfigPath("home"))
+	workBefore := readTestFile(t, cfg.PeerConfigPath("work", "desk"))
+
+	rep, err := mgr.SetEndpoint(ctx, "home", "vpn.example.com")
+	if err != nil {
+		t.Fatalf("SetEndpoint returned error: %v", err)
+	}
+	if len(rep.Changes) != 2 {
+		t.Fatalf("expected two peer files to change, got %#v", rep.Changes)
+	}
+	for _, peer := range []string{"laptop", "phone"} {
+		conf := readTestFile(t, cfg.PeerConfigPath("home", peer))
+		if !strings.Contains(conf, "Endpoint = vpn.example.com:55107\n") {
+			t.Fatalf("endpoint not rewritten for %s:\n%s", peer, conf)
+		}
+		if !strings.Contains(conf, "# bp-endpoints: vpn.example.com:55107, backup.example.com:55107\n") {
+			t.Fatalf("endpoint list not rewritten for %s:\n%s", peer, conf)
+		}
+	}
+	if readTestFile(t, cfg.VPNConfigPath("home")) != vpnBefore {
+		t.Fatalf("vpn config was modified")
+	}
+	if readTestFile(t, cfg.PeerConfigPath("work", "desk")) != workBefore {
+		t.Fatalf("peer of another vpn was modified")
+	}
+
+	if _, err := mgr.SetEndpoint(ctx, "work", "2001:db8::1"); err != nil {
+		t.Fatalf("SetEndpoint returned error: %v", err)
+	}
+	if conf := readTestFile(t, cfg.PeerConfigPath("work", "desk")); !strings.Contains(conf, "Endpoint = [2001:db8::1]:55108\n") {
+		t.Fatalf("ipv6 endpoint not rewritten:\n%s", conf)
+	}
+
+	for _, host := range []string{"", "vpn.exmaple.com", "vpn.example.com:51820"} {
+		if _, err := mgr.SetEndpoint(ctx, "home", host); !errors.Is(err, ErrValidation) {
+			t.Fatalf("SetEndpoint(%q): expected ErrValidation, got %v", host, err)
+		}
+	}
+	if _, err := mgr.SetEndpoint(ctx, "missing", "203.0.113.8"); !errors.Is(err, ErrVPNNotFound) {
+		t.Fatalf("expected ErrVPNNotFound, got %v", err)
+	}
+	bad := newTestManager(t, Config{WireGuardDir: cfg.WireGuardDir, FirewallBackend: "pf"})
+	if _, err := bad.SetEndpoint(ctx, "home", "203.0.113.8"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for an invalid config, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package bypasser
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Error classes returned by Manager methods; test with errors.Is.
+var (
+	ErrAlreadyExists = errors.New("already exists")
+	ErrNotFound      = errors.New("not found")
+	ErrValidation    = errors.New("invalid input")
+	// ErrPermission is fs.ErrPermission, so permission failures from the
+	// filesystem match it without extra wrapping.
+	ErrPermission = fs.ErrPermission
+)
+
+// Specific errors; each also matches its class above, e.g.
+// errors.Is(err, ErrVPNNotFound) implies errors.Is(err, ErrNotFound).
+var (
+	ErrVPNNotFound  = fmt.Errorf("vpn %w", ErrNotFound)
+	ErrVPNExists    = fmt.Errorf("vpn %w", ErrAlreadyExists)
+	ErrPeerNotFound = fmt.Errorf("peer %w", ErrNotFound)
+	ErrPeerExists   = fmt.Errorf("peer %w", ErrAlreadyExists)
+	ErrInvalidName  = fmt.Errorf("name is %w", ErrValidation)
+	// ErrArchiveAuth means an encrypted archive failed authentication: the
+	// passphrase is wrong or the archive was modified.
+	ErrArchiveAuth = fmt.Errorf("archive authentication failed: %w", ErrValidation)
+)
+
+// kindError tags an error with a class while keeping its message unchanged.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.err, e.kind} }
+
+func errorf(kind error, format string, args ...any) error {
+	return &kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+func vpnNotFound(vpn, path string) error {
+	return errorf(ErrVPNNotFound, "vpn %q does not exist (%s)", vpn, path)
+}
+
+func vpnExists(vpn, path string) error {
+	return errorf(ErrVPNExists, "vpn %q already exists (%s)", vpn, path)
+}
+
+func peerNotFound(ref PeerRef, path string) error {
+	return errorf(ErrPeerNotFound, "peer %q does not exist (%s)", ref.String(), path)
+}
+
+func peerExists(ref PeerRef, path string) error {
+	return errorf(ErrPeerExists, "peer %q already exists (%s)", ref.String(), path)
+}
@@ -0,0 +1,65 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestErrorClasses(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+
+	_, err := mgr.AddVPN(ctx, "home")
+	if !errors.Is(err, ErrAlreadyExists) || err.Error() != `vpn "home" already exists (`+mgr.Config().VPNConfigPath("home")+`)` {
+		t.Fatalf("unexpected duplicate vpn error: %v", err)
+	}
+	if _, err := mgr.DeleteVPN(ctx, "nope"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "Bad!"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation, got %v", err)
+	}
+	if _, err := NewManager(Config{NetNS: "bad ns"}, Dependencies{}).AddVPN(ctx, "x"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for bad config, got %v", err)
+	}
+}
+
+func TestSpecificErrors(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+
+	_, err := mgr.DeleteVPN(ctx, "missing")
+	if !errors.Is(err, ErrVPNNotFound) || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrVPNNotFound, got %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "missing", "laptop"); !errors.Is(err, ErrVPNNotFound) {
+		t.Fatalf("expected ErrVPNNotFound from AddPeer, got %v", err)
+	}
+
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddVPN(ctx, "home"); !errors.Is(err, ErrVPNExists) {
+		t.Fatalf("expected ErrVPNExists, got %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); !errors.Is(err, ErrPeerExists) || !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected ErrPeerExists, got %v", err)
+	}
+	if _, err := mgr.DeletePeer(ctx, "home", "phone"); !errors.Is(err, ErrPeerNotFound) || errors.Is(err, ErrVPNNotFound) {
+		t.Fatalf("expected ErrPeerNotFound, got %v", err)
+	}
+	if _, err := ParsePeerRef("home"); !errors.Is(err, ErrInvalidName) || !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrInvalidName, got %v", err)
+	}
+}
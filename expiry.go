@@ -0,0 +1,95 @@
+package bypasser
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const expiresPrefix = "# bp-expires:"
+
+// peerExpiry returns the first "# bp-expires:" time among lines. ok is false
+// when there is none; a malformed one is returned as err.
+func peerExpiry(lines []string) (t time.Time, ok bool, err error) {
+	for _, raw := range lines {
+		rest, found := strings.CutPrefix(strings.TrimSpace(raw), expiresPrefix)
+		if !found {
+			continue
+		}
+		t, err = time.Parse(time.RFC3339, strings.TrimSpace(rest))
+		return t, true, err
+	}
+	return time.Time{}, false, nil
+}
+
+// PruneExpired deletes every peer whose "# bp-expires:" time (from its client
+// file or, failing that, its server block) has passed by the manager's clock,
+// removing the client file and server block like DeletePeer. Each affected
+// interface is restarted once.
+func (m *Manager) PruneExpired(ctx context.Context) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	peers, err := m.ListPeers()
+	if err != nil {
+		return rep, err
+	}
+	now := m.now()
+	var restart []string
+	for _, ref := range peers {
+		peerPath := m.cfg.PeerConfigPath(ref.VPN, ref.Peer)
+		b, err := os.ReadFile(peerPath)
+		if err != nil {
+			return rep, err
+		}
+		expires, ok, err := peerExpiry(splitLines(string(b)))
+		if !ok {
+			expires, ok, err = m.serverBlockExpiry(ref)
+		}
+		if err != nil {
+			rep.warnf("ignoring malformed expiry of %s: %v", ref.String(), err)
+			continue
+		}
+		if !ok || expires.After(now) {
+			continue
+		}
+		if err := m.deletePeer(ctx, ref.VPN, ref.Peer, &rep); err != nil {
+			return rep, err
+		}
+		if !slices.Contains(restart, ref.VPN) {
+			restart = append(restart, ref.VPN)
+		}
+	}
+	if len(restart) == 0 {
+		return rep, nil
+	}
+	for _, vpn := range restart {
+		m.maybeVPNRestart(ctx, &rep, vpn)
+	}
+	m.updateInventory(&rep)
+	return rep, nil
+}
+
+// serverBlockExpiry reads the expiry of ref from its block in the vpn config.
+func (m *Manager) serverBlockExpiry(ref PeerRef) (time.Time, bool, error) {
+	b, err := os.ReadFile(m.cfg.VPNConfigPath(ref.VPN))
+	if err != nil {
+		return time.Time{}, false, nil
+	}
+	for _, sec := range parseINI(string(b)).SectionsNamed("Peer") {
+		if meta := sectionMeta(sec); meta != nil && meta["vpn"] == ref.VPN && meta["peer"] == ref.Peer {
+			return peerExpiry(sec.Comments())
+		}
+	}
+	return time.Time{}, false, nil
+}
@@ -0,0 +1,129 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneExpired(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	sys := &FakeSystem{RootValue: true, Commands: map[string]bool{"systemctl": true}}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointHost: "203.0.113.7"},
+		Dependencies{System: sys, Keys: &fakeKeys{}, Clock: func() time.Time { return now }})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "guest", AddPeerOptions{ExpiresAt: now.Add(-time.Hour)}); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for a past expiry, got %v", err)
+	}
+	guest, err := mgr.AddPeerWithOptions(ctx, "home", "guest", AddPeerOptions{ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "work", "visitor", AddPeerOptions{ExpiresAt: now.Add(48 * time.Hour)}); err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	cfg := mgr.Config()
+	line := "# bp-expires: 2026-03-01T13:00:00Z"
+	if !strings.Contains(guest.PeerConfig, peerMetaLine("home", "guest")+"\n"+line+"\n") {
+		t.Fatalf("expiry not recorded in client config:\n%s", guest.PeerConfig)
+	}
+	if !strings.Contains(readTestFile(t, cfg.VPNConfigPath("home")), peerMetaLine("home", "guest")+"\n"+line+"\n[Peer]") {
+		t.Fatalf("expiry not recorded in server block:\n%s", readTestFile(t, cfg.VPNConfigPath("home")))
+	}
+
+	calls := len(sys.Calls())
+	rep, err := mgr.PruneExpired(ctx)
+	if err != nil {
+		t.Fatalf("PruneExpired returned error: %v", err)
+	}
+	if len(rep.Changes) != 0 || len(sys.Calls()) != calls {
+		t.Fatalf("nothing should expire yet: %#v", rep)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := mgr.PruneExpired(ctx); err != nil {
+		t.Fatalf("PruneExpired returned error: %v", err)
+	}
+	if _, err := os.Stat(cfg.PeerConfigPath("home", "guest")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expired peer file still exists: %v", err)
+	}
+	home := readTestFile(t, cfg.VPNConfigPath("home"))
+	if strings.Contains(home, "peer=guest") || !strings.Contains(home, "peer=laptop") {
+		t.Fatalf("unexpected server config:\n%s", home)
+	}
+	if !strings.Contains(readTestFile(t, cfg.VPNConfigPath("work")), "peer=visitor") {
+		t.Fatalf("unexpired peer was removed")
+	}
+	if got := sys.Calls()[calls:]; len(got) != 1 || got[0] != "systemctl restart wg-quick@bp-home" {
+		t.Fatalf("calls = %v", got)
+	}
+}
+
+func TestMovePeerKeepsExpiry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	opts := AddPeerOptions{ExpiresAt: expires, Labels: map[string]string{"team": "eng"}}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "guest", opts); err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	if _, err := mgr.MovePeer(ctx, "home", "work", "guest"); err != nil {
+		t.Fatalf("MovePeer returned error: %v", err)
+	}
+
+	cfg := mgr.Config()
+	lines := peerMetaLine("work", "guest") + "\n" + strings.Join(peerCommentLines(opts), "\n") + "\n"
+	if conf := readTestFile(t, cfg.PeerConfigPath("work", "guest")); !strings.Contains(conf, lines) {
+		t.Fatalf("expiry or labels lost from client config:\n%s", conf)
+	}
+	if conf := readTestFile(t, cfg.VPNConfigPath("work")); !strings.Contains(conf, lines+"[Peer]") {
+		t.Fatalf("expiry or labels lost from server block:\n%s", conf)
+	}
+}
+
+func TestRegenerateKeysKeepsExpiry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	opts := AddPeerOptions{ExpiresAt: expires, Labels: map[string]string{"team": "eng"}}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "guest", opts); err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	res, err := mgr.RegenerateKeys(ctx, "home", "guest")
+	if err != nil {
+		t.Fatalf("RegenerateKeys returned error: %v", err)
+	}
+
+	lines := peerMetaLine("home", "guest") + "\n" + strings.Join(peerCommentLines(opts), "\n") + "\n"
+	if !strings.Contains(res.PeerConfig, lines) {
+		t.Fatalf("expiry or labels lost from client config:\n%s", res.PeerConfig)
+	}
+	if conf := readTestFile(t, mgr.Config().VPNConfigPath("home")); !strings.Contains(conf, lines+"[Peer]") {
+		t.Fatalf("expiry or labels lost from server block:\n%s", conf)
+	}
+}
@@ -0,0 +1,66 @@
+package bypasser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FakeSystem is an in-memory System for tests. Commands are keyed by the
+// command line joined with spaces, e.g. "wg show bp-home dump".
+type FakeSystem struct {
+	RootValue bool
+	Commands  map[string]bool
+	Outputs   map[string]string
+	Errors    map[string]error
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *FakeSystem) IsRoot() bool {
+	return f.RootValue
+}
+
+func (f *FakeSystem) HasCommand(name string) bool {
+	return f.Commands[name]
+}
+
+func (f *FakeSystem) Run(_ context.Context, name string, args ...string) error {
+	key := f.record(name, args)
+	return f.Errors[key]
+}
+
+func (f *FakeSystem) Output(_ context.Context, name string, args ...string) (string, error) {
+	return f.output(f.record(name, args))
+}
+
+func (f *FakeSystem) OutputInput(_ context.Context, _ string, name string, args ...string) (string, error) {
+	return f.output(f.record(name, args))
+}
+
+func (f *FakeSystem) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *FakeSystem) record(name string, args []string) string {
+	key := strings.Join(append([]string{name}, args...), " ")
+	f.mu.Lock()
+	f.calls = append(f.calls, key)
+	f.mu.Unlock()
+	return key
+}
+
+func (f *FakeSystem) output(key string) (string, error) {
+	if err := f.Errors[key]; err != nil {
+		return "", err
+	}
+	out, ok := f.Outputs[key]
+	if !ok {
+		return "", fmt.Errorf("fake system: no output configured for %q", key)
+	}
+	return out, nil
+}
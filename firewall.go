@@ -0,0 +1,283 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	FirewallIPTables = "iptables"
+	FirewallNFTables = "nftables"
+)
+
+type FirewallRuleData struct {
+	MeshCIDR    string
+	MeshCIDR6   string // empty unless Config.IPv6Prefix is set
+	PublicIface string
+	Port        int
+	Interface   string
+	// BindAddress is Config.BindAddress; BindIPv6 reports its family.
+	BindAddress string
+	BindIPv6    bool
+}
+
+const (
+	iptablesPostUp = `iptables -t nat -A POSTROUTING -s {{.MeshCIDR}} -o {{.PublicIface}} -j MASQUERADE; ` +
+		`{{if .BindAddress}}{{if .BindIPv6}}ip6tables{{else}}iptables{{end}} -A INPUT -p udp -m udp --dport {{.Port}} ! -d {{.BindAddress}} -j DROP; {{end}}` +
+		`iptables -A INPUT -p udp -m udp --dport {{.Port}} -j ACCEPT; ` +
+		`iptables -A FORWARD -i {{.Interface}} -j ACCEPT; iptables -A FORWARD -o {{.Interface}} -j ACCEPT;` +
+		`{{if .MeshCIDR6}} ip6tables -t nat -A POSTROUTING -s {{.MeshCIDR6}} -o {{.PublicIface}} -j MASQUERADE; ` +
+		`ip6tables -A FORWARD -i {{.Interface}} -j ACCEPT; ip6tables -A FORWARD -o {{.Interface}} -j ACCEPT;{{end}}`
+	iptablesPostDown = `iptables -t nat -D POSTROUTING -s {{.MeshCIDR}} -o {{.PublicIface}} -j MASQUERADE; ` +
+		`{{if .BindAddress}}{{if .BindIPv6}}ip6tables{{else}}iptables{{end}} -D INPUT -p udp -m udp --dport {{.Port}} ! -d {{.BindAddress}} -j DROP; {{end}}` +
+		`iptables -D INPUT -p udp -m udp --dport {{.Port}} -j ACCEPT; ` +
+		`iptables -D FORWARD -i {{.Interface}} -j ACCEPT; iptables -D FORWARD -o {{.Interface}} -j ACCEPT;` +
+		`{{if .MeshCIDR6}} ip6tables -t nat -D POSTROUTING -s {{.MeshCIDR6}} -o {{.PublicIface}} -j MASQUERADE; ` +
+		`ip6tables -D FORWARD -i {{.Interface}} -j ACCEPT; ip6tables -D FORWARD -o {{.Interface}} -j ACCEPT;{{end}}`
+
+	// The nftables preset keeps every rule in a table named after the interface,
+	// so PostDown only has to drop that table.
+	nftablesPostUp = `nft add table inet {{.Interface}}; ` +
+		`nft add chain inet {{.Interface}} input { type filter hook input priority 0 \; }; ` +
+		`{{if .BindAddress}}nft add rule inet {{.Interface}} input {{if .BindIPv6}}ip6{{else}}ip{{end}} daddr != {{.BindAddress}} udp dport {{.Port}} drop; {{end}}` +
+		`nft add rule inet {{.Interface}} input udp dport {{.Port}} accept; ` +
+		`nft add chain inet {{.Interface}} forward { type filter hook forward priority 0 \; }; ` +
+		`nft add rule inet {{.Interface}} forward iifname {{.Interface}} accept; ` +
+		`nft add rule inet {{.Interface}} forward oifname {{.Interface}} accept; ` +
+		`nft add chain inet {{.Interface}} postrouting { type nat hook postrouting priority 100 \; }; ` +
+		`nft add rule inet {{.Interface}} postrouting ip saddr {{.MeshCIDR}} oifname {{.PublicIface}} masquerade;` +
+		`{{if .MeshCIDR6}} nft add rule inet {{.Interface}} postrouting ip6 saddr {{.MeshCIDR6}} oifname {{.PublicIface}} masquerade;{{end}}`
+	nftablesPostDown = `nft delete table inet {{.Interface}};`
+)
+
+func IPTablesTemplates() (postUp, postDown string) {
+	return iptablesPostUp, iptablesPostDown
+}
+
+func NFTablesTemplates() (postUp, postDown string) {
+	return nftablesPostUp, nftablesPostDown
+}
+
+func (c Config) ruleTemplates() (postUp, postDown string) {
+	postUp, postDown = IPTablesTemplates()
+	if c.FirewallBackend == FirewallNFTables {
+		postUp, postDown = NFTablesTemplates()
+	}
+	if c.PostUpTemplate != "" {
+		postUp = c.PostUpTemplate
+	}
+	if c.PostDownTemplate != "" {
+		postDown = c.PostDownTemplate
+	}
+	return postUp, postDown
+}
+
+func (c Config) renderRules(data FirewallRuleData) (postUp, postDown string, err error) {
+	upTmpl, downTmpl := c.ruleTemplates()
+	postUp, err = renderRuleTemplate("PostUp", upTmpl, data)
+	if err != nil {
+		return "", "", err
+	}
+	postDown, err = renderRuleTemplate("PostDown", downTmpl, data)
+	if err != nil {
+		return "", "", err
+	}
+	return postUp, postDown, nil
+}
+
+// vpnRules renders the PostUp/PostDown rules of a vpn on c's addressing.
+func (c Config) vpnRules(ifaceName, publicIface string, port, vpnOctet int) (postUp, postDown string, err error) {
+	data := FirewallRuleData{
+		MeshCIDR:    c.meshCIDR4(vpnOctet),
+		MeshCIDR6:   c.meshCIDR6(vpnOctet),
+		PublicIface: publicIface,
+		Port:        port,
+		Interface:   ifaceName,
+		BindAddress: c.BindAddress,
+		BindIPv6:    strings.Contains(c.BindAddress, ":"),
+	}
+	if postUp, postDown, err = c.renderRules(data); err != nil {
+		return "", "", err
+	}
+	if c.ObfuscationPostUp != "" {
+		hook, err := renderRuleTemplate("ObfuscationPostUp", c.ObfuscationPostUp, data)
+		if err != nil {
+			return "", "", err
+		}
+		postUp += " " + hook
+	}
+	if c.ObfuscationPostDown != "" {
+		hook, err := renderRuleTemplate("ObfuscationPostDown", c.ObfuscationPostDown, data)
+		if err != nil {
+			return "", "", err
+		}
+		postDown += " " + hook
+	}
+	return c.netnsRules(postUp), c.netnsRules(postDown), nil
+}
+
+// netnsRules runs rules inside Config.NetNS, so they land in the namespace
+// that holds the interface even when the config is brought up by hand.
+func (c Config) netnsRules(rules string) string {
+	if c.NetNS == "" {
+		return rules
+	}
+	return "ip netns exec " + c.NetNS + " sh -c '" + strings.ReplaceAll(rules, "'", `'\''`) + "'"
+}
+
+// RefreshRules re-renders the PostUp/PostDown rules of vpn from the current
+// config (firewall backend, templates, public interface) and rewrites only
+// those lines. The interface is taken down before the rewrite, so the old
+// PostDown removes the old rules, and brought up again after it.
+func (m *Manager) RefreshRules(ctx context.Context, vpn string) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("vpn", vpn); err != nil {
+		return rep, err
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	vpnPath := m.cfg.VPNConfigPath(vpn)
+	vpnBytes, err := os.ReadFile(vpnPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, vpnNotFound(vpn, vpnPath)
+		}
+		return rep, err
+	}
+	content := string(vpnBytes)
+	vpnDoc := parseINI(content)
+	vpnCfg := m.vpnConfig(vpnDoc)
+	vpnOctet, _, err := parseBPAddress(vpnCfg.SubnetPrefix, vpnDoc.First("Interface", "Address"))
+	if err != nil {
+		return rep, fmt.Errorf("vpn config %s: %w", vpnPath, err)
+	}
+	portStr := vpnDoc.First("Interface", "ListenPort")
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return rep, fmt.Errorf("invalid ListenPort %q in %s", portStr, vpnPath)
+	}
+	publicIface, err := m.detectDefaultInterface(ctx)
+	if err != nil {
+		return rep, err
+	}
+	postUp, postDown, err := vpnCfg.vpnRules(m.cfg.InterfaceName(vpn), publicIface, port, vpnOctet)
+	if err != nil {
+		return rep, err
+	}
+	content, _ = setSectionValue(content, "Interface", "PostUp", postUp)
+	content, _ = setSectionValue(content, "Interface", "PostDown", postDown)
+	if content == string(vpnBytes) {
+		return rep, nil
+	}
+	if err := m.backup(&rep, "refresh-rules-"+vpn, vpnPath); err != nil {
+		return rep, err
+	}
+	return rep, m.rewriteRules(ctx, &rep, vpn, vpnPath, content)
+}
+
+// rewriteRules writes a vpn config whose PostUp/PostDown changed between
+// taking the interface down and bringing it back up. It is brought up even
+// if the write fails, then still running the old rules.
+func (m *Manager) rewriteRules(ctx context.Context, rep *Report, vpn, vpnPath, content string) error {
+	m.maybeVPNStop(ctx, rep, vpn)
+	err := m.writeFile(vpnPath, []byte(content), rep)
+	m.maybeVPNStart(ctx, rep, vpn)
+	return err
+}
+
+// masqueradeIfaceRE finds the public interface in the iptables and nftables
+// masquerade rules.
+var masqueradeIfaceRE = regexp.MustCompile(`(-o|oifname) (\S+)( -j MASQUERADE| masquerade)`)
+
+// FixInterface points the masquerade rules of vpn at the interface that
+// detectDefaultInterface reports now, e.g. after eth0 became ens3 in a
+// migration. Only the interface name in PostUp/PostDown changes, between
+// taking the interface down and up as in RefreshRules; when the current
+// interface cannot be detected it warns and writes nothing.
+func (m *Manager) FixInterface(ctx context.Context, vpn string) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("vpn", vpn); err != nil {
+		return rep, err
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	vpnPath := m.cfg.VPNConfigPath(vpn)
+	vpnBytes, err := os.ReadFile(vpnPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, vpnNotFound(vpn, vpnPath)
+		}
+		return rep, err
+	}
+	iface, err := m.detectDefaultInterface(ctx)
+	if err != nil {
+		rep.warnf("could not detect the public interface, leaving %s unchanged: %v", vpnPath, err)
+		return rep, nil
+	}
+
+	lines := splitLines(string(vpnBytes))
+	start, end, ok := findSection(lines, "Interface")
+	found := false
+	for i := start + 1; ok && i < end; i++ {
+		k, _, isKV := splitKV(strings.TrimSpace(lines[i]))
+		if !isKV || (!strings.EqualFold(k, "PostUp") && !strings.EqualFold(k, "PostDown")) {
+			continue
+		}
+		lines[i] = masqueradeIfaceRE.ReplaceAllStringFunc(lines[i], func(rule string) string {
+			found = true
+			sub := masqueradeIfaceRE.FindStringSubmatch(rule)
+			return sub[1] + " " + iface + sub[3]
+		})
+	}
+	if !found {
+		rep.warnf("no masquerade rule found in %s; use RefreshRules to re-render them", vpnPath)
+		return rep, nil
+	}
+	content := strings.Join(lines, "\n")
+	if content == string(vpnBytes) {
+		return rep, nil
+	}
+
+	if err := m.backup(&rep, "fix-interface-"+vpn, vpnPath); err != nil {
+		return rep, err
+	}
+	return rep, m.rewriteRules(ctx, &rep, vpn, vpnPath, content)
+}
+
+func renderRuleTemplate(name, text string, data FirewallRuleData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render %s template: %w", name, err)
+	}
+	out := strings.TrimSpace(b.String())
+	if strings.ContainsAny(out, "\r\n") {
+		return "", fmt.Errorf("render %s template: result must be a single line", name)
+	}
+	return out, nil
+}
@@ -0,0 +1,250 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRenderRulesDefaultsToIPTables(t *testing.T) {
+	t.Parallel()
+
+	up, down, err := Config{}.renderRules(FirewallRuleData{MeshCIDR: "69.0.1.0/24", PublicIface: "eth0", Port: 55107, Interface: "bp-home"})
+	if err != nil {
+		t.Fatalf("renderRules returned error: %v", err)
+	}
+	wantUp := "iptables -t nat -A POSTROUTING -s 69.0.1.0/24 -o eth0 -j MASQUERADE; iptables -A INPUT -p udp -m udp --dport 55107 -j ACCEPT; iptables -A FORWARD -i bp-home -j ACCEPT; iptables -A FORWARD -o bp-home -j ACCEPT;"
+	if up != wantUp {
+		t.Fatalf("PostUp = %q, want %q", up, wantUp)
+	}
+	if !strings.HasPrefix(down, "iptables -t nat -D POSTROUTING") {
+		t.Fatalf("unexpected PostDown %q", down)
+	}
+}
+
+func TestRenderRulesCustomTemplates(t *testing.T) {
+	t.Parallel()
+
+	postUp, postDown := NFTablesTemplates()
+	cfg := Config{PostUpTemplate: postUp + " nft add rule inet {{.Interface}} input tcp dport 8080 accept;", PostDownTemplate: postDown}
+	up, down, err := cfg.renderRules(FirewallRuleData{MeshCIDR: "69.0.1.0/24", PublicIface: "eth0", Port: 55107, Interface: "bp-home"})
+	if err != nil {
+		t.Fatalf("renderRules returned error: %v", err)
+	}
+	if !strings.Contains(up, "ip saddr 69.0.1.0/24 oifname eth0 masquerade;") || !strings.HasSuffix(up, "tcp dport 8080 accept;") {
+		t.Fatalf("unexpected PostUp %q", up)
+	}
+	if down != "nft delete table inet bp-home;" {
+		t.Fatalf("unexpected PostDown %q", down)
+	}
+
+	bad := Config{PostUpTemplate: "{{.Nope"}
+	if err := bad.validate(); err == nil {
+		t.Fatal("expected unparsable template to be rejected")
+	}
+}
+
+func TestRenderVPNConfigWithNFTablesBackend(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{FirewallBackend: FirewallNFTables}, Dependencies{})
+	conf, err := mgr.renderVPNConfig(mgr.cfg, "home", "bp-home", "PRIV", 55107, 1, "eth0")
+	if err != nil {
+		t.Fatalf("renderVPNConfig returned error: %v", err)
+	}
+	if !strings.Contains(conf, "PostUp = nft add table inet bp-home;") || !strings.Contains(conf, "PostDown = nft delete table inet bp-home;") {
+		t.Fatalf("expected nft rules:\n%s", conf)
+	}
+	if strings.Contains(conf, "iptables") {
+		t.Fatalf("unexpected iptables rules:\n%s", conf)
+	}
+
+	if err := (Config{FirewallBackend: "pf"}).validate(); err == nil {
+		t.Fatal("expected unknown firewall backend to be rejected")
+	}
+}
+
+func TestRenderVPNConfigInNetNS(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{NetNS: "tenant1", FirewallBackend: FirewallNFTables}, Dependencies{})
+	conf, err := mgr.renderVPNConfig(mgr.cfg, "home", "bp-home", "PRIV", 55107, 1, "eth0")
+	if err != nil {
+		t.Fatalf("renderVPNConfig returned error: %v", err)
+	}
+	if !strings.Contains(conf, `PostUp = ip netns exec tenant1 sh -c 'nft add table inet bp-home; nft add chain inet bp-home input { type filter hook input priority 0 \; };`) {
+		t.Fatalf("expected PostUp wrapped in the namespace:\n%s", conf)
+	}
+	if !strings.Contains(conf, "PostDown = ip netns exec tenant1 sh -c 'nft delete table inet bp-home;'\n") {
+		t.Fatalf("expected PostDown wrapped in the namespace:\n%s", conf)
+	}
+
+	cfg := Config{NetNS: "tenant1", PostUpTemplate: "echo 'up {{.Interface}}'"}
+	up, _, err := cfg.vpnRules("bp-home", "eth0", 55107, 1)
+	if err != nil {
+		t.Fatalf("vpnRules returned error: %v", err)
+	}
+	if want := `ip netns exec tenant1 sh -c 'echo '\''up bp-home'\'''`; up != want {
+		t.Fatalf("PostUp = %q, want %q", up, want)
+	}
+}
+
+func TestRenderRulesBindAddress(t *testing.T) {
+	t.Parallel()
+
+	data := FirewallRuleData{MeshCIDR: "69.0.1.0/24", PublicIface: "eth0", Port: 55107, Interface: "bp-home", BindAddress: "198.51.100.4"}
+	up, down, err := Config{}.renderRules(data)
+	if err != nil {
+		t.Fatalf("renderRules returned error: %v", err)
+	}
+	drop := "iptables -A INPUT -p udp -m udp --dport 55107 ! -d 198.51.100.4 -j DROP; iptables -A INPUT -p udp -m udp --dport 55107 -j ACCEPT;"
+	if !strings.Contains(up, drop) {
+		t.Fatalf("PostUp %q lacks %q", up, drop)
+	}
+	if !strings.Contains(down, "iptables -D INPUT -p udp -m udp --dport 55107 ! -d 198.51.100.4 -j DROP;") {
+		t.Fatalf("PostDown %q does not remove the drop rule", down)
+	}
+
+	data.BindAddress, data.BindIPv6 = "2001:db8::4", true
+	up, _, err = Config{FirewallBackend: FirewallNFTables}.renderRules(data)
+	if err != nil {
+		t.Fatalf("renderRules returned error: %v", err)
+	}
+	if !strings.Contains(up, "nft add rule inet bp-home input ip6 daddr != 2001:db8::4 udp dport 55107 drop; nft add rule inet bp-home input udp dport 55107 accept;") {
+		t.Fatalf("unexpected nft PostUp %q", up)
+	}
+
+	mgr := NewManager(Config{BindAddress: "2001:db8::4"}, Dependencies{})
+	conf, err := mgr.renderVPNConfig(mgr.cfg, "home", "bp-home", "PRIV", 55107, 1, "eth0")
+	if err != nil {
+		t.Fatalf("renderVPNConfig returned error: %v", err)
+	}
+	if !strings.Contains(conf, "ip6tables -A INPUT -p udp -m udp --dport 55107 ! -d 2001:db8::4 -j DROP;") {
+		t.Fatalf("expected bind rule in vpn config:\n%s", conf)
+	}
+}
+
+func TestRefreshRules(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	cfg := mgr.Config()
+	path := cfg.VPNConfigPath("home")
+	old := readTestFile(t, path)
+	edited, _ := replaceLine(old, "ListenPort = 55107", "ListenPort = 55107\n# keep me")
+	writeTestFile(t, path, edited)
+
+	cfg.PublicInterface = "ens3"
+	cfg.FirewallBackend = FirewallNFTables
+	sys := &FakeSystem{RootValue: true, Commands: map[string]bool{"systemctl": true}}
+	mgr = NewManager(cfg, Dependencies{System: sys, Keys: &fakeKeys{}})
+	rep, err := mgr.RefreshRules(ctx, "home")
+	if err != nil {
+		t.Fatalf("RefreshRules returned error: %v", err)
+	}
+	got := readTestFile(t, path)
+	up := firstSectionValue(got, "Interface", "PostUp")
+	if !strings.HasPrefix(up, "nft add table inet bp-home;") || !strings.Contains(up, "oifname ens3 masquerade;") {
+		t.Fatalf("PostUp not refreshed: %q", up)
+	}
+	if down := firstSectionValue(got, "Interface", "PostDown"); down != "nft delete table inet bp-home;" {
+		t.Fatalf("PostDown not refreshed: %q", down)
+	}
+	var want []string
+	for _, line := range strings.Split(edited, "\n") {
+		if !strings.HasPrefix(line, "PostUp") && !strings.HasPrefix(line, "PostDown") {
+			want = append(want, line)
+		}
+	}
+	var kept []string
+	for _, line := range strings.Split(got, "\n") {
+		if !strings.HasPrefix(line, "PostUp") && !strings.HasPrefix(line, "PostDown") {
+			kept = append(kept, line)
+		}
+	}
+	if strings.Join(kept, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("other lines changed:\n%s", got)
+	}
+	if calls := strings.Join(sys.Calls(), "; "); calls != "systemctl stop wg-quick@bp-home; systemctl start wg-quick@bp-home" {
+		t.Fatalf("calls = %v", calls)
+	}
+	if len(rep.Changes) == 0 {
+		t.Fatalf("rewrite not reported: %#v", rep)
+	}
+	if rep, err := mgr.RefreshRules(ctx, "home"); err != nil || len(rep.Changes) != 0 || len(sys.Calls()) != 2 {
+		t.Fatalf("expected an unchanged refresh to neither write nor restart, got %#v, %v, calls %v", rep, err, sys.Calls())
+	}
+
+	// Without systemctl, wg-quick down only runs when the interface is up.
+	cfg.FirewallBackend = FirewallIPTables
+	quick := &FakeSystem{RootValue: true, Commands: map[string]bool{"wg-quick": true, "wg": true}, Outputs: map[string]string{"wg show bp-home": ""}}
+	mgr = NewManager(cfg, Dependencies{System: quick, Keys: &fakeKeys{}})
+	if _, err := mgr.RefreshRules(ctx, "home"); err != nil {
+		t.Fatalf("RefreshRules returned error: %v", err)
+	}
+	if calls := strings.Join(quick.Calls(), "; "); calls != "wg show bp-home; wg-quick down bp-home; wg-quick up bp-home" {
+		t.Fatalf("calls = %v", calls)
+	}
+
+	if _, err := mgr.RefreshRules(ctx, "missing"); !errors.Is(err, ErrVPNNotFound) {
+		t.Fatalf("expected ErrVPNNotFound, got %v", err)
+	}
+}
+
+func TestFixInterface(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	cfg := mgr.Config()
+	path := cfg.VPNConfigPath("home")
+	before := readTestFile(t, path)
+
+	cfg.PublicInterface = ""
+	undetected := NewManager(cfg, Dependencies{System: &FakeSystem{}, Keys: &fakeKeys{}, Net: fakeNetwork{}})
+	rep, err := undetected.FixInterface(ctx, "home")
+	if err != nil {
+		t.Fatalf("FixInterface returned error: %v", err)
+	}
+	if len(rep.Warnings) != 1 || !strings.Contains(rep.Warnings[0], "could not detect") || readTestFile(t, path) != before {
+		t.Fatalf("expected a warning and no change, got %#v", rep)
+	}
+
+	cfg.PublicInterface = "ens3"
+	sys := &FakeSystem{RootValue: true, Commands: map[string]bool{"systemctl": true}}
+	mgr = NewManager(cfg, Dependencies{System: sys, Keys: &fakeKeys{}})
+	if _, err := mgr.FixInterface(ctx, "home"); err != nil {
+		t.Fatalf("FixInterface returned error: %v", err)
+	}
+	want := strings.ReplaceAll(before, "-o eth0 -j MASQUERADE", "-o ens3 -j MASQUERADE")
+	if got := readTestFile(t, path); got != want {
+		t.Fatalf("unexpected config:\n%s", got)
+	}
+	if calls := strings.Join(sys.Calls(), "; "); calls != "systemctl stop wg-quick@bp-home; systemctl start wg-quick@bp-home" {
+		t.Fatalf("calls = %v", calls)
+	}
+
+	rep, err = mgr.FixInterface(ctx, "home")
+	if err != nil {
+		t.Fatalf("FixInterface returned error: %v", err)
+	}
+	if len(rep.Changes) != 0 || len(sys.Calls()) != 2 {
+		t.Fatalf("expected no change on second run, got %#v", rep)
+	}
+
+	cfg.FirewallBackend = "pf"
+	if _, err := NewManager(cfg, Dependencies{System: sys}).FixInterface(ctx, "home"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected invalid config to be rejected, got %v", err)
+	}
+}
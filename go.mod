@@ -1,3 +1,9 @@
 module github.com/tavocg/bypasser
 
 go 1.25
+
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.43.0
+)
@@ -0,0 +1,45 @@
+package bypasser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExportHosts renders "<ip> <peer>.<vpn>" lines for every peer of vpn, one per
+// address, suitable for /etc/hosts or a dnsmasq addn-hosts file.
+func (m *Manager) ExportHosts(vpn string) (string, error) {
+	if err := ValidateName("vpn", vpn); err != nil {
+		return "", err
+	}
+	return m.exportHosts(func(ref PeerRef) bool { return ref.VPN == vpn })
+}
+
+// ExportAllHosts is ExportHosts across every vpn.
+func (m *Manager) ExportAllHosts() (string, error) {
+	return m.exportHosts(func(PeerRef) bool { return true })
+}
+
+func (m *Manager) exportHosts(match func(PeerRef) bool) (string, error) {
+	peers, err := m.ListPeers()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, ref := range peers {
+		if !match(ref) {
+			continue
+		}
+		addrs, err := m.PeerAddress(ref.VPN, ref.Peer)
+		if err != nil {
+			return "", err
+		}
+		for _, addr := range strings.Split(addrs, ",") {
+			ip, _, _ := strings.Cut(strings.TrimSpace(addr), "/")
+			if ip == "" {
+				continue
+			}
+			fmt.Fprintf(&b, "%s %s.%s\n", ip, ref.Peer, ref.VPN)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,146 @@
+package bypasser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// InterfaceNameData is what Config.InterfaceNameTemplate is executed with.
+// Index is the lowest number whose name is not taken by another vpn's config
+// or peer files.
+type InterfaceNameData struct {
+	VPN   string
+	Index int
+}
+
+// maxInterfaceName is IFNAMSIZ minus the trailing NUL.
+const maxInterfaceName = 15
+
+var interfaceNameRE = regexp.MustCompile(`^[A-Za-z0-9_.=+-]+$`)
+
+func (c Config) executeInterfaceName(vpn string, index int) (string, error) {
+	tmpl, err := template.New("interface").Option("missingkey=error").Parse(c.InterfaceNameTemplate)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, InterfaceNameData{VPN: vpn, Index: index}); err != nil {
+		return "", err
+	}
+	name := b.String()
+	if len(name) > maxInterfaceName || !interfaceNameRE.MatchString(name) {
+		return "", fmt.Errorf("invalid interface name %q: use at most %d letters, numbers, '_', '.', '=', '+' or '-'", name, maxInterfaceName)
+	}
+	return name, nil
+}
+
+func (c Config) validateInterfaceNameTemplate() error {
+	a0, err := c.executeInterfaceName("a", 0)
+	if err != nil {
+		return fmt.Errorf("invalid interface name template %q: %w", c.InterfaceNameTemplate, err)
+	}
+	a1, err := c.executeInterfaceName("a", 1)
+	if err != nil {
+		return fmt.Errorf("invalid interface name template %q: %w", c.InterfaceNameTemplate, err)
+	}
+	b0, err := c.executeInterfaceName("b", 0)
+	if err != nil {
+		return fmt.Errorf("invalid interface name template %q: %w", c.InterfaceNameTemplate, err)
+	}
+	if a0 == a1 && a0 == b0 {
+		return fmt.Errorf("invalid interface name template %q: it must use .VPN or .Index", c.InterfaceNameTemplate)
+	}
+	return nil
+}
+
+// templateInterfaces maps every vpn in WireGuardDir to its interface name.
+// With InterfaceNameTemplate the file name does not carry the vpn, so it is
+// read from the "# bp-managed: vpn=" header; configs without one are not
+// bp's and only reserve their name in taken.
+func (c Config) templateInterfaces() (byVPN map[string]string, taken map[string]bool, err error) {
+	byVPN, taken = make(map[string]string), make(map[string]bool)
+	entries, err := os.ReadDir(c.WireGuardDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return byVPN, taken, nil
+		}
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".conf") {
+			continue
+		}
+		iface := strings.TrimSuffix(name, ".conf")
+		taken[iface] = true
+		b, err := os.ReadFile(filepath.Join(c.WireGuardDir, name))
+		if err != nil {
+			return nil, nil, err
+		}
+		if vpn := vpnMeta(parseINI(string(b)))["vpn"]; vpn != "" {
+			if _, dup := byVPN[vpn]; !dup {
+				byVPN[vpn] = iface
+			}
+		}
+	}
+	return byVPN, taken, nil
+}
+
+// templateInterfaceName returns the interface of an existing vpn, or the
+// first free one for a new vpn. Names still used by leftover peer files are
+// skipped so a new vpn never inherits another vpn's peers.
+func (c Config) templateInterfaceName(vpn string) string {
+	byVPN, taken, err := c.templateInterfaces()
+	if err != nil {
+		byVPN, taken = nil, nil
+	}
+	if iface, ok := byVPN[vpn]; ok {
+		return iface
+	}
+	var peerFiles []string
+	if entries, err := os.ReadDir(c.PeersDir()); err == nil {
+		for _, e := range entries {
+			peerFiles = append(peerFiles, e.Name())
+		}
+	}
+	inUse := func(iface string) bool {
+		if taken[iface] {
+			return true
+		}
+		for _, f := range peerFiles {
+			if strings.HasPrefix(f, iface+"-") {
+				return true
+			}
+		}
+		return false
+	}
+
+	prev := ""
+	for i := 0; ; i++ {
+		iface, err := c.executeInterfaceName(vpn, i)
+		if err != nil {
+			return c.InterfacePrefix + vpn
+		}
+		// A template without .Index yields the same name for every index.
+		if !inUse(iface) || iface == prev {
+			return iface
+		}
+		prev = iface
+	}
+}
+
+// vpnForPeerFile splits a peer file name into its vpn and peer using the
+// interface names in byVPN; the longest matching interface wins.
+func vpnForPeerFile(byVPN map[string]string, base string) (vpn, peer string) {
+	best := ""
+	for v, iface := range byVPN {
+		if rest, ok := strings.CutPrefix(base, iface+"-"); ok && rest != "" && len(iface) > len(best) {
+			best, vpn, peer = iface, v, rest
+		}
+	}
+	return vpn, peer
+}
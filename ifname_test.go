@@ -0,0 +1,88 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterfaceNameTemplate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{InterfaceNameTemplate: "wg{{.Index}}"})
+	cfg := mgr.Config()
+	// An unmanaged config keeps its name.
+	writeTestFile(t, filepath.Join(cfg.WireGuardDir, "wg0.conf"), "[Interface]\nPrivateKey = "+fakeKey("other")+"\n")
+
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	if got := cfg.InterfaceName("home"); got != "wg1" {
+		t.Fatalf("home interface = %q, want wg1", got)
+	}
+	if got := cfg.InterfaceName("work"); got != "wg2" {
+		t.Fatalf("work interface = %q, want wg2", got)
+	}
+	res, err := mgr.AddPeer(ctx, "work", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if want := filepath.Join(cfg.PeersDir(), "wg2-laptop.conf"); res.PeerConfigPath != want {
+		t.Fatalf("peer path = %q, want %q", res.PeerConfigPath, want)
+	}
+
+	vpns, err := mgr.ListVPNs()
+	if err != nil {
+		t.Fatalf("ListVPNs returned error: %v", err)
+	}
+	if strings.Join(vpns, ",") != "home,work" {
+		t.Fatalf("ListVPNs = %v", vpns)
+	}
+	peers, err := mgr.ListPeers()
+	if err != nil {
+		t.Fatalf("ListPeers returned error: %v", err)
+	}
+	if len(peers) != 1 || peers[0] != (PeerRef{VPN: "work", Peer: "laptop"}) {
+		t.Fatalf("ListPeers = %#v", peers)
+	}
+
+	// wg1 is freed by deleting home, but wg2's leftover peer keeps wg2 reserved.
+	if _, err := mgr.DeleteVPN(ctx, "home"); err != nil {
+		t.Fatalf("DeleteVPN returned error: %v", err)
+	}
+	if _, err := mgr.DeleteVPN(ctx, "work"); err != nil {
+		t.Fatalf("DeleteVPN returned error: %v", err)
+	}
+	if got := cfg.InterfaceName("lab"); got != "wg1" {
+		t.Fatalf("lab interface = %q, want wg1", got)
+	}
+	if _, err := mgr.AddVPN(ctx, "lab"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if got := cfg.InterfaceName("next"); got != "wg3" {
+		t.Fatalf("next interface = %q, want wg3", got)
+	}
+}
+
+func TestInterfaceNameTemplateValidation(t *testing.T) {
+	t.Parallel()
+
+	for _, tmpl := range []string{"wg0", "wg{{.Index", "{{.Missing}}", "a-very-long-{{.VPN}}-name"} {
+		cfg := Config{WireGuardDir: t.TempDir(), InterfaceNameTemplate: tmpl}
+		if err := cfg.Validate(); !errors.Is(err, ErrValidation) {
+			t.Fatalf("template %q: expected ErrValidation, got %v", tmpl, err)
+		}
+	}
+	cfg := Config{WireGuardDir: t.TempDir(), InterfaceNameTemplate: "wg-{{.VPN}}"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate returned error: %v", err)
+	}
+	if got := cfg.InterfaceName("home"); got != "wg-home" {
+		t.Fatalf("interface = %q, want wg-home", got)
+	}
+}
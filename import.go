@@ -0,0 +1,134 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ImportPeer adopts an existing client config. The server block's PublicKey is
+// derived from the client's [Interface].PrivateKey; the client's [Peer].PublicKey
+// is the server key and is only checked against this VPN.
+func (m *Manager) ImportPeer(ctx context.Context, vpnName, peerName string, clientConf string) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("vpn", vpnName); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("peer", peerName); err != nil {
+		return rep, err
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+	ref := PeerRef{VPN: vpnName, Peer: peerName}
+
+	vpnPath := m.cfg.VPNConfigPath(vpnName)
+	vpnBytes, err := os.ReadFile(vpnPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, vpnNotFound(vpnName, vpnPath)
+		}
+		return rep, err
+	}
+	vpnContent := string(vpnBytes)
+
+	peerPath := m.cfg.PeerConfigPath(vpnName, peerName)
+	if _, err := os.Stat(peerPath); err == nil {
+		return rep, peerExists(ref, peerPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return rep, err
+	}
+
+	clientDoc := parseINI(clientConf)
+	vpnDoc := parseINI(vpnContent)
+	addr := clientDoc.First("Interface", "Address")
+	if addr == "" {
+		return rep, errorf(ErrValidation, "imported client config is missing Interface.Address")
+	}
+	peerPriv := clientDoc.First("Interface", "PrivateKey")
+	if peerPriv == "" {
+		return rep, errorf(ErrValidation, "imported client config is missing Interface.PrivateKey")
+	}
+	serverPubInConf := clientDoc.First("Peer", "PublicKey")
+	if serverPubInConf == "" {
+		return rep, errorf(ErrValidation, "imported client config is missing Peer.PublicKey")
+	}
+	if !isValidWGKey(peerPriv) {
+		return rep, errorf(ErrValidation, "imported client config has malformed Interface.PrivateKey: expected 32 base64-encoded bytes")
+	}
+	if !isValidWGKey(serverPubInConf) {
+		return rep, errorf(ErrValidation, "imported client config has malformed Peer.PublicKey: expected 32 base64-encoded bytes")
+	}
+
+	serverAddr := vpnDoc.First("Interface", "Address")
+	vpnCfg := m.vpnConfig(vpnDoc)
+	vpnOctet, _, err := parseBPAddress(vpnCfg.SubnetPrefix, serverAddr)
+	if err != nil {
+		return rep, fmt.Errorf("vpn config %s: %w", vpnPath, err)
+	}
+	peerOctet, host, err := parseBPAddress(vpnCfg.SubnetPrefix, addr)
+	if err != nil {
+		return rep, errorf(ErrValidation, "imported client config: %w", err)
+	}
+	if peerOctet != vpnOctet {
+		return rep, errorf(ErrValidation, "imported address %q is outside vpn %q subnet %s", addr, vpnName, vpnCfg.meshCIDR4(vpnOctet))
+	}
+	if host <= 1 || vpnCfg.usedPeerHostOctets(vpnDoc, vpnOctet)[host] {
+		return rep, errorf(ErrAlreadyExists, "imported address %q collides with an existing address in vpn %q", addr, vpnName)
+	}
+
+	peerPub, err := m.keys.DerivePublicKey(ctx, peerPriv)
+	if err != nil {
+		return rep, err
+	}
+	if serverPriv := vpnDoc.First("Interface", "PrivateKey"); serverPriv != "" {
+		if !isValidWGKey(serverPriv) {
+			return rep, errorf(ErrValidation, "vpn config %s has malformed PrivateKey: expected 32 base64-encoded bytes", vpnPath)
+		}
+		serverPub, err := m.keys.DerivePublicKey(ctx, serverPriv)
+		if err != nil {
+			return rep, err
+		}
+		if serverPub != serverPubInConf {
+			rep.warnf("imported config targets server key %s but vpn %q uses %s; the client will not connect until its [Peer].PublicKey is updated", serverPubInConf, vpnName, serverPub)
+		}
+	}
+	psk := clientDoc.First("Peer", "PresharedKey")
+	if psk != "" && !isValidWGKey(psk) {
+		return rep, errorf(ErrValidation, "imported client config has malformed Peer.PresharedKey: expected 32 base64-encoded bytes")
+	}
+
+	allowed := normalizeCIDR(addr, m.cfg.PeerMask)
+	serverBlock := m.renderServerPeerBlock(vpnName, peerName, peerPub, psk, allowed)
+	vpnDoc.Append(parseINI(serverBlock))
+	updatedVPN := vpnDoc.String()
+
+	var kept []string
+	for _, line := range splitLines(clientConf) {
+		if strings.HasPrefix(strings.TrimSpace(line), "# bp-managed:") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	peerConf := peerMetaLine(vpnName, peerName) + "\n" + strings.TrimLeft(strings.Join(kept, "\n"), "\n")
+	peerConf = strings.TrimRight(peerConf, "\n") + "\n"
+
+	if err := m.writeFile(vpnPath, []byte(updatedVPN), &rep); err != nil {
+		return rep, err
+	}
+	if err := m.writeFile(peerPath, []byte(peerConf), &rep); err != nil {
+		return rep, err
+	}
+
+	m.maybeVPNRestart(ctx, &rep, vpnName)
+	m.updateInventory(&rep)
+	return rep, nil
+}
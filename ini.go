@@ -0,0 +1,216 @@
+package bypasser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// INIDocument is a parsed wg-quick style config: ordered sections whose
+// repeated keys (and repeated sections such as [Peer]) are preserved. The raw
+// text is kept alongside, so String returns the input byte for byte and edits
+// only touch the sections they add or remove.
+type INIDocument struct {
+	Sections []*INISection
+}
+
+type INISection struct {
+	// Name is empty for the preamble: anything before the first section header
+	// that is not a comment attached to it.
+	Name    string
+	Entries []INIEntry
+
+	// lines holds the comments directly above the header (no blank line in
+	// between), the header itself and everything up to the next section.
+	lines  []string
+	header int
+}
+
+type INIEntry struct {
+	Key   string
+	Value string
+}
+
+// ParseINI parses content strictly: any line that is not blank, a comment,
+// a [Section] header or a key = value pair is an error.
+func ParseINI(content string) (*INIDocument, error) {
+	return parseINIDocument(content, true)
+}
+
+// parseINI is the lenient variant used internally; malformed lines are kept
+// verbatim but otherwise ignored, the way wg-quick configs were always read here.
+func parseINI(content string) *INIDocument {
+	doc, _ := parseINIDocument(content, false)
+	return doc
+}
+
+func parseINIDocument(content string, strict bool) (*INIDocument, error) {
+	cur := &INISection{header: -1}
+	sections := []*INISection{cur}
+	for i, raw := range strings.Split(content, "\n") {
+		line := strings.TrimSpace(raw)
+		switch {
+		case isSectionHeader(line):
+			k := len(cur.lines)
+			for k-1 > cur.header && isCommentLine(cur.lines[k-1]) {
+				k--
+			}
+			next := &INISection{Name: strings.TrimSpace(strings.Trim(line, "[]"))}
+			next.lines = append(next.lines, cur.lines[k:]...)
+			next.header = len(next.lines)
+			next.lines = append(next.lines, raw)
+			cur.lines = cur.lines[:k]
+			cur = next
+			sections = append(sections, cur)
+		case line == "" || isCommentLine(line):
+			cur.lines = append(cur.lines, raw)
+		default:
+			cur.lines = append(cur.lines, raw)
+			k, v, ok := splitKV(line)
+			if !ok {
+				if strict {
+					return nil, fmt.Errorf("line %d: expected [Section] or key = value, got %q", i+1, line)
+				}
+				continue
+			}
+			cur.Entries = append(cur.Entries, INIEntry{Key: k, Value: v})
+		}
+	}
+	if pre := sections[0]; len(pre.lines) == 0 {
+		sections = sections[1:]
+	}
+	return &INIDocument{Sections: sections}, nil
+}
+
+func isCommentLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";")
+}
+
+func (d *INIDocument) String() string {
+	var lines []string
+	for _, s := range d.Sections {
+		lines = append(lines, s.lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// First returns the first value of key (case-insensitive) in the first
+// section named section that has it, or "".
+func (d *INIDocument) First(section, key string) string {
+	for _, s := range d.Sections {
+		if s.Name != section {
+			continue
+		}
+		if v, ok := s.Get(key); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// All returns every value of key across all sections named section, in order.
+func (d *INIDocument) All(section, key string) []string {
+	var out []string
+	for _, s := range d.Sections {
+		if s.Name != section {
+			continue
+		}
+		for _, e := range s.Entries {
+			if strings.EqualFold(e.Key, key) {
+				out = append(out, e.Value)
+			}
+		}
+	}
+	return out
+}
+
+// SectionsNamed returns every section called name, e.g. all [Peer] blocks.
+func (d *INIDocument) SectionsNamed(name string) []*INISection {
+	var out []*INISection
+	for _, s := range d.Sections {
+		if s.Name == name {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Append adds the sections of other after a single blank line, dropping any
+// blank lines at the end of d first.
+func (d *INIDocument) Append(other *INIDocument) {
+	for len(d.Sections) > 0 {
+		last := d.Sections[len(d.Sections)-1]
+		for len(last.lines) > last.header+1 && strings.TrimSpace(last.lines[len(last.lines)-1]) == "" {
+			last.lines = last.lines[:len(last.lines)-1]
+		}
+		if len(last.lines) > 0 {
+			last.lines = append(last.lines, "")
+			break
+		}
+		d.Sections = d.Sections[:len(d.Sections)-1]
+	}
+	d.Sections = append(d.Sections, other.Sections...)
+}
+
+// RemoveSection drops s together with its attached comments. Blank and comment
+// lines after its last entry are kept, since they usually describe what follows.
+func (d *INIDocument) RemoveSection(s *INISection) bool {
+	idx := -1
+	for i, cand := range d.Sections {
+		if cand == s {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return false
+	}
+
+	tail := s.trailer()
+	rest := append([]*INISection{}, d.Sections[idx+1:]...)
+	d.Sections = d.Sections[:idx]
+	if len(tail) == 0 {
+		d.Sections = append(d.Sections, rest...)
+		return true
+	}
+	if idx == 0 {
+		d.Sections = append(d.Sections, &INISection{lines: tail, header: -1})
+	} else {
+		prev := d.Sections[idx-1]
+		if n := len(prev.lines); n > prev.header+1 && strings.TrimSpace(prev.lines[n-1]) == "" && strings.TrimSpace(tail[0]) == "" {
+			prev.lines = prev.lines[:n-1]
+		}
+		prev.lines = append(prev.lines, tail...)
+	}
+	d.Sections = append(d.Sections, rest...)
+	return true
+}
+
+func (s *INISection) Get(key string) (string, bool) {
+	for _, e := range s.Entries {
+		if strings.EqualFold(e.Key, key) {
+			return e.Value, true
+		}
+	}
+	return "", false
+}
+
+// Comments returns the comment lines attached directly above the header.
+func (s *INISection) Comments() []string {
+	var out []string
+	for _, line := range s.lines[:max(s.header, 0)] {
+		out = append(out, strings.TrimSpace(line))
+	}
+	return out
+}
+
+func (s *INISection) trailer() []string {
+	last := s.header
+	for i := s.header + 1; i < len(s.lines); i++ {
+		t := strings.TrimSpace(s.lines[i])
+		if t != "" && !isCommentLine(t) {
+			last = i
+		}
+	}
+	return append([]string{}, s.lines[last+1:]...)
+}
@@ -0,0 +1,67 @@
+package bypasser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseINI(t *testing.T) {
+	t.Parallel()
+
+	doc, err := ParseINI(`# bp-managed: vpn=home
+[Interface]
+Address = 69.0.1.1/24
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PostUp = iptables -A FORWARD -o %i -j ACCEPT
+
+; a user comment
+[Peer]
+PublicKey = AAA
+AllowedIPs = 69.0.1.2/32
+
+[Peer]
+publickey = BBB
+AllowedIPs = 69.0.1.3/32
+`)
+	if err != nil {
+		t.Fatalf("ParseINI returned error: %v", err)
+	}
+	if got := doc.First("Interface", "address"); got != "69.0.1.1/24" {
+		t.Fatalf("unexpected address %q", got)
+	}
+	if got := doc.All("Interface", "PostUp"); len(got) != 2 || !strings.Contains(got[1], "-o %i") {
+		t.Fatalf("unexpected PostUp values %#v", got)
+	}
+	if got := doc.All("Peer", "PublicKey"); strings.Join(got, ",") != "AAA,BBB" {
+		t.Fatalf("unexpected peer keys %#v", got)
+	}
+	if got := len(doc.SectionsNamed("Peer")); got != 2 {
+		t.Fatalf("expected 2 peer sections, got %d", got)
+	}
+	if got := doc.First("Peer", "Endpoint"); got != "" {
+		t.Fatalf("expected empty missing key, got %q", got)
+	}
+
+	if _, err := ParseINI("[Interface]\nnot a pair\n"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected strict parse error, got %v", err)
+	}
+	if got := parseINI("[Interface]\nnot a pair\nAddress = x\n").First("Interface", "Address"); got != "x" {
+		t.Fatalf("lenient parse lost value: %q", got)
+	}
+}
+
+func TestINIDocumentRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	in := "# bp-managed: vpn=home\n[Interface]\nAddress = 69.0.1.1/24\n  MTU=1420   \n\n\n; odd\n[Peer]\nPublicKey = AAA\n"
+	doc, err := ParseINI(in)
+	if err != nil {
+		t.Fatalf("ParseINI returned error: %v", err)
+	}
+	if got := doc.String(); got != in {
+		t.Fatalf("round trip changed content:\n%q\n%q", in, got)
+	}
+	if got := doc.SectionsNamed("Peer")[0].Comments(); len(got) != 1 || got[0] != "; odd" {
+		t.Fatalf("unexpected attached comments %#v", got)
+	}
+}
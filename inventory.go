@@ -0,0 +1,100 @@
+package bypasser
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Inventory struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	VPNs        []InventoryVPN `json:"vpns"`
+}
+
+type InventoryVPN struct {
+	Name       string          `json:"name"`
+	Interface  string          `json:"interface"`
+	ListenPort int             `json:"listen_port,omitempty"`
+	Address    string          `json:"address,omitempty"`
+	Subnets    []string        `json:"subnets,omitempty"`
+	Peers      []InventoryPeer `json:"peers"`
+}
+
+// InventoryPeer is a [Peer] block of the vpn config; Name is empty for blocks
+// bp did not create.
+type InventoryPeer struct {
+	Name       string `json:"name,omitempty"`
+	AllowedIPs string `json:"allowed_ips,omitempty"`
+	PublicKey  string `json:"public_key"`
+}
+
+// Inventory describes every vpn and the peers its config accepts.
+func (m *Manager) Inventory() (Inventory, error) {
+	inv := Inventory{GeneratedAt: m.now().UTC(), VPNs: []InventoryVPN{}}
+	summaries, err := m.ListAll()
+	if err != nil {
+		return inv, err
+	}
+	for _, s := range summaries {
+		if s.Orphaned {
+			continue
+		}
+		v := InventoryVPN{Name: s.Name, Interface: s.Interface, ListenPort: s.ListenPort, Address: s.Address, Peers: []InventoryPeer{}}
+		for _, addr := range strings.Split(s.Address, ",") {
+			if _, subnet, err := net.ParseCIDR(strings.TrimSpace(addr)); err == nil {
+				v.Subnets = append(v.Subnets, subnet.String())
+			}
+		}
+		b, err := os.ReadFile(m.cfg.VPNConfigPath(s.Name))
+		if err != nil {
+			return inv, err
+		}
+		for _, block := range peerBlocks(string(b)) {
+			p := InventoryPeer{PublicKey: block.PublicKey, AllowedIPs: block.AllowedIPs}
+			if block.Meta != nil {
+				p.Name = block.Meta["peer"]
+			}
+			v.Peers = append(v.Peers, p)
+		}
+		inv.VPNs = append(inv.VPNs, v)
+	}
+	return inv, nil
+}
+
+// WriteInventory atomically writes Inventory as JSON to path with
+// Config.FilePerm, since it reveals every vpn's layout and public keys.
+func (m *Manager) WriteInventory(path string) error {
+	inv, err := m.Inventory()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), m.cfg.DirPerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'), m.cfg.FilePerm)
+}
+
+// updateInventory refreshes Config.InventoryFile after a successful change.
+// The change already happened, so a failure is only a warning.
+func (m *Manager) updateInventory(rep *Report) {
+	path := m.cfg.InventoryFile
+	if path == "" {
+		return
+	}
+	if m.cfg.DryRun {
+		rep.addChange("would-update", path)
+		return
+	}
+	if err := m.WriteInventory(path); err != nil {
+		rep.warnf("could not update inventory %s: %v", path, err)
+		return
+	}
+	rep.addChange("updated", path)
+}
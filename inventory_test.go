@@ -0,0 +1,119 @@
+package bypasser
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInventoryFileFollowsMutations(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	invPath := filepath.Join(t.TempDir(), "state", "inventory.json")
+	mgr := newTestManager(t, Config{InventoryFile: invPath})
+	read := func() Inventory {
+		t.Helper()
+		info, err := os.Stat(invPath)
+		if err != nil {
+			t.Fatalf("inventory not written: %v", err)
+		}
+		if perm := info.Mode().Perm(); perm&0o077 != 0 {
+			t.Fatalf("inventory permissions %#o are too open", perm)
+		}
+		var inv Inventory
+		if err := json.Unmarshal([]byte(readTestFile(t, invPath)), &inv); err != nil {
+			t.Fatalf("invalid inventory json: %v", err)
+		}
+		return inv
+	}
+
+	res, err := mgr.AddVPN(ctx, "home")
+	if err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if len(res.Report.Changes) == 0 || res.Report.Changes[len(res.Report.Changes)-1].Path != invPath {
+		t.Fatalf("inventory update not reported: %#v", res.Report.Changes)
+	}
+	inv := read()
+	if len(inv.VPNs) != 1 || inv.VPNs[0].Name != "home" || inv.VPNs[0].ListenPort != 55107 || len(inv.VPNs[0].Subnets) != 1 || inv.VPNs[0].Subnets[0] != "69.0.1.0/24" {
+		t.Fatalf("unexpected inventory after AddVPN: %#v", inv)
+	}
+
+	peer, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	inv = read()
+	peers := inv.VPNs[0].Peers
+	wantKey := fakePub(firstSectionValue(peer.PeerConfig, "Interface", "PrivateKey"))
+	if len(peers) != 1 || peers[0].Name != "laptop" || peers[0].AllowedIPs != "69.0.1.2/32" || peers[0].PublicKey != wantKey {
+		t.Fatalf("unexpected peers after AddPeer: %#v", peers)
+	}
+
+	if _, err := mgr.DeletePeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("DeletePeer returned error: %v", err)
+	}
+	if inv = read(); len(inv.VPNs[0].Peers) != 0 {
+		t.Fatalf("peer still listed after DeletePeer: %#v", inv.VPNs[0].Peers)
+	}
+	if _, err := mgr.DeleteVPN(ctx, "home"); err != nil {
+		t.Fatalf("DeleteVPN returned error: %v", err)
+	}
+	if inv = read(); len(inv.VPNs) != 0 {
+		t.Fatalf("vpn still listed after DeleteVPN: %#v", inv.VPNs)
+	}
+}
+
+func TestInventoryFileFollowsRekeyAndMove(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	invPath := filepath.Join(t.TempDir(), "inventory.json")
+	mgr := newTestManager(t, Config{InventoryFile: invPath})
+	read := func() Inventory {
+		t.Helper()
+		var inv Inventory
+		if err := json.Unmarshal([]byte(readTestFile(t, invPath)), &inv); err != nil {
+			t.Fatalf("invalid inventory json: %v", err)
+		}
+		return inv
+	}
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+
+	res, err := mgr.RegenerateKeys(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("RegenerateKeys returned error: %v", err)
+	}
+	wantKey := fakePub(firstSectionValue(res.PeerConfig, "Interface", "PrivateKey"))
+	if peers := read().VPNs[0].Peers; len(peers) != 1 || peers[0].PublicKey != wantKey {
+		t.Fatalf("inventory not refreshed after RegenerateKeys: %#v", peers)
+	}
+
+	if _, err := mgr.MovePeer(ctx, "home", "work", "laptop"); err != nil {
+		t.Fatalf("MovePeer returned error: %v", err)
+	}
+	inv := read()
+	if len(inv.VPNs[0].Peers) != 0 {
+		t.Fatalf("peer still listed under home after MovePeer: %#v", inv.VPNs[0].Peers)
+	}
+	if peers := inv.VPNs[1].Peers; len(peers) != 1 || peers[0].Name != "laptop" || peers[0].AllowedIPs != "69.0.2.2/32" {
+		t.Fatalf("peer not listed under work after MovePeer: %#v", peers)
+	}
+
+	if _, err := mgr.MigrateAddressing(ctx, "work", "10.8", 5); err != nil {
+		t.Fatalf("MigrateAddressing returned error: %v", err)
+	}
+	if v := read().VPNs[1]; len(v.Subnets) != 1 || v.Subnets[0] != "10.8.5.0/24" || v.Peers[0].AllowedIPs != "10.8.5.2/32" {
+		t.Fatalf("inventory not refreshed after MigrateAddressing: %#v", v)
+	}
+}
@@ -0,0 +1,315 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+func (m *Manager) RegenerateKeys(ctx context.Context, vpnName, peerName string) (AddPeerResult, error) {
+	var out AddPeerResult
+	if err := m.cfg.validate(); err != nil {
+		return out, err
+	}
+	if err := ValidateName("vpn", vpnName); err != nil {
+		return out, err
+	}
+	if err := ValidateName("peer", peerName); err != nil {
+		return out, err
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return out, err
+	}
+	defer unlock()
+	ref := PeerRef{VPN: vpnName, Peer: peerName}
+
+	peerPath := m.cfg.PeerConfigPath(vpnName, peerName)
+	peerBytes, err := os.ReadFile(peerPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return out, peerNotFound(ref, peerPath)
+		}
+		return out, err
+	}
+	vpnPath := m.cfg.VPNConfigPath(vpnName)
+	vpnBytes, err := os.ReadFile(vpnPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return out, vpnNotFound(vpnName, vpnPath)
+		}
+		return out, err
+	}
+
+	meta := peerMetaLine(vpnName, peerName)
+	if _, _, ok := findManagedPeerBlock(splitLines(string(vpnBytes)), meta); !ok {
+		return out, fmt.Errorf("peer block for %s not found in %s (missing %q comment)", ref.String(), vpnPath, meta)
+	}
+	if m.cfg.RequireClientPublicKey {
+		return out, errorf(ErrValidation, "cannot regenerate keys for %s: the server does not generate client keys", ref.String())
+	}
+
+	peerPriv, err := m.keys.GeneratePrivateKey(ctx)
+	if err != nil {
+		return out, err
+	}
+	peerPub, err := m.keys.DerivePublicKey(ctx, peerPriv)
+	if err != nil {
+		return out, err
+	}
+	psk, err := m.presharedKey(ctx)
+	if err != nil {
+		return out, err
+	}
+
+	updatedVPN, _ := setManagedPeerValues(string(vpnBytes), meta, "PublicKey", peerPub)
+	clientConf, ok := replaceLine(string(peerBytes), clientKeyPlaceholder, "PrivateKey = "+peerPriv)
+	if !ok {
+		clientConf, ok = setSectionValue(clientConf, "Interface", "PrivateKey", peerPriv)
+	}
+	if !ok {
+		return out, fmt.Errorf("peer file %s is missing an [Interface] section", peerPath)
+	}
+	if psk != "" {
+		updatedVPN, _ = setManagedPeerValues(updatedVPN, meta, "PresharedKey", psk)
+		clientConf, ok = setSectionValue(clientConf, "Peer", "PresharedKey", psk)
+		if !ok {
+			return out, fmt.Errorf("peer file %s is missing a [Peer] section", peerPath)
+		}
+	} else {
+		updatedVPN = removeManagedPeerValue(updatedVPN, meta, "PresharedKey")
+		clientConf = removeSectionValue(clientConf, "Peer", "PresharedKey")
+	}
+
+	if err := m.backup(&out.Report, "regenerate-keys-"+vpnName+"-"+peerName, vpnPath, peerPath); err != nil {
+		return out, err
+	}
+	if err := m.writeFile(vpnPath, []byte(updatedVPN), &out.Report); err != nil {
+		return out, err
+	}
+	if err := m.writeFile(peerPath, []byte(clientConf), &out.Report); err != nil {
+		return out, err
+	}
+
+	out.PeerRef = ref
+	out.PeerConfigPath = peerPath
+	out.PeerConfig = clientConf
+
+	m.maybeVPNRestart(ctx, &out.Report, vpnName)
+	m.updateInventory(&out.Report)
+	return out, nil
+}
+
+func (m *Manager) RotateVPNKeys(ctx context.Context, vpnName string) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("vpn", vpnName); err != nil {
+		return rep, err
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	vpnPath := m.cfg.VPNConfigPath(vpnName)
+	vpnBytes, err := os.ReadFile(vpnPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, vpnNotFound(vpnName, vpnPath)
+		}
+		return rep, err
+	}
+
+	serverPriv, err := m.keys.GeneratePrivateKey(ctx)
+	if err != nil {
+		return rep, err
+	}
+	serverPub, err := m.keys.DerivePublicKey(ctx, serverPriv)
+	if err != nil {
+		return rep, err
+	}
+	updatedVPN, ok := setSectionValue(string(vpnBytes), "Interface", "PrivateKey", serverPriv)
+	if !ok {
+		return rep, fmt.Errorf("vpn config %s is missing an [Interface] section", vpnPath)
+	}
+
+	peers, err := m.ListPeers()
+	if err != nil {
+		return rep, err
+	}
+	updatedPeers := make(map[string]string)
+	for _, p := range peers {
+		if p.VPN != vpnName {
+			continue
+		}
+		path := m.cfg.PeerConfigPath(p.VPN, p.Peer)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return rep, err
+		}
+		conf, ok := setSectionValue(string(b), "Peer", "PublicKey", serverPub)
+		if !ok {
+			rep.warnf("peer file %s has no [Peer] section; server public key not updated", path)
+			continue
+		}
+		updatedPeers[path] = conf
+	}
+
+	backupPaths := []string{vpnPath}
+	for path := range updatedPeers {
+		backupPaths = append(backupPaths, path)
+	}
+	if err := m.backup(&rep, "rotate-keys-"+vpnName, backupPaths...); err != nil {
+		return rep, err
+	}
+	if err := m.writeFile(vpnPath, []byte(updatedVPN), &rep); err != nil {
+		return rep, err
+	}
+	for _, p := range peers {
+		path := m.cfg.PeerConfigPath(p.VPN, p.Peer)
+		conf, ok := updatedPeers[path]
+		if !ok {
+			continue
+		}
+		if err := m.writeFile(path, []byte(conf), &rep); err != nil {
+			return rep, err
+		}
+	}
+
+	m.maybeVPNRestart(ctx, &rep, vpnName)
+	m.updateInventory(&rep)
+	return rep, nil
+}
+
+// FindPeerByPublicKey returns the peer whose public key is pubkey, e.g. to
+// name the keys printed by wg show. The server blocks' PublicKey is checked
+// first; peers without a managed block are matched by deriving the key from
+// their stored private key.
+func (m *Manager) FindPeerByPublicKey(ctx context.Context, pubkey string) (PeerRef, error) {
+	if !isValidWGKey(pubkey) {
+		return PeerRef{}, errorf(ErrValidation, "invalid public key %q: expected a base64-encoded 32-byte key", pubkey)
+	}
+	vpns, err := m.ListVPNs()
+	if err != nil {
+		return PeerRef{}, err
+	}
+	for _, vpn := range vpns {
+		b, err := os.ReadFile(m.cfg.VPNConfigPath(vpn))
+		if err != nil {
+			return PeerRef{}, err
+		}
+		for _, block := range peerBlocks(string(b)) {
+			if block.PublicKey == pubkey && block.Meta != nil && block.Meta["peer"] != "" {
+				return PeerRef{VPN: vpn, Peer: block.Meta["peer"]}, nil
+			}
+		}
+	}
+
+	peers, err := m.ListPeers()
+	if err != nil {
+		return PeerRef{}, err
+	}
+	for _, p := range peers {
+		b, err := os.ReadFile(m.cfg.PeerConfigPath(p.VPN, p.Peer))
+		if err != nil {
+			return PeerRef{}, err
+		}
+		if m.peerPublicKey(ctx, string(b)) == pubkey {
+			return p, nil
+		}
+	}
+	return PeerRef{}, errorf(ErrPeerNotFound, "no peer has public key %s", pubkey)
+}
+
+// peerPublicKey derives the public key of a client config's private key, or
+// returns "" when it holds none (e.g. the owner kept their own key).
+func (m *Manager) peerPublicKey(ctx context.Context, peerConf string) string {
+	priv := firstSectionValue(peerConf, "Interface", "PrivateKey")
+	if priv == "" {
+		return ""
+	}
+	pub, err := m.keys.DerivePublicKey(ctx, priv)
+	if err != nil {
+		return ""
+	}
+	return pub
+}
+
+// Kinds of KeyMismatch.
+const (
+	// KeyMismatchServer: a client's [Peer] PublicKey is not the server's.
+	KeyMismatchServer = "server-public-key"
+	// KeyMismatchPeer: the server block's PublicKey is not the one derived
+	// from the client's private key.
+	KeyMismatchPeer = "peer-public-key"
+)
+
+type KeyMismatch struct {
+	Peer     string `json:"peer"`
+	Kind     string `json:"kind"`
+	Path     string `json:"path"`
+	Expected string `json:"expected"`
+	Found    string `json:"found"`
+}
+
+// VerifyKeys checks that every peer file of vpn names the server public key
+// derived from the vpn's private key and that, for peers whose private key is
+// stored, the server block carries the matching public key. Mismatches are
+// returned, not reported as errors; Path is the file holding the wrong key.
+func (m *Manager) VerifyKeys(ctx context.Context, vpn string) ([]KeyMismatch, error) {
+	if err := ValidateName("vpn", vpn); err != nil {
+		return nil, err
+	}
+	vpnPath := m.cfg.VPNConfigPath(vpn)
+	vpnBytes, err := os.ReadFile(vpnPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, vpnNotFound(vpn, vpnPath)
+		}
+		return nil, err
+	}
+	serverPriv := firstSectionValue(string(vpnBytes), "Interface", "PrivateKey")
+	serverPub, err := m.keys.DerivePublicKey(ctx, serverPriv)
+	if err != nil {
+		return nil, fmt.Errorf("vpn config %s: cannot derive the server public key: %w", vpnPath, err)
+	}
+	blockKeys := make(map[string]string)
+	for _, block := range peerBlocks(string(vpnBytes)) {
+		if block.Meta != nil && block.Meta["peer"] != "" {
+			blockKeys[block.Meta["peer"]] = block.PublicKey
+		}
+	}
+
+	peers, err := m.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+	var out []KeyMismatch
+	for _, p := range peers {
+		if p.VPN != vpn {
+			continue
+		}
+		path := m.cfg.PeerConfigPath(p.VPN, p.Peer)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if found := firstSectionValue(string(b), "Peer", "PublicKey"); found != serverPub {
+			out = append(out, KeyMismatch{Peer: p.Peer, Kind: KeyMismatchServer, Path: path, Expected: serverPub, Found: found})
+		}
+		derived := m.peerPublicKey(ctx, string(b))
+		if derived == "" {
+			continue
+		}
+		if found := blockKeys[p.Peer]; found != derived {
+			out = append(out, KeyMismatch{Peer: p.Peer, Kind: KeyMismatchPeer, Path: vpnPath, Expected: derived, Found: found})
+		}
+	}
+	return out, nil
+}
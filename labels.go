@@ -0,0 +1,101 @@
+package bypasser
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const labelsPrefix = "# bp-labels:"
+
+var labelRE = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if !labelRE.MatchString(k) || !labelRE.MatchString(v) {
+			return errorf(ErrValidation, "invalid label %q=%q: use letters, digits, '.', '_', '/' or '-'", k, v)
+		}
+	}
+	return nil
+}
+
+func labelsLine(labels map[string]string) string {
+	parts := make([]string, 0, len(labels))
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		parts = append(parts, k+"="+labels[k])
+	}
+	return labelsPrefix + " " + strings.Join(parts, ",")
+}
+
+// peerLabels returns the "# bp-labels:" comment among lines, or an empty map.
+func peerLabels(lines []string) map[string]string {
+	for _, raw := range lines {
+		if labels := parseCommentMap(raw, labelsPrefix); labels != nil {
+			return labels
+		}
+	}
+	return map[string]string{}
+}
+
+// PeerLabels returns the labels recorded in a peer's client config.
+func (m *Manager) PeerLabels(vpn, peer string) (map[string]string, error) {
+	if err := ValidateName("vpn", vpn); err != nil {
+		return nil, err
+	}
+	if err := ValidateName("peer", peer); err != nil {
+		return nil, err
+	}
+	path := m.cfg.PeerConfigPath(vpn, peer)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, peerNotFound(PeerRef{VPN: vpn, Peer: peer}, path)
+		}
+		return nil, err
+	}
+	return peerLabels(splitLines(string(b))), nil
+}
+
+// ListPeersMatching is ListPeers filtered by a label selector such as
+// "team=eng,device=phone": a peer matches when it carries every listed
+// label. An empty selector matches all peers.
+func (m *Manager) ListPeersMatching(selector string) ([]PeerRef, error) {
+	want := make(map[string]string)
+	if strings.TrimSpace(selector) != "" {
+		for _, part := range strings.Split(selector, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok {
+				return nil, errorf(ErrValidation, "invalid label selector %q: expected key=value pairs", selector)
+			}
+			want[k] = v
+		}
+	}
+	if err := validateLabels(want); err != nil {
+		return nil, err
+	}
+	peers, err := m.ListPeers()
+	if err != nil || len(want) == 0 {
+		return peers, err
+	}
+	var out []PeerRef
+	for _, p := range peers {
+		labels, err := m.PeerLabels(p.VPN, p.Peer)
+		if err != nil {
+			return nil, err
+		}
+		matches := true
+		for k, v := range want {
+			if labels[k] != v {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,70 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPeerLabels(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	peers := map[string]map[string]string{
+		"laptop": {"team": "eng", "device": "laptop"},
+		"phone":  {"team": "eng", "device": "phone"},
+		"desk":   {"team": "ops"},
+		"guest":  nil,
+	}
+	for _, name := range []string{"desk", "guest", "laptop", "phone"} {
+		if _, err := mgr.AddPeerWithOptions(ctx, "home", name, AddPeerOptions{Labels: peers[name]}); err != nil {
+			t.Fatalf("AddPeerWithOptions returned error: %v", err)
+		}
+	}
+	if conf := readTestFile(t, mgr.Config().PeerConfigPath("home", "laptop")); !strings.Contains(conf, "\n# bp-labels: device=laptop,team=eng\n") {
+		t.Fatalf("labels not recorded in client config:\n%s", conf)
+	}
+	if conf := readTestFile(t, mgr.Config().VPNConfigPath("home")); !strings.Contains(conf, peerMetaLine("home", "laptop")+"\n# bp-labels: device=laptop,team=eng\n[Peer]") {
+		t.Fatalf("labels not recorded in server block:\n%s", conf)
+	}
+
+	labels, err := mgr.PeerLabels("home", "phone")
+	if err != nil {
+		t.Fatalf("PeerLabels returned error: %v", err)
+	}
+	if len(labels) != 2 || labels["team"] != "eng" || labels["device"] != "phone" {
+		t.Fatalf("labels = %v", labels)
+	}
+	if labels, err := mgr.PeerLabels("home", "guest"); err != nil || len(labels) != 0 {
+		t.Fatalf("PeerLabels(guest) = %v, %v", labels, err)
+	}
+	if _, err := mgr.PeerLabels("home", "missing"); !errors.Is(err, ErrPeerNotFound) {
+		t.Fatalf("expected ErrPeerNotFound, got %v", err)
+	}
+
+	for selector, want := range map[string]string{
+		"":                       "home:desk home:guest home:laptop home:phone",
+		"team=eng":               "home:laptop home:phone",
+		"team=eng, device=phone": "home:phone",
+		"team=sales":             "",
+	} {
+		got, err := mgr.ListPeersMatching(selector)
+		if err != nil {
+			t.Fatalf("ListPeersMatching(%q) returned error: %v", selector, err)
+		}
+		if fmtRefs(got) != want {
+			t.Fatalf("ListPeersMatching(%q) = %s, want %s", selector, fmtRefs(got), want)
+		}
+	}
+	if _, err := mgr.ListPeersMatching("team"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for a bad selector, got %v", err)
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "tablet", AddPeerOptions{Labels: map[string]string{"team": "a,b"}}); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for a bad label, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const lockFileName = ".bp.lock"
+
+var errLocked = errors.New("lock is held by another process")
+
+// lock serializes mutations across bp processes sharing a WireGuardDir.
+func (m *Manager) lock(ctx context.Context) (func(), error) {
+	if m.cfg.DryRun {
+		return func() {}, nil
+	}
+	if _, err := os.Stat(m.cfg.WireGuardDir); errors.Is(err, os.ErrNotExist) {
+		return func() {}, nil
+	}
+
+	path := filepath.Join(m.cfg.WireGuardDir, lockFileName)
+	deadline := time.Now().Add(m.cfg.LockTimeout)
+	for {
+		unlock, err := tryLock(path, m.cfg.FilePerm)
+		if err == nil {
+			return unlock, nil
+		}
+		if !errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("cannot lock %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for lock %s: %w", m.cfg.LockTimeout, path, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
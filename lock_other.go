@@ -0,0 +1,22 @@
+//go:build !unix
+
+package bypasser
+
+import (
+	"errors"
+	"os"
+)
+
+// Without flock the lock is the existence of the file itself; a crashed
+// process leaves it behind and it must be removed by hand.
+func tryLock(path string, perm os.FileMode) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	f.Close()
+	return func() { os.Remove(path) }, nil
+}
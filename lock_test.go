@@ -0,0 +1,40 @@
+package bypasser
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockSerializesManagers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{LockTimeout: 100 * time.Millisecond})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+
+	unlock, err := tryLock(filepath.Join(mgr.Config().WireGuardDir, lockFileName), 0o600)
+	if err != nil {
+		t.Fatalf("tryLock returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected lock timeout, got %v", err)
+	}
+	if _, err := mgr.Normalize(ctx); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected Normalize lock timeout, got %v", err)
+	}
+
+	dry := newTestManager(t, Config{WireGuardDir: mgr.Config().WireGuardDir, DryRun: true})
+	if _, err := dry.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("dry-run AddPeer should not take the lock: %v", err)
+	}
+
+	unlock()
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer after unlock returned error: %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
@@ -18,12 +19,21 @@ import (
 type Dependencies struct {
 	System System
 	Keys   KeyGenerator
+	Net    Network
+	Clock  func() time.Time
+	// Logger receives debug traces of detection and fallbacks; nil discards them.
+	Logger *slog.Logger
 }
 
 type Manager struct {
 	cfg  Config
 	sys  System
 	keys KeyGenerator
+	net  Network
+	now  func() time.Time
+	log  *slog.Logger
+	// write is writeFileAtomic; tests swap it to inject failures.
+	write func(path string, data []byte, perm os.FileMode) error
 }
 
 func NewManager(cfg Config, deps Dependencies) *Manager {
@@ -35,12 +45,59 @@ func NewManager(cfg Config, deps Dependencies) *Manager {
 	keys := deps.Keys
 	if keys == nil {
 		keys = WGCLIKeyGenerator{System: sys}
+		if !sys.HasCommand("wg") {
+			keys = NativeKeyGenerator{}
+		}
+	}
+	network := deps.Net
+	if network == nil {
+		network = StdNetwork{}
+	}
+	clock := deps.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+	logger := deps.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
 	}
-	return &Manager{cfg: cfg, sys: sys, keys: keys}
+	return &Manager{cfg: cfg, sys: sys, keys: keys, net: network, now: clock, log: logger, write: writeFileAtomic}
+}
+
+// NewManagerWithError is NewManager but fails up front on an invalid Config
+// or when the default key generator cannot produce keys, instead of deep
+// inside AddVPN or AddPeer.
+// An explicit Dependencies.Keys is trusted as-is.
+func NewManagerWithError(cfg Config, deps Dependencies) (*Manager, error) {
+	m := NewManager(cfg, deps)
+	if err := m.cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := m.cfg.validatePerms(); err != nil {
+		return nil, err
+	}
+	if deps.Keys == nil {
+		ctx := context.Background()
+		priv, err := m.keys.GeneratePrivateKey(ctx)
+		if err == nil {
+			_, err = m.keys.DerivePublicKey(ctx, priv)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key generation unavailable (install wireguard-tools or set Dependencies.Keys): %w", err)
+		}
+	}
+	return m, nil
 }
 
 func (m *Manager) Config() Config { return m.cfg }
 
+// VPNPath, PeerPath, PeersDir and WireGuardDir are the file locations of the
+// manager's normalized config, for tooling that backs up or syncs them.
+func (m *Manager) VPNPath(vpn string) string        { return m.cfg.VPNConfigPath(vpn) }
+func (m *Manager) PeerPath(vpn, peer string) string { return m.cfg.PeerConfigPath(vpn, peer) }
+func (m *Manager) PeersDir() string                 { return m.cfg.PeersDir() }
+func (m *Manager) WireGuardDir() string             { return m.cfg.WireGuardDir }
+
 func (m *Manager) SetupServer(ctx context.Context) (Report, error) {
 	var rep Report
 
@@ -51,6 +108,14 @@ func (m *Manager) SetupServer(ctx context.Context) (Report, error) {
 		return rep, err
 	}
 
+	if !m.sys.HasCommand("iptables") && !m.sys.HasCommand("nft") {
+		rep.warnf("neither iptables nor nft was found; generated PostUp/PostDown firewall rules will fail")
+	} else if m.cfg.FirewallBackend == FirewallNFTables && !m.sys.HasCommand("nft") {
+		rep.warnf("firewall backend is %s but nft was not found", FirewallNFTables)
+	} else if m.cfg.FirewallBackend != FirewallNFTables && !m.sys.HasCommand("iptables") {
+		rep.warnf("firewall backend is %s but iptables was not found; consider BP_FIREWALL_BACKEND=%s", FirewallIPTables, FirewallNFTables)
+	}
+
 	if m.cfg.SysctlFile == "" {
 		rep.warnf("skipping sysctl forwarding file setup on %s; set SYSCTL_CONF_FILE if you want to override", runtime.GOOS)
 		return rep, nil
@@ -66,6 +131,18 @@ func (m *Manager) SetupServer(ctx context.Context) (Report, error) {
 }
 
 func (m *Manager) ListVPNs() ([]string, error) {
+	if m.cfg.InterfaceNameTemplate != "" {
+		byVPN, _, err := m.cfg.templateInterfaces()
+		if err != nil {
+			return nil, err
+		}
+		vpns := make([]string, 0, len(byVPN))
+		for vpn := range byVPN {
+			vpns = append(vpns, vpn)
+		}
+		sort.Strings(vpns)
+		return vpns, nil
+	}
 	entries, err := os.ReadDir(m.cfg.WireGuardDir)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -94,13 +171,28 @@ func (m *Manager) ListVPNs() ([]string, error) {
 }
 
 func (m *Manager) ListPeers() ([]PeerRef, error) {
-	entries, err := os.ReadDir(m.cfg.PeersDir())
+	return m.cfg.listPeers()
+}
+
+// listPeers finds the peer files stored in c's PeerLayout.
+func (c Config) listPeers() ([]PeerRef, error) {
+	entries, err := os.ReadDir(c.PeersDir())
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return nil, nil
 		}
 		return nil, err
 	}
+	if c.PeerLayout == PeerLayoutNested {
+		return c.listNestedPeers(entries)
+	}
+
+	var byVPN map[string]string
+	if c.InterfaceNameTemplate != "" {
+		if byVPN, _, err = c.templateInterfaces(); err != nil {
+			return nil, err
+		}
+	}
 
 	var peers []PeerRef
 	for _, e := range entries {
@@ -108,27 +200,236 @@ func (m *Manager) ListPeers() ([]PeerRef, error) {
 			continue
 		}
 		name := e.Name()
-		if !strings.HasPrefix(name, m.cfg.InterfacePrefix) || !strings.HasSuffix(name, ".conf") {
+		if byVPN != nil {
+			if !strings.HasSuffix(name, ".conf") {
+				continue
+			}
+			// Peer files of deleted vpns cannot be attributed and are skipped.
+			if vpn, peer := vpnForPeerFile(byVPN, strings.TrimSuffix(name, ".conf")); vpn != "" {
+				peers = append(peers, PeerRef{VPN: vpn, Peer: peer})
+			}
 			continue
 		}
-		base := strings.TrimSuffix(strings.TrimPrefix(name, m.cfg.InterfacePrefix), ".conf")
+		if !strings.HasPrefix(name, c.InterfacePrefix) || !strings.HasSuffix(name, ".conf") {
+			continue
+		}
+		base := strings.TrimSuffix(strings.TrimPrefix(name, c.InterfacePrefix), ".conf")
 		parts := strings.SplitN(base, "-", 2)
 		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
 			continue
 		}
 		peers = append(peers, PeerRef{VPN: parts[0], Peer: parts[1]})
 	}
+	sortPeerRefs(peers)
+	return peers, nil
+}
+
+// listNestedPeers reads peers/<vpn>/<peer>.conf; entries are PeersDir's.
+func (c Config) listNestedPeers(entries []os.DirEntry) ([]PeerRef, error) {
+	var peers []PeerRef
+	for _, e := range entries {
+		if !e.IsDir() || ValidateName("vpn", e.Name()) != nil {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(c.PeersDir(), e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			peer, ok := strings.CutSuffix(f.Name(), ".conf")
+			if f.IsDir() || !ok || ValidateName("peer", peer) != nil {
+				continue
+			}
+			peers = append(peers, PeerRef{VPN: e.Name(), Peer: peer})
+		}
+	}
+	sortPeerRefs(peers)
+	return peers, nil
+}
+
+func sortPeerRefs(peers []PeerRef) {
 	sort.Slice(peers, func(i, j int) bool {
 		if peers[i].VPN == peers[j].VPN {
 			return peers[i].Peer < peers[j].Peer
 		}
 		return peers[i].VPN < peers[j].VPN
 	})
-	return peers, nil
+}
+
+func (m *Manager) ListAll() ([]VPNSummary, error) {
+	vpns, err := m.ListVPNs()
+	if err != nil {
+		return nil, err
+	}
+	peers, err := m.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*VPNSummary, len(vpns))
+	out := make([]*VPNSummary, 0, len(vpns))
+	for _, vpn := range vpns {
+		s := &VPNSummary{Name: vpn, Interface: m.cfg.InterfaceName(vpn)}
+		b, err := os.ReadFile(m.cfg.VPNConfigPath(vpn))
+		if err != nil {
+			return nil, err
+		}
+		doc := parseINI(string(b))
+		s.ListenPort, _ = strconv.Atoi(doc.First("Interface", "ListenPort"))
+		s.Address = doc.First("Interface", "Address")
+		byName[vpn] = s
+		out = append(out, s)
+	}
+	for _, p := range peers {
+		s, ok := byName[p.VPN]
+		if !ok {
+			s = &VPNSummary{Name: p.VPN, Interface: m.cfg.InterfaceName(p.VPN), Orphaned: true}
+			byName[p.VPN] = s
+			out = append(out, s)
+		}
+		s.Peers = append(s.Peers, p.Peer)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	summaries := make([]VPNSummary, 0, len(out))
+	for _, s := range out {
+		sort.Strings(s.Peers)
+		summaries = append(summaries, *s)
+	}
+	return summaries, nil
+}
+
+// PeerConfig returns the stored client config of an existing peer.
+func (m *Manager) PeerConfig(vpnName, peerName string) (string, error) {
+	if err := ValidateName("vpn", vpnName); err != nil {
+		return "", err
+	}
+	if err := ValidateName("peer", peerName); err != nil {
+		return "", err
+	}
+	ref := PeerRef{VPN: vpnName, Peer: peerName}
+	path := m.cfg.PeerConfigPath(vpnName, peerName)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", peerNotFound(ref, path)
+		}
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (m *Manager) PeerAddress(vpnName, peerName string) (string, error) {
+	ref := PeerRef{VPN: vpnName, Peer: peerName}
+	path := m.cfg.PeerConfigPath(vpnName, peerName)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", peerNotFound(ref, path)
+		}
+		return "", err
+	}
+	addr := firstSectionValue(string(b), "Interface", "Address")
+	if addr == "" {
+		return "", fmt.Errorf("peer file %s is missing Interface.Address", path)
+	}
+	return addr, nil
+}
+
+func (m *Manager) VPNInfo(ctx context.Context, vpn string) (VPNInfo, error) {
+	var info VPNInfo
+	if err := ValidateName("vpn", vpn); err != nil {
+		return info, err
+	}
+
+	path := m.cfg.VPNConfigPath(vpn)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return info, vpnNotFound(vpn, path)
+		}
+		return info, err
+	}
+	doc := parseINI(string(b))
+
+	priv := doc.First("Interface", "PrivateKey")
+	if priv == "" {
+		return info, fmt.Errorf("vpn config %s is missing Interface.PrivateKey", path)
+	}
+	portStr := doc.First("Interface", "ListenPort")
+	if portStr == "" {
+		return info, fmt.Errorf("vpn config %s is missing Interface.ListenPort", path)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return info, fmt.Errorf("invalid ListenPort %q in %s: %w", portStr, path, err)
+	}
+	pub, err := m.keys.DerivePublicKey(ctx, priv)
+	if err != nil {
+		return info, fmt.Errorf("derive public key for vpn %q: %w", vpn, err)
+	}
+	peers, err := m.ListPeers()
+	if err != nil {
+		return info, err
+	}
+
+	info.Name = vpn
+	info.Interface = m.cfg.InterfaceName(vpn)
+	info.ListenPort = port
+	info.Address = doc.First("Interface", "Address")
+	info.PublicKey = pub
+	for _, p := range peers {
+		if p.VPN == vpn {
+			info.Peers = append(info.Peers, p)
+		}
+	}
+	return info, nil
+}
+
+// AvailableHostOctets returns the peer host numbers of vpn not yet assigned to
+// a [Peer] block, in ascending order. The server always holds host 1.
+func (m *Manager) AvailableHostOctets(vpn string) ([]int, error) {
+	if err := ValidateName("vpn", vpn); err != nil {
+		return nil, err
+	}
+	path := m.cfg.VPNConfigPath(vpn)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, vpnNotFound(vpn, path)
+		}
+		return nil, err
+	}
+	doc := parseINI(string(b))
+	c := m.vpnConfig(doc)
+	vpnOctet, _, err := parseBPAddress(c.SubnetPrefix, doc.First("Interface", "Address"))
+	if err != nil {
+		return nil, fmt.Errorf("vpn config %s: %w", path, err)
+	}
+
+	used := c.usedPeerHostOctets(doc, vpnOctet)
+	var free []int
+	for h := 2; h <= c.maxPeerHost(); h++ {
+		if !used[h] {
+			free = append(free, h)
+		}
+	}
+	return free, nil
 }
 
 func (m *Manager) AddVPN(ctx context.Context, name string) (AddVPNResult, error) {
+	return m.AddVPNWithOptions(ctx, name, AddVPNOptions{})
+}
+
+func (m *Manager) AddVPNWithOptions(ctx context.Context, name string, opts AddVPNOptions) (AddVPNResult, error) {
 	var out AddVPNResult
+	if err := m.cfg.validate(); err != nil {
+		return out, err
+	}
+	vpnCfg, err := m.addVPNConfig(opts)
+	if err != nil {
+		return out, err
+	}
 	if err := ValidateName("vpn", name); err != nil {
 		return out, err
 	}
@@ -139,81 +440,199 @@ func (m *Manager) AddVPN(ctx context.Context, name string) (AddVPNResult, error)
 	if err := m.ensureDir(m.cfg.PeersDir(), &out.Report); err != nil {
 		return out, err
 	}
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return out, err
+	}
+	defer unlock()
+
+	created, err := m.addVPN(ctx, name, opts, vpnCfg, &out)
+	if err != nil || !created {
+		return out, err
+	}
+	m.maybeVPNEnable(ctx, &out.Report, name)
+	m.updateInventory(&out.Report)
+	return out, nil
+}
+
+// addVPNConfig checks the port and subnet pinned by opts and returns the
+// config the new vpn is numbered under.
+func (m *Manager) addVPNConfig(opts AddVPNOptions) (Config, error) {
+	if opts.Port != 0 && (opts.Port < m.cfg.MinPort || opts.Port > m.cfg.MaxPort) {
+		return m.cfg, errorf(ErrValidation, "port %d is outside the allowed range %d-%d", opts.Port, m.cfg.MinPort, m.cfg.MaxPort)
+	}
+	if opts.SubnetOctet != 0 && (opts.SubnetOctet < 1 || opts.SubnetOctet > 254) {
+		return m.cfg, errorf(ErrValidation, "subnet octet %d is outside the allowed range 1-254", opts.SubnetOctet)
+	}
+	if opts.SubnetPrefix == "" {
+		return m.cfg, nil
+	}
+	if err := validateSubnetPrefix(opts.SubnetPrefix); err != nil {
+		return m.cfg, &kindError{kind: ErrValidation, err: err}
+	}
+	vpnCfg := m.cfg.withPrefix(opts.SubnetPrefix)
+	return vpnCfg, vpnCfg.validate()
+}
 
+// addVPN writes the config of a new vpn, or with opts.IfNotExists reports an
+// existing one as unchanged. The caller holds the lock and enables the
+// interface when created is true.
+func (m *Manager) addVPN(ctx context.Context, name string, opts AddVPNOptions, vpnCfg Config, out *AddVPNResult) (created bool, err error) {
+	// The paths are reported even when AddVPN fails, e.g. to locate an
+	// existing vpn's config.
 	confPath := m.cfg.VPNConfigPath(name)
-	if _, err := os.Stat(confPath); err == nil {
-		return out, fmt.Errorf("vpn %q already exists (%s)", name, confPath)
+	interfaceName := m.cfg.InterfaceName(name)
+	out.VPN, out.Interface, out.ConfigPath = name, interfaceName, confPath
+	if b, err := os.ReadFile(confPath); err == nil {
+		if !opts.IfNotExists {
+			return false, vpnExists(name, confPath)
+		}
+		doc := parseINI(string(b))
+		out.ListenPort, _ = strconv.Atoi(doc.First("Interface", "ListenPort"))
+		out.Address = doc.First("Interface", "Address")
+		out.addChange("unchanged", confPath)
+		return false, nil
 	} else if !errors.Is(err, os.ErrNotExist) {
-		return out, err
+		return false, err
 	}
 
-	port, err := m.nextAvailablePort()
+	port := opts.Port
+	if port == 0 {
+		port, err = m.nextAvailablePort(ctx, &out.Report)
+	} else {
+		err = m.checkRequestedPort(ctx, port)
+	}
 	if err != nil {
-		return out, err
+		return false, err
+	}
+	vpnOctet := opts.SubnetOctet
+	if vpnOctet == 0 {
+		vpnOctet, err = m.nextVPNSubnetOctet(vpnCfg.SubnetPrefix)
+	} else {
+		err = m.checkRequestedSubnetOctet(vpnCfg, vpnOctet)
 	}
-	vpnOctet, err := m.nextVPNSubnetOctet()
 	if err != nil {
-		return out, err
+		return false, err
+	}
+	// VPNs under other prefixes are not partitioned with this one, so a
+	// "10" /16 could still swallow a "10.8" /24.
+	if err := m.checkSubnetFree("", vpnCfg.meshCIDR4(vpnOctet)); err != nil {
+		return false, err
 	}
 	iface, err := m.detectDefaultInterface(ctx)
 	if err != nil {
-		return out, err
+		return false, err
 	}
 	privateKey, err := m.keys.GeneratePrivateKey(ctx)
 	if err != nil {
-		return out, err
+		return false, err
 	}
 
-	interfaceName := m.cfg.InterfaceName(name)
-	conf := m.renderVPNConfig(name, interfaceName, privateKey, port, vpnOctet, iface)
+	conf, err := m.renderVPNConfig(vpnCfg, name, interfaceName, privateKey, port, vpnOctet, iface)
+	if err != nil {
+		return false, err
+	}
 	if err := m.writeFile(confPath, []byte(conf), &out.Report); err != nil {
-		return out, err
+		return false, err
 	}
-
-	out.VPN = name
-	out.Interface = interfaceName
-	out.ConfigPath = confPath
-
-	m.maybeVPNEnable(ctx, &out.Report, name)
-	return out, nil
+	out.ListenPort, out.Address = port, vpnCfg.serverAddrs(vpnOctet)
+	return true, nil
 }
 
 func (m *Manager) DeleteVPN(ctx context.Context, name string) (Report, error) {
+	return m.DeleteVPNWithOptions(ctx, name, DeleteVPNOptions{})
+}
+
+func (m *Manager) DeleteVPNWithOptions(ctx context.Context, name string, opts DeleteVPNOptions) (Report, error) {
 	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
 	if err := ValidateName("vpn", name); err != nil {
 		return rep, err
 	}
 
-	confPath := m.cfg.VPNConfigPath(name)
-	if _, err := os.Stat(confPath); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return rep, fmt.Errorf("vpn %q does not exist (%s)", name, confPath)
-		}
+	unlock, err := m.lock(ctx)
+	if err != nil {
 		return rep, err
 	}
+	defer unlock()
 
-	m.maybeVPNDisable(ctx, &rep, name)
-	if err := os.Remove(confPath); err != nil {
+	if err := m.deleteVPN(ctx, name, opts, &rep); err != nil {
 		return rep, err
 	}
-	rep.addChange("deleted", confPath)
+	m.updateInventory(&rep)
+	return rep, nil
+}
+
+// deleteVPN stops vpn and removes its config; the caller holds the lock.
+func (m *Manager) deleteVPN(ctx context.Context, name string, opts DeleteVPNOptions, rep *Report) error {
+	confPath := m.cfg.VPNConfigPath(name)
+	if _, err := os.Stat(confPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return vpnNotFound(name, confPath)
+		}
+		return err
+	}
 
 	peers, _ := m.ListPeers()
-	count := 0
+	var peerPaths []string
 	for _, p := range peers {
 		if p.VPN == name {
-			count++
+			peerPaths = append(peerPaths, m.cfg.PeerConfigPath(p.VPN, p.Peer))
 		}
 	}
-	if count > 0 {
-		rep.warnf("%d peer file(s) for vpn %q still exist under %s", count, name, m.cfg.PeersDir())
+
+	backupPaths := []string{confPath}
+	if opts.Cascade {
+		backupPaths = append(backupPaths, peerPaths...)
+	}
+	if err := m.backup(rep, "delete-vpn-"+name, backupPaths...); err != nil {
+		return err
+	}
+	m.maybeVPNDisable(ctx, rep, name)
+	if err := m.removeFile(confPath, rep); err != nil {
+		return err
 	}
 
-	return rep, nil
+	if opts.Cascade {
+		for _, path := range peerPaths {
+			if err := m.removeFile(path, rep); err != nil {
+				return err
+			}
+		}
+		if m.cfg.PeerLayout == PeerLayoutNested && !m.cfg.DryRun {
+			_ = os.Remove(filepath.Join(m.cfg.PeersDir(), name))
+		}
+	} else if len(peerPaths) > 0 {
+		rep.warnf("%d peer file(s) for vpn %q still exist under %s", len(peerPaths), name, m.cfg.PeersDir())
+	}
+	return nil
 }
 
 func (m *Manager) AddPeer(ctx context.Context, vpnName, peerName string) (AddPeerResult, error) {
+	return m.AddPeerWithOptions(ctx, vpnName, peerName, AddPeerOptions{})
+}
+
+func (m *Manager) AddPeerWithOptions(ctx context.Context, vpnName, peerName string, opts AddPeerOptions) (AddPeerResult, error) {
 	var out AddPeerResult
+	if err := m.cfg.validate(); err != nil {
+		return out, err
+	}
+	if opts.AllowedIPs != "" {
+		if err := validateCIDRList(opts.AllowedIPs); err != nil {
+			return out, errorf(ErrValidation, "invalid allowed ips %q: %w", opts.AllowedIPs, err)
+		}
+	}
+	if err := m.checkClientKeyOptions(opts); err != nil {
+		return out, err
+	}
+	if !opts.ExpiresAt.IsZero() && !opts.ExpiresAt.After(m.now()) {
+		return out, errorf(ErrValidation, "expiry %s is not in the future", opts.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if err := validateLabels(opts.Labels); err != nil {
+		return out, err
+	}
 	if err := ValidateName("vpn", vpnName); err != nil {
 		return out, err
 	}
@@ -221,154 +640,514 @@ func (m *Manager) AddPeer(ctx context.Context, vpnName, peerName string) (AddPee
 		return out, err
 	}
 
-	if err := m.ensureDir(m.cfg.PeersDir(), &out.Report); err != nil {
+	// As with AddVPN, the paths are reported even on failure.
+	out.PeerRef = PeerRef{VPN: vpnName, Peer: peerName}
+	out.PeerConfigPath = m.cfg.PeerConfigPath(vpnName, peerName)
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return out, err
+	}
+	defer unlock()
+
+	if opts.IfNotExists {
+		if b, err := os.ReadFile(out.PeerConfigPath); err == nil {
+			out.PeerConfig = string(b)
+			out.addChange("unchanged", out.PeerConfigPath)
+			return out, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return out, err
+		}
+	}
+
+	results, err := m.addPeers(ctx, vpnName, []peerRequest{{name: peerName, opts: opts}}, &out.Report)
+	if err != nil {
 		return out, err
 	}
+	out.Changes = append(out.Changes, results[0].Changes...)
+	results[0].Report = out.Report
+	out = results[0]
+
+	m.maybeVPNRestart(ctx, &out.Report, vpnName)
+	m.updateInventory(&out.Report)
+	return out, nil
+}
+
+// AddPeers provisions several peers of one vpn in a single pass: the vpn
+// config is read and written once, hosts are numbered consecutively and the
+// interface is restarted once. Changes shared by the batch (the vpn config,
+// runtime actions, warnings) are reported on the first result.
+//
+// Every name and address is checked before anything is written, and a failed
+// write rolls the whole batch back like AddPeer.
+func (m *Manager) AddPeers(ctx context.Context, vpnName string, peerNames []string) ([]AddPeerResult, error) {
+	if err := m.cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := ValidateName("vpn", vpnName); err != nil {
+		return nil, err
+	}
+	if len(peerNames) == 0 {
+		return nil, errorf(ErrValidation, "no peer names given")
+	}
+	if err := m.checkClientKeyOptions(AddPeerOptions{}); err != nil {
+		return nil, err
+	}
+	reqs := make([]peerRequest, len(peerNames))
+	for i, name := range peerNames {
+		if err := ValidateName("peer", name); err != nil {
+			return nil, err
+		}
+		reqs[i].name = name
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var rep Report
+	results, err := m.addPeers(ctx, vpnName, reqs, &rep)
+	if err != nil {
+		return nil, err
+	}
+	rep.Changes = append(rep.Changes, results[0].Changes...)
+	results[0].Report = rep
+
+	m.maybeVPNRestart(ctx, &results[0].Report, vpnName)
+	m.updateInventory(&results[0].Report)
+	return results, nil
+}
+
+// checkClientKeyOptions validates caller-supplied keys and enforces
+// Config.RequireClientPublicKey.
+func (m *Manager) checkClientKeyOptions(opts AddPeerOptions) error {
+	if opts.PresharedKey != "" && !isValidWGKey(opts.PresharedKey) {
+		return errorf(ErrValidation, "invalid preshared key: expected a base64-encoded 32-byte key")
+	}
+	if opts.PublicKey == "" {
+		if m.cfg.RequireClientPublicKey {
+			return errorf(ErrValidation, "a client public key is required: the server does not generate client keys")
+		}
+		return nil
+	}
+	if !isValidWGKey(opts.PublicKey) {
+		return errorf(ErrValidation, "invalid public key %q: expected a base64-encoded 32-byte key", opts.PublicKey)
+	}
+	return nil
+}
+
+type peerRequest struct {
+	name string
+	opts AddPeerOptions
+}
+
+// addPeers writes reqs into vpnName with the lock held; the caller restarts
+// the interface. Shared changes go to rep and each result carries only its
+// client file change. It is all-or-nothing: if a client file cannot be
+// written, the ones already written are removed and the vpn config restored.
+func (m *Manager) addPeers(ctx context.Context, vpnName string, reqs []peerRequest, rep *Report) (_ []AddPeerResult, err error) {
+	if err := m.ensureDir(m.cfg.PeersDir(), rep); err != nil {
+		return nil, err
+	}
 
 	vpnPath := m.cfg.VPNConfigPath(vpnName)
 	vpnBytes, err := os.ReadFile(vpnPath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return out, fmt.Errorf("vpn %q does not exist (%s)", vpnName, vpnPath)
+			return nil, vpnNotFound(vpnName, vpnPath)
 		}
-		return out, err
+		return nil, err
 	}
 	vpnContent := string(vpnBytes)
-
-	peerPath := m.cfg.PeerConfigPath(vpnName, peerName)
-	if _, err := os.Stat(peerPath); err == nil {
-		return out, fmt.Errorf("peer %q already exists (%s)", PeerRef{VPN: vpnName, Peer: peerName}.String(), peerPath)
-	} else if !errors.Is(err, os.ErrNotExist) {
-		return out, err
+	m.warnSaveConfig(rep, vpnPath, vpnContent)
+
+	seen := make(map[string]bool)
+	for _, req := range reqs {
+		ref := PeerRef{VPN: vpnName, Peer: req.name}
+		peerPath := m.cfg.PeerConfigPath(vpnName, req.name)
+		if seen[req.name] {
+			return nil, peerExists(ref, peerPath)
+		}
+		seen[req.name] = true
+		if _, err := os.Stat(peerPath); err == nil {
+			return nil, peerExists(ref, peerPath)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
 	}
 
-	serverPriv := firstSectionValue(vpnContent, "Interface", "PrivateKey")
+	vpnDoc := parseINI(vpnContent)
+	serverPriv := vpnDoc.First("Interface", "PrivateKey")
 	if serverPriv == "" {
-		return out, fmt.Errorf("vpn config %s is missing Interface.PrivateKey", vpnPath)
+		return nil, fmt.Errorf("vpn config %s is missing Interface.PrivateKey", vpnPath)
+	}
+	if !isValidWGKey(serverPriv) {
+		return nil, errorf(ErrValidation, "vpn config %s has malformed PrivateKey: expected 32 base64-encoded bytes", vpnPath)
 	}
 	serverPub, err := m.keys.DerivePublicKey(ctx, serverPriv)
 	if err != nil {
-		return out, err
+		return nil, err
 	}
-	listenPortStr := firstSectionValue(vpnContent, "Interface", "ListenPort")
+	listenPortStr := vpnDoc.First("Interface", "ListenPort")
 	if listenPortStr == "" {
-		return out, fmt.Errorf("vpn config %s is missing Interface.ListenPort", vpnPath)
+		return nil, fmt.Errorf("vpn config %s is missing Interface.ListenPort", vpnPath)
 	}
 	listenPort, err := strconv.Atoi(listenPortStr)
 	if err != nil {
-		return out, fmt.Errorf("invalid ListenPort %q in %s", listenPortStr, vpnPath)
+		return nil, fmt.Errorf("invalid ListenPort %q in %s", listenPortStr, vpnPath)
 	}
-	addr := firstSectionValue(vpnContent, "Interface", "Address")
+	addr := vpnDoc.First("Interface", "Address")
 	if addr == "" {
-		return out, fmt.Errorf("vpn config %s is missing Interface.Address", vpnPath)
+		return nil, fmt.Errorf("vpn config %s is missing Interface.Address", vpnPath)
 	}
-	vpnOctet, _, err := parseBPAddress(m.cfg.SubnetPrefix, addr)
+	vpnCfg := m.vpnConfig(vpnDoc)
+	vpnOctet, _, err := parseBPAddress(vpnCfg.SubnetPrefix, addr)
 	if err != nil {
-		return out, err
+		return nil, err
 	}
-	nextHost, err := m.nextPeerHostOctet(vpnContent, vpnOctet)
-	if err != nil {
-		return out, err
+
+	endpointHost := m.cfg.EndpointHost
+	if endpointHost == "" {
+		endpointHost = m.detectEndpointHost(ctx, rep)
+	} else {
+		m.checkEndpointResolves(ctx, rep, endpointHost)
+	}
+	meshCIDR := vpnCfg.meshCIDRs(vpnOctet)
+
+	results := make([]AddPeerResult, len(reqs))
+	for i, req := range reqs {
+		nextHost := req.opts.HostOctet
+		switch {
+		case nextHost == 0:
+			nextHost, err = vpnCfg.nextPeerHostOctet(vpnDoc, vpnOctet)
+		case nextHost < 2 || nextHost > vpnCfg.maxPeerHost():
+			err = errorf(ErrValidation, "host octet %d is outside the allowed range 2-%d", nextHost, vpnCfg.maxPeerHost())
+		case vpnCfg.usedPeerHostOctets(vpnDoc, vpnOctet)[nextHost]:
+			err = errorf(ErrAlreadyExists, "address %s is already assigned in vpn %q", vpnCfg.ipv4Addr(vpnOctet, nextHost), vpnName)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		peerPriv, peerPub := "", req.opts.PublicKey
+		if peerPub == "" {
+			if peerPriv, err = m.keys.GeneratePrivateKey(ctx); err != nil {
+				return nil, err
+			}
+			if peerPub, err = m.keys.DerivePublicKey(ctx, peerPriv); err != nil {
+				return nil, err
+			}
+		}
+		psk := req.opts.PresharedKey
+		if psk == "" {
+			if psk, err = m.presharedKey(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		peerAddr := vpnCfg.peerAddrs(vpnOctet, nextHost)
+		meta, extra := peerMetaLine(vpnName, req.name), peerCommentLines(req.opts)
+		vpnDoc.Append(parseINI(insertAfterLine(m.renderServerPeerBlock(vpnName, req.name, peerPub, psk, peerAddr), meta, extra...)))
+
+		clientAllowed := meshCIDR
+		switch {
+		case req.opts.AllowedIPs != "":
+			clientAllowed = req.opts.AllowedIPs
+		case m.cfg.ClientAllowedIPs != "":
+			clientAllowed = m.cfg.ClientAllowedIPs
+		}
+		results[i] = AddPeerResult{
+			PeerRef:        PeerRef{VPN: vpnName, Peer: req.name},
+			PeerConfigPath: m.cfg.PeerConfigPath(vpnName, req.name),
+			PeerConfig:     insertAfterLine(m.renderClientPeerConfig(vpnName, req.name, peerPriv, peerAddr, serverPub, psk, clientAllowed, endpointHost, listenPort), meta, extra...),
+		}
 	}
 
-	peerPriv, err := m.keys.GeneratePrivateKey(ctx)
-	if err != nil {
-		return out, err
+	updatedVPN := vpnDoc.String()
+	if err := m.writeFile(vpnPath, []byte(updatedVPN), rep); err != nil {
+		return nil, err
+	}
+	written := 0
+	defer func() {
+		if err == nil || m.cfg.DryRun {
+			return
+		}
+		for _, res := range results[:written] {
+			if rmErr := os.Remove(res.PeerConfigPath); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+				err = fmt.Errorf("%w (rollback: %v)", err, rmErr)
+			}
+		}
+		if wErr := m.write(vpnPath, vpnBytes, m.cfg.FilePerm); wErr != nil {
+			err = fmt.Errorf("%w (rollback of %s failed: %v)", err, vpnPath, wErr)
+			return
+		}
+		rep.warnf("rolled back %s after the failed write", vpnPath)
+	}()
+	m.warnConfigSize(rep, vpnPath, updatedVPN)
+
+	for i := range results {
+		res := &results[i]
+		if err := m.writeFile(res.PeerConfigPath, []byte(res.PeerConfig), &res.Report); err != nil {
+			return nil, err
+		}
+		written++
 	}
-	peerPub, err := m.keys.DerivePublicKey(ctx, peerPriv)
+	return results, nil
+}
+
+func (m *Manager) DeletePeer(ctx context.Context, vpnName, peerName string) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("vpn", vpnName); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("peer", peerName); err != nil {
+		return rep, err
+	}
+
+	unlock, err := m.lock(ctx)
 	if err != nil {
-		return out, err
+		return rep, err
+	}
+	defer unlock()
+
+	if err := m.deletePeer(ctx, vpnName, peerName, &rep); err != nil {
+		return rep, err
 	}
-	psk, err := m.keys.GeneratePresharedKey(ctx)
+	m.maybeVPNRestart(ctx, &rep, vpnName)
+	m.updateInventory(&rep)
+	return rep, nil
+}
+
+// deletePeer removes a peer's server block and client file; the caller holds
+// the lock and restarts the interface.
+func (m *Manager) deletePeer(ctx context.Context, vpnName, peerName string, rep *Report) error {
+	peerPath := m.cfg.PeerConfigPath(vpnName, peerName)
+	peerBytes, err := os.ReadFile(peerPath)
 	if err != nil {
-		return out, err
+		if errors.Is(err, os.ErrNotExist) {
+			return peerNotFound(PeerRef{VPN: vpnName, Peer: peerName}, peerPath)
+		}
+		return err
 	}
+	peerAddr := firstSectionValue(string(peerBytes), "Interface", "Address")
+	if peerAddr == "" {
+		rep.warnf("peer file %s missing Interface.Address; will remove file but may not clean vpn peer block", peerPath)
+	}
+	peerAddr = normalizeCIDR(peerAddr, m.cfg.PeerMask)
 
-	endpointHost := m.cfg.EndpointHost
-	if endpointHost == "" {
-		host, hostErr := m.detectServerIPv4(ctx)
-		if hostErr != nil {
-			endpointHost = "<server-public-ip>"
-			out.Report.warnf("could not detect server public IPv4 automatically: %v", hostErr)
+	vpnPath := m.cfg.VPNConfigPath(vpnName)
+	if err := m.backup(rep, "delete-peer-"+vpnName+"-"+peerName, vpnPath, peerPath); err != nil {
+		return err
+	}
+	vpnBytes, err := os.ReadFile(vpnPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			rep.warnf("vpn config %s not found; only deleting peer file", vpnPath)
 		} else {
-			endpointHost = host
+			return err
+		}
+	} else {
+		m.warnSaveConfig(rep, vpnPath, string(vpnBytes))
+		peerPub := m.peerPublicKey(ctx, string(peerBytes))
+		updated, removed := removePeerBlock(string(vpnBytes), PeerRef{VPN: vpnName, Peer: peerName}, peerAddr, peerPub)
+		if removed {
+			if err := m.writeFile(vpnPath, []byte(updated), rep); err != nil {
+				return err
+			}
+		} else {
+			rep.warnf("peer block for %s was not found in %s", PeerRef{VPN: vpnName, Peer: peerName}.String(), vpnPath)
 		}
 	}
 
-	peerAddr := fmt.Sprintf("%s.%d.%d/%d", m.cfg.SubnetPrefix, vpnOctet, nextHost, m.cfg.PeerMask)
-	meshCIDR := fmt.Sprintf("%s.%d.0/%d", m.cfg.SubnetPrefix, vpnOctet, m.cfg.InterfaceMask)
+	return m.removeFile(peerPath, rep)
+}
 
-	serverBlock := m.renderServerPeerBlock(vpnName, peerName, peerPub, psk, peerAddr)
-	updatedVPN := strings.TrimRight(vpnContent, "\n") + "\n\n" + serverBlock
-	if err := m.writeFile(vpnPath, []byte(updatedVPN), &out.Report); err != nil {
-		return out, err
+// DeleteAllPeers removes every peer of vpn: their server [Peer] blocks and
+// client files. The interface is restarted once at the end.
+func (m *Manager) DeleteAllPeers(ctx context.Context, vpn string) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("vpn", vpn); err != nil {
+		return rep, err
 	}
 
-	clientConf := m.renderClientPeerConfig(vpnName, peerName, peerPriv, peerAddr, serverPub, psk, meshCIDR, endpointHost, listenPort)
-	if err := m.writeFile(peerPath, []byte(clientConf), &out.Report); err != nil {
-		return out, err
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
 	}
+	defer unlock()
 
-	out.PeerRef = PeerRef{VPN: vpnName, Peer: peerName}
-	out.PeerConfigPath = peerPath
-	out.PeerConfig = clientConf
+	vpnPath := m.cfg.VPNConfigPath(vpn)
+	vpnBytes, err := os.ReadFile(vpnPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, vpnNotFound(vpn, vpnPath)
+		}
+		return rep, err
+	}
+	all, err := m.ListPeers()
+	if err != nil {
+		return rep, err
+	}
+	var peers []PeerRef
+	paths := []string{vpnPath}
+	for _, p := range all {
+		if p.VPN == vpn {
+			peers = append(peers, p)
+			paths = append(paths, m.cfg.PeerConfigPath(p.VPN, p.Peer))
+		}
+	}
+	if len(peers) == 0 {
+		return rep, nil
+	}
+	if err := m.backup(&rep, "delete-peers-"+vpn, paths...); err != nil {
+		return rep, err
+	}
 
-	m.maybeVPNRestart(ctx, &out.Report, vpnName)
-	return out, nil
+	content := string(vpnBytes)
+	changed := false
+	for _, ref := range peers {
+		peerPath := m.cfg.PeerConfigPath(ref.VPN, ref.Peer)
+		peerBytes, err := os.ReadFile(peerPath)
+		if err != nil {
+			return rep, err
+		}
+		peerAddr := normalizeCIDR(firstSectionValue(string(peerBytes), "Interface", "Address"), m.cfg.PeerMask)
+		updated, removed := removePeerBlock(content, ref, peerAddr, m.peerPublicKey(ctx, string(peerBytes)))
+		if !removed {
+			rep.warnf("peer block for %s was not found in %s", ref.String(), vpnPath)
+			continue
+		}
+		content = updated
+		changed = true
+	}
+	if changed {
+		if err := m.writeFile(vpnPath, []byte(content), &rep); err != nil {
+			return rep, err
+		}
+	}
+	for _, ref := range peers {
+		if err := m.removeFile(m.cfg.PeerConfigPath(ref.VPN, ref.Peer), &rep); err != nil {
+			return rep, err
+		}
+	}
+
+	m.maybeVPNRestart(ctx, &rep, vpn)
+	m.updateInventory(&rep)
+	return rep, nil
 }
 
-func (m *Manager) DeletePeer(ctx context.Context, vpnName, peerName string) (Report, error) {
+func (m *Manager) RenamePeer(ctx context.Context, vpnName, oldName, newName string) (Report, error) {
 	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
 	if err := ValidateName("vpn", vpnName); err != nil {
 		return rep, err
 	}
-	if err := ValidateName("peer", peerName); err != nil {
+	if err := ValidateName("peer", oldName); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("peer", newName); err != nil {
+		return rep, err
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
 		return rep, err
 	}
+	defer unlock()
+	oldRef := PeerRef{VPN: vpnName, Peer: oldName}
+	newRef := PeerRef{VPN: vpnName, Peer: newName}
+	if oldName == newName {
+		return rep, errorf(ErrValidation, "peer %q already has that name", oldRef.String())
+	}
 
-	peerPath := m.cfg.PeerConfigPath(vpnName, peerName)
-	peerBytes, err := os.ReadFile(peerPath)
+	oldPath := m.cfg.PeerConfigPath(vpnName, oldName)
+	peerBytes, err := os.ReadFile(oldPath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return rep, fmt.Errorf("peer %q does not exist (%s)", PeerRef{VPN: vpnName, Peer: peerName}.String(), peerPath)
+			return rep, peerNotFound(oldRef, oldPath)
 		}
 		return rep, err
 	}
-	peerAddr := firstSectionValue(string(peerBytes), "Interface", "Address")
-	if peerAddr == "" {
-		rep.warnf("peer file %s missing Interface.Address; will remove file but may not clean vpn peer block", peerPath)
+	newPath := m.cfg.PeerConfigPath(vpnName, newName)
+	if _, err := os.Stat(newPath); err == nil {
+		return rep, peerExists(newRef, newPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return rep, err
 	}
-	peerAddr = normalizeCIDR(peerAddr, m.cfg.PeerMask)
 
 	vpnPath := m.cfg.VPNConfigPath(vpnName)
 	vpnBytes, err := os.ReadFile(vpnPath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			rep.warnf("vpn config %s not found; only deleting peer file", vpnPath)
-		} else {
-			return rep, err
-		}
-	} else {
-		updated, removed := removePeerBlock(string(vpnBytes), PeerRef{VPN: vpnName, Peer: peerName}, peerAddr)
-		if removed {
-			if err := m.writeFile(vpnPath, []byte(updated), &rep); err != nil {
-				return rep, err
-			}
-		} else {
-			rep.warnf("peer block for %s was not found in %s", PeerRef{VPN: vpnName, Peer: peerName}.String(), vpnPath)
+			return rep, vpnNotFound(vpnName, vpnPath)
 		}
+		return rep, err
 	}
 
-	if err := os.Remove(peerPath); err != nil {
-		return rep, err
+	// Both metadata comments must be found before anything is written.
+	oldMeta := peerMetaLine(vpnName, oldName)
+	newMeta := peerMetaLine(vpnName, newName)
+	updatedPeer, ok := replaceLine(string(peerBytes), oldMeta, newMeta)
+	if !ok {
+		return rep, fmt.Errorf("peer file %s is missing the %q metadata comment", oldPath, oldMeta)
+	}
+	updatedVPN, ok := replaceLine(string(vpnBytes), oldMeta, newMeta)
+	if !ok {
+		return rep, fmt.Errorf("vpn config %s is missing the %q metadata comment", vpnPath, oldMeta)
 	}
-	rep.addChange("deleted", peerPath)
 
-	m.maybeVPNRestart(ctx, &rep, vpnName)
+	if err := m.backup(&rep, "rename-peer-"+vpnName+"-"+oldName, vpnPath, oldPath); err != nil {
+		return rep, err
+	}
+	if err := m.writeFile(vpnPath, []byte(updatedVPN), &rep); err != nil {
+		return rep, err
+	}
+	// A later failure puts the vpn config back and drops the new peer file,
+	// so the peer keeps its old name everywhere.
+	rollback := func(err error) error {
+		if m.cfg.DryRun {
+			return err
+		}
+		if rmErr := os.Remove(newPath); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+			err = fmt.Errorf("%w (rollback: %v)", err, rmErr)
+		}
+		if wErr := m.write(vpnPath, vpnBytes, m.cfg.FilePerm); wErr != nil {
+			return fmt.Errorf("%w (rollback of %s failed: %v)", err, vpnPath, wErr)
+		}
+		rep.warnf("rolled back %s after the failed write", vpnPath)
+		return err
+	}
+	if err := m.writeFile(newPath, []byte(updatedPeer), &rep); err != nil {
+		return rep, rollback(err)
+	}
+	if err := m.removeFile(oldPath, &rep); err != nil {
+		return rep, rollback(err)
+	}
+	m.updateInventory(&rep)
 	return rep, nil
 }
 
+// ensureDir creates path if it is missing. A symlink to a directory (e.g.
+// peers/ moved to another volume) is followed, with a warning naming the
+// directory files actually land in; a dangling one is an error.
 func (m *Manager) ensureDir(path string, rep *Report) error {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return fmt.Errorf("%s is a symlink whose target cannot be resolved: %w", path, err)
+		}
+		rep.warnf("%s is a symlink; files are written to %s", path, target)
+	}
 	info, err := os.Stat(path)
 	if err == nil {
 		if !info.IsDir() {
@@ -379,6 +1158,10 @@ func (m *Manager) ensureDir(path string, rep *Report) error {
 	if !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
+	if m.cfg.DryRun {
+		rep.addChange("would-create", path)
+		return nil
+	}
 	if err := os.MkdirAll(path, m.cfg.DirPerm); err != nil {
 		return err
 	}
@@ -386,7 +1169,87 @@ func (m *Manager) ensureDir(path string, rep *Report) error {
 	return nil
 }
 
+func (m *Manager) Normalize(ctx context.Context) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	vpns, err := m.ListVPNs()
+	if err != nil {
+		return rep, err
+	}
+	peers, err := m.ListPeers()
+	if err != nil {
+		return rep, err
+	}
+
+	paths := make([]string, 0, len(vpns)+len(peers))
+	for _, vpn := range vpns {
+		paths = append(paths, m.cfg.VPNConfigPath(vpn))
+	}
+	for _, p := range peers {
+		paths = append(paths, m.cfg.PeerConfigPath(p.VPN, p.Peer))
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return rep, err
+		}
+		if err := m.writeFile(path, []byte(normalizeConfig(string(b))), &rep); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// ReapplyRuntime brings up the interface of every vpn from the configs
+// already on disk, e.g. from a startup script or after manual edits: an
+// interface that is up is restarted, one that is not (as after a reboot) is
+// enabled and started. No file is written.
+func (m *Manager) ReapplyRuntime(ctx context.Context) (Report, error) {
+	var rep Report
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	vpns, err := m.ListVPNs()
+	if err != nil {
+		return rep, err
+	}
+	for _, vpn := range vpns {
+		if !m.cfg.NoRuntime && m.interfaceUp(ctx, m.cfg.InterfaceName(vpn)) {
+			m.maybeVPNRestart(ctx, &rep, vpn)
+			continue
+		}
+		m.maybeVPNEnable(ctx, &rep, vpn)
+	}
+	return rep, nil
+}
+
 func (m *Manager) writeFile(path string, data []byte, rep *Report) error {
+	if m.cfg.NormalizeOnWrite {
+		data = []byte(normalizeConfig(string(data)))
+	}
+	if m.cfg.StripSaveConfig && saveConfigEnabled(string(data)) {
+		data = []byte(removeSectionValue(string(data), "Interface", "SaveConfig"))
+		rep.warnf("removed SaveConfig = true from %s", path)
+	}
+	if m.cfg.ValidateBeforeWrite && path != m.cfg.SysctlFile {
+		// A client config awaiting its owner's key is checked as if filled in.
+		check, _ := replaceLine(string(data), clientKeyPlaceholder, "PrivateKey = "+placeholderKey)
+		if err := ValidateWGConfig(check); err != nil {
+			return errorf(ErrValidation, "refusing to write invalid config %s: %w", path, err)
+		}
+	}
 	action := "created"
 	if old, err := os.ReadFile(path); err == nil {
 		if bytes.Equal(old, data) {
@@ -397,33 +1260,87 @@ func (m *Manager) writeFile(path string, data []byte, rep *Report) error {
 		return err
 	}
 
+	if m.cfg.DryRun {
+		planned := "would-create"
+		if action == "updated" {
+			planned = "would-update"
+		}
+		rep.addChange(planned, path)
+		return nil
+	}
 	if err := os.MkdirAll(filepath.Dir(path), m.cfg.DirPerm); err != nil {
 		return err
 	}
-	if err := os.WriteFile(path, data, m.cfg.FilePerm); err != nil {
+	if err := m.write(path, data, m.cfg.FilePerm); err != nil {
 		return err
 	}
 	rep.addChange(action, path)
 	return nil
 }
 
-func (m *Manager) nextAvailablePort() (int, error) {
+// warnSaveConfig warns that wg-quick down will rewrite a vpn config with
+// SaveConfig = true unless writes strip it.
+func (m *Manager) warnSaveConfig(rep *Report, path, content string) {
+	if saveConfigEnabled(content) && !m.cfg.StripSaveConfig {
+		rep.warnf("%s sets SaveConfig = true: wg-quick down will rewrite it and drop bp's peer comments; remove it or set BP_STRIP_SAVE_CONFIG=1", path)
+	}
+}
+
+func (m *Manager) removeFile(path string, rep *Report) error {
+	if m.cfg.DryRun {
+		rep.addChange("would-delete", path)
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	rep.addChange("deleted", path)
+	return nil
+}
+
+func (m *Manager) usedVPNPorts() (map[int]string, error) {
 	vpns, err := m.ListVPNs()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	maxPort := m.cfg.MinPort - 1
+	used := make(map[int]string)
 	for _, vpn := range vpns {
 		b, err := os.ReadFile(m.cfg.VPNConfigPath(vpn))
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		p := firstSectionValue(string(b), "Interface", "ListenPort")
-		if p == "" {
-			continue
+		n, err := strconv.Atoi(firstSectionValue(string(b), "Interface", "ListenPort"))
+		if err == nil {
+			used[n] = vpn
+		}
+	}
+	return used, nil
+}
+
+func (m *Manager) checkRequestedPort(ctx context.Context, port int) error {
+	used, err := m.usedVPNPorts()
+	if err != nil {
+		return err
+	}
+	if vpn, ok := used[port]; ok {
+		return errorf(ErrAlreadyExists, "port %d is already used by vpn %q", port, vpn)
+	}
+	if m.cfg.CheckPortInUse {
+		if err := m.probeUDPPort(ctx, port); err != nil {
+			return errorf(ErrAlreadyExists, "port %d is already in use on this host: %w", port, err)
 		}
-		n, err := strconv.Atoi(p)
-		if err == nil && n > maxPort {
+	}
+	return nil
+}
+
+func (m *Manager) nextAvailablePort(ctx context.Context, rep *Report) (int, error) {
+	used, err := m.usedVPNPorts()
+	if err != nil {
+		return 0, err
+	}
+	maxPort := m.cfg.MinPort - 1
+	for n := range used {
+		if n > maxPort {
 			maxPort = n
 		}
 	}
@@ -431,96 +1348,254 @@ func (m *Manager) nextAvailablePort() (int, error) {
 	if next < m.cfg.MinPort {
 		next = m.cfg.MinPort
 	}
-	if next > m.cfg.MaxPort {
-		return 0, fmt.Errorf("no available port in range %d-%d", m.cfg.MinPort, m.cfg.MaxPort)
+	for ; next <= m.cfg.MaxPort; next++ {
+		if !m.cfg.CheckPortInUse {
+			return next, nil
+		}
+		err := m.probeUDPPort(ctx, next)
+		if err == nil {
+			return next, nil
+		}
+		rep.warnf("skipping port %d: already in use on this host (%v)", next, err)
 	}
-	return next, nil
+	return 0, fmt.Errorf("no available port in range %d-%d", m.cfg.MinPort, m.cfg.MaxPort)
+}
+
+func (m *Manager) probeUDPPort(ctx context.Context, port int) error {
+	conn, err := m.net.ListenPacket(ctx, "udp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
 }
 
-func (m *Manager) nextVPNSubnetOctet() (int, error) {
+// usedVPNSubnetOctets maps the vpn octets taken under prefix to their vpn;
+// vpns recorded under another prefix are ignored.
+func (m *Manager) usedVPNSubnetOctets(prefix string) (map[int]string, error) {
 	vpns, err := m.ListVPNs()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	highest := 0
+	used := make(map[int]string)
 	for _, vpn := range vpns {
 		b, err := os.ReadFile(m.cfg.VPNConfigPath(vpn))
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		addr := firstSectionValue(string(b), "Interface", "Address")
-		if addr == "" {
+		doc := parseINI(string(b))
+		addr := doc.First("Interface", "Address")
+		if addr == "" || m.vpnConfig(doc).SubnetPrefix != prefix {
 			continue
 		}
-		vpnOctet, _, err := parseBPAddress(m.cfg.SubnetPrefix, addr)
+		vpnOctet, _, err := parseBPAddress(prefix, addr)
 		if err != nil {
 			continue
 		}
+		used[vpnOctet] = vpn
+	}
+	return used, nil
+}
+
+func (m *Manager) checkRequestedSubnetOctet(c Config, octet int) error {
+	used, err := m.usedVPNSubnetOctets(c.SubnetPrefix)
+	if err != nil {
+		return err
+	}
+	if vpn, ok := used[octet]; ok {
+		return errorf(ErrAlreadyExists, "subnet %s is already used by vpn %q", c.meshCIDR4(octet), vpn)
+	}
+	return nil
+}
+
+func (m *Manager) nextVPNSubnetOctet(prefix string) (int, error) {
+	used, err := m.usedVPNSubnetOctets(prefix)
+	if err != nil {
+		return 0, err
+	}
+	highest := 0
+	for vpnOctet := range used {
 		if vpnOctet > highest {
 			highest = vpnOctet
 		}
 	}
 	next := highest + 1
 	if next > 254 {
-		return 0, fmt.Errorf("no available vpn subnet octet left in %s.X.0/24", m.cfg.SubnetPrefix)
+		return 0, fmt.Errorf("no available vpn subnet octet left under prefix %s", prefix)
 	}
 	return next, nil
 }
 
-func (m *Manager) nextPeerHostOctet(vpnConfig string, vpnOctet int) (int, error) {
-	highest := 1
-	for _, ip := range allSectionValues(vpnConfig, "Peer", "AllowedIPs") {
-		v, h, err := parseBPAddress(m.cfg.SubnetPrefix, ip)
-		if err != nil || v != vpnOctet {
-			continue
+// NextAllocations reports the ListenPort and vpn subnet octet the next
+// AddVPN would assign, without creating anything. With Config.CheckPortInUse
+// ports already bound on this host are skipped as AddVPN would.
+func (m *Manager) NextAllocations() (port int, subnetOctet int, err error) {
+	if err := m.cfg.validate(); err != nil {
+		return 0, 0, err
+	}
+	port, err = m.nextAvailablePort(context.Background(), &Report{})
+	if err != nil {
+		return 0, 0, err
+	}
+	subnetOctet, err = m.nextVPNSubnetOctet(m.cfg.SubnetPrefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	return port, subnetOctet, nil
+}
+
+func (c Config) usedPeerHostOctets(vpnDoc *INIDocument, vpnOctet int) map[int]bool {
+	used := make(map[int]bool)
+	for _, ip := range vpnDoc.All("Peer", "AllowedIPs") {
+		v, h, err := parseBPAddress(c.SubnetPrefix, ip)
+		if err == nil && v == vpnOctet {
+			used[h] = true
 		}
-		if h > highest {
-			highest = h
+	}
+	return used
+}
+
+// nextPeerHostOctet returns the lowest free peer host, so pinned
+// AddPeerOptions.HostOctet addresses never push auto-allocation past them.
+func (c Config) nextPeerHostOctet(vpnDoc *INIDocument, vpnOctet int) (int, error) {
+	used := c.usedPeerHostOctets(vpnDoc, vpnOctet)
+	for h := 2; h <= c.maxPeerHost(); h++ {
+		if !used[h] {
+			return h, nil
 		}
 	}
-	next := highest + 1
-	if next > 254 {
-		return 0, fmt.Errorf("no available peer addresses left in vpn %d", vpnOctet)
+	return 0, fmt.Errorf("no available peer addresses left in %s", c.meshCIDR4(vpnOctet))
+}
+
+// checkEndpointResolves warns when a configured endpoint hostname does not
+// resolve; clients would otherwise fail silently on first connect.
+func (m *Manager) checkEndpointResolves(ctx context.Context, rep *Report, host string) {
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return
+	}
+	if _, err := m.net.LookupHost(ctx, host); err != nil {
+		rep.warnf("endpoint host %q does not resolve: %v", host, err)
 	}
-	return next, nil
 }
 
 func (m *Manager) detectDefaultInterface(ctx context.Context) (string, error) {
 	if m.cfg.PublicInterface != "" {
+		m.log.Debug("default interface configured", "interface", m.cfg.PublicInterface)
 		return m.cfg.PublicInterface, nil
 	}
 
-	if localIP, err := m.detectOutboundIPv4(ctx); err == nil {
-		if iface, err := m.findInterfaceByIPv4(localIP); err == nil {
+	if localIP, err := m.detectOutboundIP(ctx, EndpointFamilyV4); err == nil {
+		iface, err := m.findInterfaceByIPv4(localIP)
+		if err == nil {
+			m.log.Debug("default interface detected from outbound address", "ip", localIP, "interface", iface)
 			return iface, nil
 		}
+		m.log.Debug("no interface owns outbound address", "ip", localIP, "err", err)
 	}
 
 	if !m.sys.HasCommand("ip") {
 		return "", fmt.Errorf("could not determine default interface natively and ip command not found; set BP_PUBLIC_IFACE or Config.PublicInterface")
 	}
-	out, err := m.sys.Output(ctx, "ip", "-4", "route", "show", "default")
+	// IPv6-only hosts have no IPv4 default route, so fall back to the v6 one.
+	var lastErr error
+	for _, flag := range []string{"-4", "-6"} {
+		iface, err := m.defaultRouteInterface(ctx, flag)
+		if err == nil {
+			return iface, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// defaultRouteInterface returns the dev of `ip <flag> route show default`.
+func (m *Manager) defaultRouteInterface(ctx context.Context, flag string) (string, error) {
+	cmdCtx, cancel := m.commandContext(ctx)
+	defer cancel()
+	out, err := m.sys.Output(cmdCtx, "ip", flag, "route", "show", "default")
 	if err != nil {
+		m.log.Debug("ip route failed", "family", flag, "err", err)
 		return "", err
 	}
+	m.log.Debug("parsing default route", "family", flag, "output", strings.TrimSpace(out))
 	fields := strings.Fields(out)
 	for i := 0; i < len(fields)-1; i++ {
 		if fields[i] == "dev" && fields[i+1] != "" {
+			m.log.Debug("default interface detected from ip route", "interface", fields[i+1])
 			return fields[i+1], nil
 		}
 	}
 	return "", fmt.Errorf("could not determine default interface from %q", out)
 }
 
-func (m *Manager) detectServerIPv4(ctx context.Context) (string, error) {
+func (m *Manager) detectServerIP(ctx context.Context) (string, error) {
 	if m.cfg.EndpointHost != "" {
 		return m.cfg.EndpointHost, nil
 	}
 
-	if localIP, err := m.detectOutboundIPv4(ctx); err == nil {
-		return localIP.String(), nil
+	families, err := endpointFamilies(m.cfg.EndpointFamily)
+	if err != nil {
+		return "", err
+	}
+
+	if m.cfg.EndpointSource == EndpointSourceCloudMetadata && families[0] == EndpointFamilyV4 {
+		ip, err := m.cloudMetadataIP(ctx)
+		if err == nil {
+			return ip.String(), nil
+		}
+		m.log.Debug("cloud metadata lookup failed, falling back to local detection", "err", err)
+	}
+
+	ip, err := m.detectLocalServerIP(ctx, families)
+	if m.cfg.EndpointSource == EndpointSourceHTTP && (err != nil || !isPublicIP(net.ParseIP(ip))) {
+		pub, httpErr := m.httpPublicIP(ctx)
+		if httpErr == nil {
+			return pub.String(), nil
+		}
+		m.log.Debug("public ip service failed, keeping local detection", "err", httpErr)
+	}
+	return ip, err
+}
+
+// detectEndpointHost detects the host for client Endpoints, warning in rep
+// when it falls back to a placeholder or only finds a non-public address that
+// clients outside this network cannot reach.
+func (m *Manager) detectEndpointHost(ctx context.Context, rep *Report) string {
+	host, err := m.detectServerIP(ctx)
+	if err != nil {
+		m.log.Debug("endpoint detection fell back to placeholder", "err", err)
+		rep.warnf("could not detect server public IP automatically: %v", err)
+		return "<server-public-ip>"
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		rep.warnf("detected private IP %s; set BP_ENDPOINT_HOST (or BP_ENDPOINT_SOURCE=http) for clients outside this network", host)
+	}
+	return host
+}
+
+func (m *Manager) detectLocalServerIP(ctx context.Context, families []string) (string, error) {
+	var lastErr error
+	for _, family := range families {
+		localIP, err := m.detectOutboundIP(ctx, family)
+		if err == nil {
+			m.log.Debug("server ip detected from outbound probe", "family", family, "ip", localIP)
+			return localIP.String(), nil
+		}
+		m.log.Debug("outbound probe failed", "family", family, "err", err)
+		lastErr = err
+	}
+	for _, family := range families {
+		ip, err := m.detectInterfaceIP(ctx, family)
+		if err == nil {
+			m.log.Debug("server ip detected from interface address", "family", family, "ip", ip)
+			return ip, nil
+		}
+		m.log.Debug("interface address lookup failed", "family", family, "err", err)
+		lastErr = err
 	}
+	return "", lastErr
+}
 
+func (m *Manager) detectInterfaceIP(ctx context.Context, family string) (string, error) {
 	iface, err := m.detectDefaultInterface(ctx)
 	if err != nil {
 		return "", err
@@ -528,14 +1603,21 @@ func (m *Manager) detectServerIPv4(ctx context.Context) (string, error) {
 	if !m.sys.HasCommand("ip") {
 		return "", fmt.Errorf("ip command not found")
 	}
-	out, err := m.sys.Output(ctx, "ip", "-4", "-o", "addr", "show", "dev", iface, "scope", "global")
+	flag, keyword := "-4", "inet"
+	if family == EndpointFamilyV6 {
+		flag, keyword = "-6", "inet6"
+	}
+	cmdCtx, cancel := m.commandContext(ctx)
+	defer cancel()
+	out, err := m.sys.Output(cmdCtx, "ip", flag, "-o", "addr", "show", "dev", iface, "scope", "global")
 	if err != nil {
 		return "", err
 	}
+	m.log.Debug("parsing interface addresses", "interface", iface, "output", strings.TrimSpace(out))
 	for _, line := range strings.Split(out, "\n") {
 		fields := strings.Fields(line)
 		for i := 0; i < len(fields)-1; i++ {
-			if fields[i] == "inet" {
+			if fields[i] == keyword {
 				ip := strings.TrimSpace(fields[i+1])
 				if slash := strings.IndexByte(ip, '/'); slash >= 0 {
 					ip = ip[:slash]
@@ -546,22 +1628,26 @@ func (m *Manager) detectServerIPv4(ctx context.Context) (string, error) {
 			}
 		}
 	}
-	return "", fmt.Errorf("could not detect ipv4 on interface %s", iface)
+	return "", fmt.Errorf("could not detect ip%s address on interface %s", family, iface)
 }
 
-func (m *Manager) detectOutboundIPv4(ctx context.Context) (net.IP, error) {
+func (m *Manager) detectOutboundIP(ctx context.Context, family string) (net.IP, error) {
 	dialCtx := ctx
 	if dialCtx == nil {
 		dialCtx = context.Background()
 	}
 
 	// UDP "connect" picks a route and local address without requiring a real handshake.
+	network := "udp4"
 	probes := []string{"1.1.1.1:53", "8.8.8.8:53"}
-	dialer := net.Dialer{Timeout: 2 * time.Second}
+	if family == EndpointFamilyV6 {
+		network = "udp6"
+		probes = []string{"[2606:4700:4700::1111]:53", "[2001:4860:4860::8888]:53"}
+	}
 
 	var lastErr error
 	for _, probe := range probes {
-		conn, err := dialer.DialContext(dialCtx, "udp4", probe)
+		conn, err := m.net.DialContext(dialCtx, network, probe)
 		if err != nil {
 			lastErr = err
 			continue
@@ -576,8 +1662,14 @@ func (m *Manager) detectOutboundIPv4(ctx context.Context) (net.IP, error) {
 		}
 
 		ip := addr.IP.To4()
+		if family == EndpointFamilyV6 {
+			ip = addr.IP.To16()
+			if addr.IP.To4() != nil {
+				ip = nil
+			}
+		}
 		if ip == nil {
-			lastErr = fmt.Errorf("detected non-ipv4 local address %q", addr.IP.String())
+			lastErr = fmt.Errorf("detected non-ip%s local address %q", family, addr.IP.String())
 			continue
 		}
 		return ip, nil
@@ -633,49 +1725,140 @@ func ipv4FromAddr(addr net.Addr) net.IP {
 	}
 }
 
-func (m *Manager) renderVPNConfig(vpnName, ifaceName, privateKey string, port, vpnOctet int, publicIface string) string {
-	meshCIDR := fmt.Sprintf("%s.%d.0/%d", m.cfg.SubnetPrefix, vpnOctet, m.cfg.InterfaceMask)
-	addr := fmt.Sprintf("%s.%d.1/%d", m.cfg.SubnetPrefix, vpnOctet, m.cfg.InterfaceMask)
-	postUp := fmt.Sprintf(
-		"iptables -t nat -A POSTROUTING -s %s -o %s -j MASQUERADE; iptables -A INPUT -p udp -m udp --dport %d -j ACCEPT; iptables -A FORWARD -i %s -j ACCEPT; iptables -A FORWARD -o %s -j ACCEPT;",
-		meshCIDR, publicIface, port, ifaceName, ifaceName,
-	)
-	postDown := fmt.Sprintf(
-		"iptables -t nat -D POSTROUTING -s %s -o %s -j MASQUERADE; iptables -D INPUT -p udp -m udp --dport %d -j ACCEPT; iptables -D FORWARD -i %s -j ACCEPT; iptables -D FORWARD -o %s -j ACCEPT;",
-		meshCIDR, publicIface, port, ifaceName, ifaceName,
-	)
-	return fmt.Sprintf(`# bp-managed: vpn=%s
+// renderVPNConfig renders a new vpn using c's addressing and records
+// c.SubnetPrefix in the header so peers are numbered under it later.
+func (m *Manager) renderVPNConfig(c Config, vpnName, ifaceName, privateKey string, port, vpnOctet int, publicIface string) (string, error) {
+	postUp, postDown, err := c.vpnRules(ifaceName, publicIface, port, vpnOctet)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`%s
+%s
 [Interface]
 PrivateKey = %s
 ListenPort = %d
 Address = %s
-PostUp = %s
+%s%s%sPostUp = %s
 PostDown = %s
-`, vpnName, privateKey, port, addr, postUp, postDown)
+`, vpnMetaLine(vpnName, c.SubnetPrefix), m.createdLine(), privateKey, port, c.serverAddrs(vpnOctet), c.mtuLine(), tableLine(c.ServerTable), fwMarkLine(c.FwMark), postUp, postDown), nil
+}
+
+func (m *Manager) createdLine() string {
+	return "# created: " + m.now().UTC().Format(time.RFC3339)
+}
+
+// vpnConfig returns m.cfg with the subnet prefix recorded in vpnDoc's
+// header; vpns created before prefixes were recorded use the global one.
+func (m *Manager) vpnConfig(vpnDoc *INIDocument) Config {
+	if prefix := vpnPrefix(vpnDoc); prefix != "" {
+		return m.cfg.withPrefix(prefix)
+	}
+	return m.cfg
+}
+
+// presharedKey generates a PSK for a new peer, or returns "" when
+// Config.DisablePresharedKey is set.
+func (m *Manager) presharedKey(ctx context.Context) (string, error) {
+	if m.cfg.DisablePresharedKey {
+		return "", nil
+	}
+	return m.keys.GeneratePresharedKey(ctx)
+}
+
+// peerCommentLines returns the "# bp-" comments recorded under a new peer's
+// managed comment, in both its server block and its client file.
+func peerCommentLines(opts AddPeerOptions) []string {
+	var lines []string
+	if !opts.ExpiresAt.IsZero() {
+		lines = append(lines, expiresPrefix+" "+opts.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if len(opts.Labels) > 0 {
+		lines = append(lines, labelsLine(opts.Labels))
+	}
+	return lines
 }
 
 func (m *Manager) renderServerPeerBlock(vpnName, peerName, peerPub, psk, allowedIP string) string {
-	return fmt.Sprintf(`# bp-managed: vpn=%s,peer=%s
+	pskLine := ""
+	if psk != "" {
+		pskLine = "PresharedKey = " + psk + "\n"
+	}
+	return fmt.Sprintf(`%s
 [Peer]
 PublicKey = %s
-PresharedKey = %s
-AllowedIPs = %s
-`, vpnName, peerName, peerPub, psk, allowedIP)
+%sAllowedIPs = %s
+`, peerMetaLine(vpnName, peerName), peerPub, pskLine, allowedIP)
 }
 
-func (m *Manager) renderClientPeerConfig(vpnName, peerName, peerPriv, peerAddr, serverPub, psk, meshCIDR, endpointHost string, port int) string {
-	return fmt.Sprintf(`# bp-managed: vpn=%s,peer=%s
+// clientKeyPlaceholder stands in for the private key of a client that
+// supplied its own public key.
+const clientKeyPlaceholder = "# PrivateKey = <paste your own>"
+
+// placeholderKey is an all-zero key used only to validate such configs.
+const placeholderKey = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+func (m *Manager) renderClientPeerConfig(vpnName, peerName, peerPriv, peerAddr, serverPub, psk, allowedIPs, endpointHost string, port int) string {
+	dns := ""
+	if len(m.cfg.ClientDNS) > 0 {
+		dns = "DNS = " + strings.Join(m.cfg.ClientDNS, ", ") + "\n"
+	}
+	pskLine := ""
+	if psk != "" {
+		pskLine = "PresharedKey = " + psk + "\n"
+	}
+	if m.cfg.EndpointPort != 0 {
+		port = m.cfg.EndpointPort
+	}
+	privLine := "PrivateKey = " + peerPriv
+	if peerPriv == "" {
+		privLine = clientKeyPlaceholder
+	}
+	endpoint, obfs := formatEndpoint(endpointHost, port), ""
+	if m.cfg.ObfuscationEndpoint != "" {
+		// validate has already checked the endpoint.
+		local, _ := obfuscationEndpoint(m.cfg.ObfuscationEndpoint, port)
+		endpoint, obfs = local, m.cfg.obfsLine(endpoint)+"\n"
+	}
+	conf := fmt.Sprintf(`%s
+%s
 [Interface]
-PrivateKey = %s
+%s
 Address = %s
-
+%s%s%s%s
 [Peer]
 PublicKey = %s
-PresharedKey = %s
-AllowedIPs = %s
-Endpoint = %s:%d
-PersistentKeepalive = 25
-`, vpnName, peerName, peerPriv, peerAddr, serverPub, psk, meshCIDR, endpointHost, port)
+%sAllowedIPs = %s
+Endpoint = %s
+%s`, peerMetaLine(vpnName, peerName), m.createdLine(), privLine, peerAddr, m.cfg.mtuLine(), tableLine(m.cfg.ClientTable), fwMarkLine(m.cfg.ClientFwMark), dns, serverPub, pskLine, allowedIPs, endpoint, obfs)
+	if m.cfg.PersistentKeepalive > 0 {
+		conf += fmt.Sprintf("PersistentKeepalive = %d\n", m.cfg.PersistentKeepalive)
+	}
+	if endpoints := m.clientEndpoints(endpointHost, port); len(endpoints) > 1 {
+		conf += "# bp-endpoints: " + strings.Join(endpoints, ", ") + "\n"
+	}
+	return conf
+}
+
+// clientEndpoints returns primary followed by the distinct Config.EndpointHosts.
+func (m *Manager) clientEndpoints(primary string, port int) []string {
+	out := []string{formatEndpoint(primary, port)}
+	seen := map[string]bool{out[0]: true}
+	for _, host := range m.cfg.EndpointHosts {
+		ep := formatEndpoint(host, port)
+		if !seen[ep] {
+			seen[ep] = true
+			out = append(out, ep)
+		}
+	}
+	return out
+}
+
+// commandContext bounds a helper command by Config.CommandTimeout (0 means no limit).
+func (m *Manager) commandContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.cfg.CommandTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, m.cfg.CommandTimeout)
 }
 
 func (m *Manager) maybeRun(ctx context.Context, rep *Report, description string, cmd []string) {
@@ -688,6 +1871,17 @@ func (m *Manager) maybeRun(ctx context.Context, rep *Report, description string,
 		Status:      "suggested",
 	}
 
+	if m.cfg.NoRuntime {
+		act.Message = "runtime disabled by config"
+		rep.addRuntime(act)
+		return
+	}
+	if m.cfg.DryRun {
+		act.Status = "planned"
+		act.Message = "dry run"
+		rep.addRuntime(act)
+		return
+	}
 	if !m.sys.HasCommand(cmd[0]) {
 		act.Message = "command not available"
 		rep.addRuntime(act)
@@ -698,8 +1892,13 @@ func (m *Manager) maybeRun(ctx context.Context, rep *Report, description string,
 		rep.addRuntime(act)
 		return
 	}
-	if err := m.sys.Run(ctx, cmd[0], cmd[1:]...); err != nil {
+	runCtx, cancel := m.commandContext(ctx)
+	defer cancel()
+	if err := m.sys.Run(runCtx, cmd[0], cmd[1:]...); err != nil {
 		act.Message = err.Error()
+		if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			act.Message = fmt.Sprintf("timed out after %s", m.cfg.CommandTimeout)
+		}
 		rep.addRuntime(act)
 		return
 	}
@@ -708,30 +1907,81 @@ func (m *Manager) maybeRun(ctx context.Context, rep *Report, description string,
 	rep.addRuntime(act)
 }
 
+// Inside a network namespace wg-quick runs via "ip netns exec" and systemd
+// units are bypassed; the rendered PostUp/PostDown are wrapped by netnsRules.
+func (m *Manager) wgQuick(args ...string) []string {
+	return m.netnsCommand("wg-quick", args...)
+}
+
+func (m *Manager) netnsCommand(name string, args ...string) []string {
+	cmd := append([]string{name}, args...)
+	if m.cfg.NetNS == "" {
+		return cmd
+	}
+	return append([]string{"ip", "netns", "exec", m.cfg.NetNS}, cmd...)
+}
+
 func (m *Manager) maybeVPNEnable(ctx context.Context, rep *Report, vpn string) {
 	iface := m.cfg.InterfaceName(vpn)
-	if m.sys.HasCommand("systemctl") {
+	if m.cfg.NetNS == "" && m.sys.HasCommand("systemctl") {
 		m.maybeRun(ctx, rep, "Enable/start WireGuard interface", []string{"systemctl", "enable", "--now", "wg-quick@" + iface})
 		return
 	}
-	m.maybeRun(ctx, rep, "Bring up WireGuard interface", []string{"wg-quick", "up", iface})
+	m.maybeRun(ctx, rep, "Bring up WireGuard interface", m.wgQuick("up", iface))
 }
 
 func (m *Manager) maybeVPNDisable(ctx context.Context, rep *Report, vpn string) {
 	iface := m.cfg.InterfaceName(vpn)
-	if m.sys.HasCommand("systemctl") {
+	if m.cfg.NetNS == "" && m.sys.HasCommand("systemctl") {
 		m.maybeRun(ctx, rep, "Disable/stop WireGuard interface", []string{"systemctl", "disable", "--now", "wg-quick@" + iface})
 		return
 	}
-	m.maybeRun(ctx, rep, "Bring down WireGuard interface", []string{"wg-quick", "down", iface})
+	m.maybeRun(ctx, rep, "Bring down WireGuard interface", m.wgQuick("down", iface))
 }
 
 func (m *Manager) maybeVPNRestart(ctx context.Context, rep *Report, vpn string) {
 	iface := m.cfg.InterfaceName(vpn)
-	if m.sys.HasCommand("systemctl") {
+	if m.cfg.NetNS == "" && m.sys.HasCommand("systemctl") {
 		m.maybeRun(ctx, rep, "Restart WireGuard interface", []string{"systemctl", "restart", "wg-quick@" + iface})
 		return
 	}
-	m.maybeRun(ctx, rep, "Restart WireGuard interface", []string{"wg-quick", "down", iface})
-	m.maybeRun(ctx, rep, "Restart WireGuard interface", []string{"wg-quick", "up", iface})
+	m.maybeRun(ctx, rep, "Restart WireGuard interface", m.wgQuick("down", iface))
+	m.maybeRun(ctx, rep, "Restart WireGuard interface", m.wgQuick("up", iface))
+}
+
+// maybeVPNStop takes vpn down ahead of a rewrite of its PostUp/PostDown, so
+// the loaded rules are removed by the PostDown that matches them;
+// maybeVPNStart then brings it up with the new ones. wg-quick down is
+// skipped when the interface is not up, where it would only fail.
+func (m *Manager) maybeVPNStop(ctx context.Context, rep *Report, vpn string) {
+	iface := m.cfg.InterfaceName(vpn)
+	if m.cfg.NetNS == "" && m.sys.HasCommand("systemctl") {
+		m.maybeRun(ctx, rep, "Stop WireGuard interface", []string{"systemctl", "stop", "wg-quick@" + iface})
+		return
+	}
+	if !m.cfg.NoRuntime && !m.cfg.DryRun && !m.interfaceUp(ctx, iface) {
+		return
+	}
+	m.maybeRun(ctx, rep, "Bring down WireGuard interface", m.wgQuick("down", iface))
+}
+
+func (m *Manager) maybeVPNStart(ctx context.Context, rep *Report, vpn string) {
+	iface := m.cfg.InterfaceName(vpn)
+	if m.cfg.NetNS == "" && m.sys.HasCommand("systemctl") {
+		m.maybeRun(ctx, rep, "Start WireGuard interface", []string{"systemctl", "start", "wg-quick@" + iface})
+		return
+	}
+	m.maybeRun(ctx, rep, "Bring up WireGuard interface", m.wgQuick("up", iface))
+}
+
+// interfaceUp reports whether wg show knows iface.
+func (m *Manager) interfaceUp(ctx context.Context, iface string) bool {
+	if !m.sys.HasCommand("wg") {
+		return false
+	}
+	cmd := m.netnsCommand("wg", "show", iface)
+	cmdCtx, cancel := m.commandContext(ctx)
+	defer cancel()
+	_, err := m.sys.Output(cmdCtx, cmd[0], cmd[1:]...)
+	return err == nil
 }
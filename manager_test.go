@@ -0,0 +1,2468 @@
+package bypasser
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRenamePeer(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{WireGuardDir: t.TempDir()}, Dependencies{})
+	cfg := mgr.Config()
+	vpnPath := cfg.VPNConfigPath("home")
+	writeTestFile(t, vpnPath, `# bp-managed: vpn=home
+[Interface]
+PrivateKey = SERVER
+ListenPort = 55107
+Address = 69.0.1.1/24
+
+# bp-managed: vpn=home,peer=laptpo
+[Peer]
+PublicKey = AAA
+AllowedIPs = 69.0.1.2/32
+`)
+	writeTestFile(t, cfg.PeerConfigPath("home", "laptpo"), `# bp-managed: vpn=home,peer=laptpo
+[Interface]
+PrivateKey = CLIENT
+Address = 69.0.1.2/32
+`)
+
+	if _, err := mgr.RenamePeer(context.Background(), "home", "laptpo", "laptop"); err != nil {
+		t.Fatalf("RenamePeer returned error: %v", err)
+	}
+	if _, err := os.Stat(cfg.PeerConfigPath("home", "laptpo")); !os.IsNotExist(err) {
+		t.Fatalf("expected old peer file to be removed, got %v", err)
+	}
+	peer := readTestFile(t, cfg.PeerConfigPath("home", "laptop"))
+	if !strings.Contains(peer, "peer=laptop") || !strings.Contains(peer, "PrivateKey = CLIENT") {
+		t.Fatalf("unexpected renamed peer file:\n%s", peer)
+	}
+	vpn := readTestFile(t, vpnPath)
+	if !strings.Contains(vpn, "peer=laptop") || strings.Contains(vpn, "peer=laptpo") {
+		t.Fatalf("expected vpn metadata to be renamed:\n%s", vpn)
+	}
+}
+
+func TestRenamePeerMissingMetadataLeavesFilesUntouched(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{WireGuardDir: t.TempDir()}, Dependencies{})
+	cfg := mgr.Config()
+	vpnContent := "[Interface]\nPrivateKey = SERVER\n\n[Peer]\nPublicKey = AAA\nAllowedIPs = 69.0.1.2/32\n"
+	writeTestFile(t, cfg.VPNConfigPath("home"), vpnContent)
+	writeTestFile(t, cfg.PeerConfigPath("home", "laptpo"), "# bp-managed: vpn=home,peer=laptpo\n[Interface]\n")
+
+	if _, err := mgr.RenamePeer(context.Background(), "home", "laptpo", "laptop"); err == nil {
+		t.Fatal("expected error when vpn metadata comment is missing")
+	}
+	if got := readTestFile(t, cfg.VPNConfigPath("home")); got != vpnContent {
+		t.Fatalf("vpn config changed:\n%s", got)
+	}
+	if _, err := os.Stat(cfg.PeerConfigPath("home", "laptop")); !os.IsNotExist(err) {
+		t.Fatalf("expected no new peer file, got %v", err)
+	}
+}
+
+func TestRenamePeerRollsBackOnPeerWriteFailure(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptpo"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	cfg := mgr.Config()
+	vpnPath := cfg.VPNConfigPath("home")
+	before := readTestFile(t, vpnPath)
+	failOn := cfg.PeerConfigPath("home", "laptop")
+	mgr.write = func(path string, data []byte, perm os.FileMode) error {
+		if path == failOn {
+			return errors.New("no space left on device")
+		}
+		return writeFileAtomic(path, data, perm)
+	}
+
+	rep, err := mgr.RenamePeer(ctx, "home", "laptpo", "laptop")
+	if err == nil || !strings.Contains(err.Error(), "no space left") {
+		t.Fatalf("expected write error, got %v", err)
+	}
+	if readTestFile(t, vpnPath) != before {
+		t.Fatalf("vpn config was not restored")
+	}
+	if _, err := os.Stat(failOn); !os.IsNotExist(err) {
+		t.Fatalf("expected no new peer file, got %v", err)
+	}
+	if _, err := os.Stat(cfg.PeerConfigPath("home", "laptpo")); err != nil {
+		t.Fatalf("old peer file is gone: %v", err)
+	}
+	if len(rep.Warnings) == 0 || !strings.Contains(rep.Warnings[len(rep.Warnings)-1], "rolled back") {
+		t.Fatalf("rollback not reported: %#v", rep.Warnings)
+	}
+
+	bad := newTestManager(t, Config{WireGuardDir: cfg.WireGuardDir, FirewallBackend: "pf"})
+	if _, err := bad.RenamePeer(ctx, "home", "laptpo", "laptop"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for an invalid config, got %v", err)
+	}
+}
+
+type fakeConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c fakeConn) LocalAddr() net.Addr { return c.local }
+func (c fakeConn) Close() error        { return nil }
+
+type fakePacketConn struct{ net.PacketConn }
+
+func (fakePacketConn) Close() error { return nil }
+
+type fakeNetwork struct {
+	local map[string]net.IP
+	busy  map[string]bool
+	hosts map[string][]string
+}
+
+func (n fakeNetwork) LookupHost(_ context.Context, host string) ([]string, error) {
+	addrs, ok := n.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func (n fakeNetwork) ListenPacket(_ context.Context, _, address string) (net.PacketConn, error) {
+	if n.busy[address] {
+		return nil, errors.New("address already in use")
+	}
+	return fakePacketConn{}, nil
+}
+
+func (n fakeNetwork) DialContext(_ context.Context, network, _ string) (net.Conn, error) {
+	ip, ok := n.local[network]
+	if !ok {
+		return nil, errors.New("network unreachable")
+	}
+	return fakeConn{local: &net.UDPAddr{IP: ip, Port: 40000}}, nil
+}
+
+func TestDetectServerIPFamilies(t *testing.T) {
+	t.Parallel()
+
+	dual := fakeNetwork{local: map[string]net.IP{
+		"udp4": net.ParseIP("203.0.113.7"),
+		"udp6": net.ParseIP("2001:db8::7"),
+	}}
+	v6Only := fakeNetwork{local: map[string]net.IP{"udp6": net.ParseIP("2001:db8::7")}}
+
+	tests := []struct {
+		family string
+		net    Network
+		want   string
+	}{
+		{EndpointFamilyAuto, dual, "203.0.113.7"},
+		{EndpointFamilyV6, dual, "2001:db8::7"},
+		{EndpointFamilyAuto, v6Only, "2001:db8::7"},
+	}
+	for _, tt := range tests {
+		mgr := NewManager(Config{WireGuardDir: t.TempDir(), EndpointFamily: tt.family}, Dependencies{Net: tt.net})
+		got, err := mgr.detectServerIP(context.Background())
+		if err != nil {
+			t.Fatalf("detectServerIP(%s) returned error: %v", tt.family, err)
+		}
+		if got != tt.want {
+			t.Fatalf("detectServerIP(%s) = %q, want %q", tt.family, got, tt.want)
+		}
+	}
+
+	if got := formatEndpoint("2001:db8::7", 55107); got != "[2001:db8::7]:55107" {
+		t.Fatalf("unexpected ipv6 endpoint %q", got)
+	}
+}
+
+func TestDetectionLogsToLogger(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	sys := &FakeSystem{
+		Commands: map[string]bool{"ip": true},
+		Outputs:  map[string]string{"ip -4 route show default": "default via 192.0.2.1 dev ens3 proto dhcp\n"},
+		Errors:   map[string]error{"ip -4 -o addr show dev ens3 scope global": errors.New("exit status 1")},
+	}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), EndpointFamily: EndpointFamilyV4}, Dependencies{System: sys, Net: fakeNetwork{}, Logger: logger})
+	if _, err := mgr.detectServerIP(context.Background()); err == nil {
+		t.Fatal("expected detection to fail")
+	}
+	out := buf.String()
+	for _, want := range []string{"outbound probe failed", "network unreachable", "parsing default route", "dev ens3", "interface=ens3", "interface address lookup failed"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in log:\n%s", want, out)
+		}
+	}
+}
+
+func TestDetectDefaultInterfaceFallsBackToIPv6Route(t *testing.T) {
+	t.Parallel()
+
+	sys := &FakeSystem{
+		Commands: map[string]bool{"ip": true},
+		Outputs:  map[string]string{"ip -6 route show default": "default via fe80::1 dev ens5 proto ra metric 100\n"},
+	}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir()}, Dependencies{System: sys, Net: fakeNetwork{}})
+	iface, err := mgr.detectDefaultInterface(context.Background())
+	if err != nil {
+		t.Fatalf("detectDefaultInterface returned error: %v", err)
+	}
+	if iface != "ens5" {
+		t.Fatalf("interface = %q, want ens5", iface)
+	}
+	if got := strings.Join(sys.Calls(), "; "); got != "ip -4 route show default; ip -6 route show default" {
+		t.Fatalf("unexpected calls: %s", got)
+	}
+}
+
+func TestNormalizeRewritesOnlyOnce(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{WireGuardDir: t.TempDir()}, Dependencies{})
+	path := mgr.Config().VPNConfigPath("home")
+	writeTestFile(t, path, "[Interface]  \nPrivateKey = AAA\n\n\n\n[Peer]\nPublicKey = BBB\n\n")
+
+	rep, err := mgr.Normalize(context.Background())
+	if err != nil {
+		t.Fatalf("Normalize returned error: %v", err)
+	}
+	if len(rep.Changes) != 1 || rep.Changes[0].Action != "updated" {
+		t.Fatalf("unexpected first normalize changes: %#v", rep.Changes)
+	}
+	first := readTestFile(t, path)
+
+	rep, err = mgr.Normalize(context.Background())
+	if err != nil {
+		t.Fatalf("Normalize returned error: %v", err)
+	}
+	if len(rep.Changes) != 0 {
+		t.Fatalf("expected second normalize to be a no-op, got %#v", rep.Changes)
+	}
+	if got := readTestFile(t, path); got != first {
+		t.Fatalf("second normalize changed content:\n%q\n%q", first, got)
+	}
+}
+
+func TestSetupServerDryRunWritesNothing(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "wg")
+	sysctl := filepath.Join(t.TempDir(), "sysctl.conf")
+	mgr := NewManager(Config{WireGuardDir: dir, SysctlFile: sysctl, DryRun: true}, Dependencies{})
+
+	rep, err := mgr.SetupServer(context.Background())
+	if err != nil {
+		t.Fatalf("SetupServer returned error: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected %s not to be created, got %v", dir, err)
+	}
+	if _, err := os.Stat(sysctl); !os.IsNotExist(err) {
+		t.Fatalf("expected %s not to be written, got %v", sysctl, err)
+	}
+	if len(rep.Changes) != 3 {
+		t.Fatalf("expected 3 planned changes, got %#v", rep.Changes)
+	}
+	for _, c := range rep.Changes {
+		if c.Action != "would-create" {
+			t.Fatalf("unexpected planned action %q for %s", c.Action, c.Path)
+		}
+	}
+	if len(rep.RuntimeActions) != 1 || rep.RuntimeActions[0].Status != "planned" {
+		t.Fatalf("unexpected runtime actions: %#v", rep.RuntimeActions)
+	}
+}
+
+func TestNetNSWrapsRuntimeCommands(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), NetNS: "tenant1", DryRun: true}, Dependencies{})
+	writeTestFile(t, mgr.Config().VPNConfigPath("home"), "[Interface]\nPrivateKey = AAA\n")
+
+	rep, err := mgr.DeleteVPN(context.Background(), "home")
+	if err != nil {
+		t.Fatalf("DeleteVPN returned error: %v", err)
+	}
+	if len(rep.RuntimeActions) != 1 {
+		t.Fatalf("unexpected runtime actions: %#v", rep.RuntimeActions)
+	}
+	if got, want := rep.RuntimeActions[0].Command, "ip netns exec tenant1 wg-quick down bp-home"; got != want {
+		t.Fatalf("runtime command = %q, want %q", got, want)
+	}
+
+	bad := NewManager(Config{WireGuardDir: t.TempDir(), NetNS: "../etc"}, Dependencies{})
+	if _, err := bad.DeleteVPN(context.Background(), "home"); err == nil {
+		t.Fatal("expected invalid namespace to be rejected")
+	}
+}
+
+func TestConfigStats(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{WireGuardDir: t.TempDir()}, Dependencies{})
+	peer := "[Peer]\nPublicKey = AAA\nAllowedIPs = 69.0.1.2/32\n"
+	content := "[Interface]\nPrivateKey = SERVER\n" + peer + peer
+	writeTestFile(t, mgr.Config().VPNConfigPath("home"), content)
+
+	st, err := mgr.ConfigStats("home")
+	if err != nil {
+		t.Fatalf("ConfigStats returned error: %v", err)
+	}
+	if st.Size != int64(len(content)) || st.PeerBlocks != 2 || st.AverageBlockSize != int64(len(peer)) {
+		t.Fatalf("unexpected stats: %#v", st)
+	}
+}
+
+type fakeKeys struct{ n atomic.Int64 }
+
+// fakeKey wraps a readable label in a well-formed 32-byte base64 key.
+func fakeKey(label string) string {
+	var b [32]byte
+	copy(b[:], label)
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// fakePub is the public key fakeKeys derives from priv. Hand-written fixture
+// keys that are not base64 just get a "pub-" prefix.
+func fakePub(priv string) string {
+	b, err := base64.StdEncoding.DecodeString(priv)
+	if err != nil || len(b) != 32 {
+		return "pub-" + priv
+	}
+	return fakeKey("pub-" + strings.TrimRight(string(b), "\x00"))
+}
+
+func (k *fakeKeys) GeneratePrivateKey(context.Context) (string, error) {
+	return fakeKey(fmt.Sprintf("PRIV%d", k.n.Add(1))), nil
+}
+
+func (k *fakeKeys) DerivePublicKey(_ context.Context, priv string) (string, error) {
+	return fakePub(priv), nil
+}
+
+func (k *fakeKeys) GeneratePresharedKey(context.Context) (string, error) {
+	return fakeKey(fmt.Sprintf("PSK%d", k.n.Add(1))), nil
+}
+
+func TestVPNInfo(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{WireGuardDir: t.TempDir()}, Dependencies{Keys: &fakeKeys{}})
+	cfg := mgr.Config()
+	writeTestFile(t, cfg.VPNConfigPath("home"), "[Interface]\nPrivateKey = SERVER\nListenPort = 55107\nAddress = 69.0.1.1/24\n")
+	writeTestFile(t, cfg.PeerConfigPath("home", "laptop"), "[Interface]\n")
+	writeTestFile(t, cfg.PeerConfigPath("work", "phone"), "[Interface]\n")
+
+	info, err := mgr.VPNInfo(context.Background(), "home")
+	if err != nil {
+		t.Fatalf("VPNInfo returned error: %v", err)
+	}
+	if info.Interface != "bp-home" || info.ListenPort != 55107 || info.Address != "69.0.1.1/24" || info.PublicKey != "pub-SERVER" {
+		t.Fatalf("unexpected info: %#v", info)
+	}
+	if len(info.Peers) != 1 || info.Peers[0].Peer != "laptop" {
+		t.Fatalf("unexpected peers: %#v", info.Peers)
+	}
+
+	writeTestFile(t, cfg.VPNConfigPath("bare"), "[Interface]\nListenPort = 55108\n")
+	if _, err := mgr.VPNInfo(context.Background(), "bare"); err == nil || !strings.Contains(err.Error(), "Interface.PrivateKey") {
+		t.Fatalf("expected missing PrivateKey error, got %v", err)
+	}
+}
+
+func TestRenderClientPeerConfigKeepalive(t *testing.T) {
+	t.Parallel()
+
+	off := NewManager(Config{PersistentKeepalive: 0}, Dependencies{})
+	conf := off.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107)
+	if strings.Contains(conf, "PersistentKeepalive") {
+		t.Fatalf("expected no keepalive line:\n%s", conf)
+	}
+
+	def := NewManager(DefaultConfig(), Dependencies{})
+	conf = def.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107)
+	if !strings.Contains(conf, "PersistentKeepalive = 25\n") {
+		t.Fatalf("expected the DefaultConfig keepalive:\n%s", conf)
+	}
+	if err := (Config{PersistentKeepalive: -1}).normalized().validate(); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for keepalive -1, got %v", err)
+	}
+
+	on := NewManager(Config{PersistentKeepalive: 15}, Dependencies{})
+	conf = on.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107)
+	if !strings.Contains(conf, "PersistentKeepalive = 15\n") {
+		t.Fatalf("expected keepalive line:\n%s", conf)
+	}
+}
+
+func TestDualStackAddressing(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{IPv6Prefix: "fd00:6900"}, Dependencies{})
+	conf, err := mgr.renderVPNConfig(mgr.cfg, "home", "bp-home", "PRIV", 55107, 3, "eth0")
+	if err != nil {
+		t.Fatalf("renderVPNConfig returned error: %v", err)
+	}
+	if !strings.Contains(conf, "Address = 69.0.3.1/24, fd00:6900:3::1/64\n") {
+		t.Fatalf("expected dual-stack address:\n%s", conf)
+	}
+	if !strings.Contains(conf, "ip6tables -t nat -A POSTROUTING -s fd00:6900:3::/64 -o eth0 -j MASQUERADE") {
+		t.Fatalf("expected ip6tables masquerade rule:\n%s", conf)
+	}
+
+	cfg := mgr.Config()
+	if got, want := cfg.peerAddrs(3, 7), "69.0.3.7/32, fd00:6900:3::7/128"; got != want {
+		t.Fatalf("peerAddrs = %q, want %q", got, want)
+	}
+	v, h, err := parseBPAddress(cfg.SubnetPrefix, cfg.peerAddrs(3, 7))
+	if err != nil || v != 3 || h != 7 {
+		t.Fatalf("parseBPAddress(dual) = %d, %d, %v", v, h, err)
+	}
+}
+
+func TestListAllGroupsPeersAndFlagsOrphans(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{WireGuardDir: t.TempDir()}, Dependencies{})
+	cfg := mgr.Config()
+	writeTestFile(t, cfg.VPNConfigPath("home"), "[Interface]\n")
+	writeTestFile(t, cfg.VPNConfigPath("empty"), "[Interface]\n")
+	writeTestFile(t, cfg.PeerConfigPath("home", "phone"), "[Interface]\n")
+	writeTestFile(t, cfg.PeerConfigPath("home", "laptop"), "[Interface]\n")
+	writeTestFile(t, cfg.PeerConfigPath("gone", "tablet"), "[Interface]\n")
+
+	got, err := mgr.ListAll()
+	if err != nil {
+		t.Fatalf("ListAll returned error: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 summaries, got %#v", got)
+	}
+	if got[0].Name != "empty" || len(got[0].Peers) != 0 || got[0].Orphaned {
+		t.Fatalf("unexpected summary: %#v", got[0])
+	}
+	if got[1].Name != "gone" || !got[1].Orphaned || len(got[1].Peers) != 1 {
+		t.Fatalf("expected orphaned summary, got %#v", got[1])
+	}
+	if got[2].Name != "home" || got[2].Interface != "bp-home" || strings.Join(got[2].Peers, ",") != "laptop,phone" {
+		t.Fatalf("unexpected summary: %#v", got[2])
+	}
+}
+
+func TestNextAvailablePortSkipsBoundPorts(t *testing.T) {
+	t.Parallel()
+
+	network := fakeNetwork{busy: map[string]bool{":55107": true, ":55108": true}}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), MinPort: 55107, MaxPort: 55109, CheckPortInUse: true}, Dependencies{Net: network})
+
+	var rep Report
+	port, err := mgr.nextAvailablePort(context.Background(), &rep)
+	if err != nil {
+		t.Fatalf("nextAvailablePort returned error: %v", err)
+	}
+	if port != 55109 {
+		t.Fatalf("port = %d, want 55109", port)
+	}
+	if len(rep.Warnings) != 2 {
+		t.Fatalf("expected a warning per skipped port, got %#v", rep.Warnings)
+	}
+
+	network.busy[":55109"] = true
+	if _, err := mgr.nextAvailablePort(context.Background(), &Report{}); err == nil {
+		t.Fatal("expected exhausted range error")
+	}
+}
+
+func TestNextAllocations(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{MinPort: 55107, MaxPort: 55108})
+	port, octet, err := mgr.NextAllocations()
+	if err != nil {
+		t.Fatalf("NextAllocations returned error: %v", err)
+	}
+	if port != 55107 || octet != 1 {
+		t.Fatalf("NextAllocations = %d, %d, want 55107, 1", port, octet)
+	}
+	if vpns, _ := mgr.ListVPNs(); len(vpns) != 0 {
+		t.Fatalf("NextAllocations created vpns: %v", vpns)
+	}
+
+	res, err := mgr.AddVPN(ctx, "home")
+	if err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if res.ListenPort != port {
+		t.Fatalf("AddVPN used port %d, NextAllocations predicted %d", res.ListenPort, port)
+	}
+	if port, octet, err = mgr.NextAllocations(); err != nil || port != 55108 || octet != 2 {
+		t.Fatalf("NextAllocations = %d, %d, %v, want 55108, 2", port, octet, err)
+	}
+
+	if _, err := mgr.AddVPN(ctx, "work"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, _, err := mgr.NextAllocations(); err == nil || !strings.Contains(err.Error(), "no available port") {
+		t.Fatalf("expected exhausted range error, got %v", err)
+	}
+}
+
+func TestRenderersStampCreationTime(t *testing.T) {
+	t.Parallel()
+
+	fixed := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	mgr := NewManager(Config{}, Dependencies{Clock: func() time.Time { return fixed }})
+	want := "# created: 2026-03-01T12:30:00Z\n"
+
+	vpn, err := mgr.renderVPNConfig(mgr.cfg, "home", "bp-home", "PRIV", 55107, 1, "eth0")
+	if err != nil {
+		t.Fatalf("renderVPNConfig returned error: %v", err)
+	}
+	if !strings.HasPrefix(vpn, "# bp-managed: vpn=home,prefix=69.0\n"+want+"[Interface]\n") {
+		t.Fatalf("unexpected vpn header:\n%s", vpn)
+	}
+	client := mgr.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107)
+	if !strings.HasPrefix(client, "# bp-managed: vpn=home,peer=laptop\n"+want+"[Interface]\n") {
+		t.Fatalf("unexpected client header:\n%s", client)
+	}
+}
+
+func TestAddVPNEnablesInterfaceOnlyAsRootWithSystemctl(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		root     bool
+		commands map[string]bool
+		want     []string
+	}{
+		{true, map[string]bool{"systemctl": true}, []string{"systemctl enable --now wg-quick@bp-home"}},
+		{false, map[string]bool{"systemctl": true}, nil},
+		{true, map[string]bool{}, nil},
+	}
+	for _, tt := range tests {
+		sys := &FakeSystem{RootValue: tt.root, Commands: tt.commands}
+		mgr := NewManager(Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0"}, Dependencies{System: sys, Keys: &fakeKeys{}})
+		res, err := mgr.AddVPN(context.Background(), "home")
+		if err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+		if got := sys.Calls(); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Fatalf("root=%v commands=%v: calls = %q, want %q", tt.root, tt.commands, got, tt.want)
+		}
+		if len(res.RuntimeActions) != 1 {
+			t.Fatalf("expected one runtime action, got %#v", res.RuntimeActions)
+		}
+	}
+}
+
+func TestClientDNS(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{ClientDNS: []string{"69.0.1.1", "1.1.1.1"}}, Dependencies{})
+	conf := mgr.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107)
+	if !strings.Contains(conf, "Address = 69.0.1.2/32\nDNS = 69.0.1.1, 1.1.1.1\n\n[Peer]") {
+		t.Fatalf("expected DNS line in client interface:\n%s", conf)
+	}
+
+	if err := (Config{ClientDNS: []string{"not-an-ip"}}).validate(); err == nil {
+		t.Fatal("expected invalid dns entry to be rejected")
+	}
+}
+
+func newTestManager(t *testing.T, cfg Config) *Manager {
+	t.Helper()
+	if cfg.WireGuardDir == "" {
+		cfg.WireGuardDir = t.TempDir()
+	}
+	if cfg.PublicInterface == "" {
+		cfg.PublicInterface = "eth0"
+	}
+	if cfg.EndpointHost == "" {
+		cfg.EndpointHost = "203.0.113.7"
+	}
+	return NewManager(cfg, Dependencies{System: &FakeSystem{}, Keys: &fakeKeys{}})
+}
+
+func TestAddPeerAllowedIPs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{ClientAllowedIPs: "0.0.0.0/0, ::/0"})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+
+	full, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if !strings.Contains(full.PeerConfig, "AllowedIPs = 0.0.0.0/0, ::/0\n") {
+		t.Fatalf("expected full-tunnel allowed ips:\n%s", full.PeerConfig)
+	}
+
+	split, err := mgr.AddPeerWithOptions(ctx, "home", "phone", AddPeerOptions{AllowedIPs: "69.0.1.0/24"})
+	if err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	if !strings.Contains(split.PeerConfig, "AllowedIPs = 69.0.1.0/24\n") {
+		t.Fatalf("expected per-peer allowed ips override:\n%s", split.PeerConfig)
+	}
+
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "tablet", AddPeerOptions{AllowedIPs: "everything"}); err == nil {
+		t.Fatal("expected invalid allowed ips to be rejected")
+	}
+}
+
+func TestRegenerateKeysKeepsAddressAndOtherPeers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	laptop, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "phone"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	before := readTestFile(t, mgr.Config().VPNConfigPath("home"))
+
+	res, err := mgr.RegenerateKeys(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("RegenerateKeys returned error: %v", err)
+	}
+	oldPriv := firstSectionValue(laptop.PeerConfig, "Interface", "PrivateKey")
+	newPriv := firstSectionValue(res.PeerConfig, "Interface", "PrivateKey")
+	if newPriv == oldPriv {
+		t.Fatal("expected a new peer private key")
+	}
+	if got, want := firstSectionValue(res.PeerConfig, "Interface", "Address"), firstSectionValue(laptop.PeerConfig, "Interface", "Address"); got != want {
+		t.Fatalf("address changed from %q to %q", want, got)
+	}
+
+	after := readTestFile(t, mgr.Config().VPNConfigPath("home"))
+	if !strings.Contains(after, "PublicKey = "+fakePub(newPriv)) {
+		t.Fatalf("expected server block to carry the new public key:\n%s", after)
+	}
+	if strings.Count(after, "[Peer]") != 2 || strings.Count(after, "AllowedIPs") != strings.Count(before, "AllowedIPs") {
+		t.Fatalf("unexpected server config after rotation:\n%s", after)
+	}
+	phoneBlock := before[strings.Index(before, "peer=phone"):]
+	if !strings.Contains(after, phoneBlock) {
+		t.Fatalf("expected phone block to be untouched:\n%s", after)
+	}
+}
+
+func TestRotateVPNKeysUpdatesEveryPeer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	var before []string
+	for _, name := range []string{"laptop", "phone"} {
+		res, err := mgr.AddPeer(ctx, "home", name)
+		if err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+		before = append(before, res.PeerConfig)
+	}
+
+	rep, err := mgr.RotateVPNKeys(ctx, "home")
+	if err != nil {
+		t.Fatalf("RotateVPNKeys returned error: %v", err)
+	}
+	if len(rep.Changes) != 3 {
+		t.Fatalf("expected vpn config and two peer files to change, got %#v", rep.Changes)
+	}
+
+	serverPriv := firstSectionValue(readTestFile(t, mgr.Config().VPNConfigPath("home")), "Interface", "PrivateKey")
+	for i, name := range []string{"laptop", "phone"} {
+		conf := readTestFile(t, mgr.Config().PeerConfigPath("home", name))
+		if got := firstSectionValue(conf, "Peer", "PublicKey"); got != fakePub(serverPriv) {
+			t.Fatalf("peer %s server public key = %q, want %q", name, got, fakePub(serverPriv))
+		}
+		for _, key := range []string{"PrivateKey", "PresharedKey"} {
+			section := "Interface"
+			if key == "PresharedKey" {
+				section = "Peer"
+			}
+			if firstSectionValue(conf, section, key) != firstSectionValue(before[i], section, key) {
+				t.Fatalf("peer %s %s changed", name, key)
+			}
+		}
+	}
+}
+
+func TestFindPeerByPublicKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	keys := make(map[string]string)
+	for _, peer := range []string{"laptop", "phone"} {
+		res, err := mgr.AddPeer(ctx, "home", peer)
+		if err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+		keys[peer] = fakePub(firstSectionValue(res.PeerConfig, "Interface", "PrivateKey"))
+	}
+	// Without its managed comment the phone block is only found through the
+	// peer file's private key.
+	vpnPath := mgr.Config().VPNConfigPath("home")
+	writeTestFile(t, vpnPath, strings.Replace(readTestFile(t, vpnPath), peerMetaLine("home", "phone")+"\n", "", 1))
+
+	for peer, key := range keys {
+		ref, err := mgr.FindPeerByPublicKey(ctx, key)
+		if err != nil {
+			t.Fatalf("FindPeerByPublicKey(%s) returned error: %v", peer, err)
+		}
+		if ref != (PeerRef{VPN: "home", Peer: peer}) {
+			t.Fatalf("FindPeerByPublicKey(%s) = %#v", peer, ref)
+		}
+	}
+	if _, err := mgr.FindPeerByPublicKey(ctx, fakeKey("nobody")); !errors.Is(err, ErrPeerNotFound) {
+		t.Fatalf("expected ErrPeerNotFound, got %v", err)
+	}
+	if _, err := mgr.FindPeerByPublicKey(ctx, "not-a-key"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation, got %v", err)
+	}
+}
+
+func TestImportPeer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	vpnPath := mgr.Config().VPNConfigPath("home")
+	serverPriv := firstSectionValue(readTestFile(t, vpnPath), "Interface", "PrivateKey")
+
+	legacyPriv, legacyPSK := fakeKey("LEGACY"), fakeKey("LEGACYPSK")
+	legacy := `[Interface]
+PrivateKey = ` + legacyPriv + `
+Address = 69.0.1.5/32
+
+[Peer]
+PublicKey = ` + fakePub(serverPriv) + `
+PresharedKey = ` + legacyPSK + `
+AllowedIPs = 69.0.1.0/24
+Endpoint = 203.0.113.7:55107
+`
+	rep, err := mgr.ImportPeer(ctx, "home", "nas", legacy)
+	if err != nil {
+		t.Fatalf("ImportPeer returned error: %v", err)
+	}
+	if len(rep.Warnings) != 0 {
+		t.Fatalf("unexpected warnings: %#v", rep.Warnings)
+	}
+	vpn := readTestFile(t, vpnPath)
+	if !strings.Contains(vpn, "# bp-managed: vpn=home,peer=nas\n[Peer]\nPublicKey = "+fakePub(legacyPriv)+"\nPresharedKey = "+legacyPSK+"\nAllowedIPs = 69.0.1.5/32\n") {
+		t.Fatalf("unexpected server block:\n%s", vpn)
+	}
+	peer := readTestFile(t, mgr.Config().PeerConfigPath("home", "nas"))
+	if !strings.HasPrefix(peer, "# bp-managed: vpn=home,peer=nas\n[Interface]\nPrivateKey = "+legacyPriv+"\n") {
+		t.Fatalf("unexpected peer file:\n%s", peer)
+	}
+
+	if _, err := mgr.ImportPeer(ctx, "home", "other", legacy); err == nil || !strings.Contains(err.Error(), "collides") {
+		t.Fatalf("expected address collision error, got %v", err)
+	}
+
+	malformed := strings.Replace(legacy, legacyPriv, "LEGACY", 1)
+	if _, err := mgr.ImportPeer(ctx, "home", "bad", malformed); !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "malformed Interface.PrivateKey") {
+		t.Fatalf("expected malformed key error, got %v", err)
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "phone"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+
+	diags, err := mgr.Doctor(ctx)
+	if err != nil {
+		t.Fatalf("Doctor returned error: %v", err)
+	}
+	if len(diags) != 0 {
+		t.Fatalf("expected clean tree, got %#v", diags)
+	}
+
+	cfg := mgr.Config()
+	home := readTestFile(t, cfg.VPNConfigPath("home"))
+	home = strings.Replace(home, "PrivateKey = ", "# PrivateKey = ", 1)
+	home += "\n# bp-managed: vpn=home,peer=ghost\n[Peer]\nPublicKey = pub-GHOST\nAllowedIPs = " +
+		firstSectionValue(readTestFile(t, cfg.PeerConfigPath("home", "laptop")), "Interface", "Address") + "\n"
+	writeTestFile(t, cfg.VPNConfigPath("home"), home)
+	work := readTestFile(t, cfg.VPNConfigPath("work"))
+	port := firstSectionValue(home, "Interface", "ListenPort")
+	work, _ = replaceLine(work, "ListenPort = "+firstSectionValue(work, "Interface", "ListenPort"), "ListenPort = "+port)
+	writeTestFile(t, cfg.VPNConfigPath("work"), work)
+	writeTestFile(t, cfg.PeerConfigPath("gone", "tablet"), "[Interface]\nAddress = 69.0.9.2/32\n")
+	if err := os.Remove(cfg.PeerConfigPath("home", "phone")); err != nil {
+		t.Fatalf("remove peer file: %v", err)
+	}
+
+	diags, err = mgr.Doctor(ctx)
+	if err != nil {
+		t.Fatalf("Doctor returned error: %v", err)
+	}
+	want := []string{
+		"missing Interface.PrivateKey",
+		"AllowedIPs",
+		"home:phone has no matching peer file",
+		"ghost has no matching peer file",
+		"already used by vpn",
+		"which has no config",
+	}
+	for _, w := range want {
+		found := false
+		for _, d := range diags {
+			if strings.Contains(d.Message, w) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("missing diagnostic %q in %#v", w, diags)
+		}
+	}
+}
+
+func TestAddVPNWithPort(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	res, err := mgr.AddVPNWithOptions(ctx, "home", AddVPNOptions{Port: 55150})
+	if err != nil {
+		t.Fatalf("AddVPNWithOptions returned error: %v", err)
+	}
+	if got := firstSectionValue(readTestFile(t, res.ConfigPath), "Interface", "ListenPort"); got != "55150" {
+		t.Fatalf("expected pinned port, got %q", got)
+	}
+
+	if _, err := mgr.AddVPNWithOptions(ctx, "work", AddVPNOptions{Port: 55150}); err == nil || !strings.Contains(err.Error(), `already used by vpn "home"`) {
+		t.Fatalf("expected port collision error, got %v", err)
+	}
+	if _, err := mgr.AddVPNWithOptions(ctx, "work", AddVPNOptions{Port: 80}); err == nil || !strings.Contains(err.Error(), "outside the allowed range") {
+		t.Fatalf("expected range error, got %v", err)
+	}
+
+	auto, err := mgr.AddVPN(ctx, "work")
+	if err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if got := firstSectionValue(readTestFile(t, auto.ConfigPath), "Interface", "ListenPort"); got != "55151" {
+		t.Fatalf("expected next port after pinned one, got %q", got)
+	}
+}
+
+func TestAddVPNWithSubnetOctet(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	res, err := mgr.AddVPNWithOptions(ctx, "home", AddVPNOptions{SubnetOctet: 10})
+	if err != nil {
+		t.Fatalf("AddVPNWithOptions returned error: %v", err)
+	}
+	if got := firstSectionValue(readTestFile(t, res.ConfigPath), "Interface", "Address"); got != "69.0.10.1/24" {
+		t.Fatalf("expected pinned subnet, got %q", got)
+	}
+
+	if _, err := mgr.AddVPNWithOptions(ctx, "work", AddVPNOptions{SubnetOctet: 10}); err == nil || !strings.Contains(err.Error(), `already used by vpn "home"`) {
+		t.Fatalf("expected subnet collision error, got %v", err)
+	}
+	if _, err := mgr.AddVPNWithOptions(ctx, "work", AddVPNOptions{SubnetOctet: 255}); err == nil {
+		t.Fatal("expected range error for octet 255")
+	}
+	if _, err := mgr.AddVPNWithOptions(ctx, "work", AddVPNOptions{SubnetOctet: 3}); err != nil {
+		t.Fatalf("AddVPNWithOptions returned error: %v", err)
+	}
+}
+
+func TestPeerEditsPreserveUserComments(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	for _, peer := range []string{"laptop", "phone"} {
+		if _, err := mgr.AddPeer(ctx, "home", peer); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+	}
+
+	vpnPath := mgr.Config().VPNConfigPath("home")
+	content := readTestFile(t, vpnPath)
+	content = strings.Replace(content, "[Interface]\n", "[Interface]\n# keep the table off, routes are managed elsewhere\nTable = off\n", 1)
+	content = strings.Replace(content, "\n# bp-managed: vpn=home,peer=phone\n", "\n# phone belongs to alice\n\n# bp-managed: vpn=home,peer=phone\n", 1)
+	writeTestFile(t, vpnPath, content)
+
+	if _, err := mgr.AddPeer(ctx, "home", "tablet"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.DeletePeer(ctx, "home", "tablet"); err != nil {
+		t.Fatalf("DeletePeer returned error: %v", err)
+	}
+	if got := readTestFile(t, vpnPath); got != content {
+		t.Fatalf("add+delete did not round-trip:\nwant:\n%s\ngot:\n%s", content, got)
+	}
+
+	if _, err := mgr.DeletePeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("DeletePeer returned error: %v", err)
+	}
+	got := readTestFile(t, vpnPath)
+	for _, want := range []string{"# keep the table off", "Table = off", "\n\n# phone belongs to alice\n\n# bp-managed: vpn=home,peer=phone\n[Peer]\n"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("missing %q after delete:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "peer=laptop") {
+		t.Fatalf("laptop block was not removed:\n%s", got)
+	}
+}
+
+func TestMovePeer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	added, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "work", "desk"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	peerPriv := firstSectionValue(added.PeerConfig, "Interface", "PrivateKey")
+
+	if _, err := mgr.MovePeer(ctx, "home", "work", "laptop"); err != nil {
+		t.Fatalf("MovePeer returned error: %v", err)
+	}
+
+	cfg := mgr.Config()
+	if _, err := os.Stat(cfg.PeerConfigPath("home", "laptop")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected source peer file to be removed, got %v", err)
+	}
+	if strings.Contains(readTestFile(t, cfg.VPNConfigPath("home")), "peer=laptop") {
+		t.Fatal("source server block was not removed")
+	}
+
+	work := readTestFile(t, cfg.VPNConfigPath("work"))
+	if !strings.Contains(work, "# bp-managed: vpn=work,peer=laptop\n[Peer]\nPublicKey = "+fakePub(peerPriv)+"\n") ||
+		!strings.Contains(work, "AllowedIPs = 69.0.2.3/32") {
+		t.Fatalf("unexpected destination vpn config:\n%s", work)
+	}
+
+	client := readTestFile(t, cfg.PeerConfigPath("work", "laptop"))
+	workPriv := firstSectionValue(work, "Interface", "PrivateKey")
+	want := map[[2]string]string{
+		{"Interface", "PrivateKey"}: peerPriv,
+		{"Interface", "Address"}:    "69.0.2.3/32",
+		{"Peer", "PublicKey"}:       fakePub(workPriv),
+		{"Peer", "AllowedIPs"}:      "69.0.2.0/24",
+		{"Peer", "Endpoint"}:        "203.0.113.7:55108",
+	}
+	for k, v := range want {
+		if got := firstSectionValue(client, k[0], k[1]); got != v {
+			t.Fatalf("%s.%s = %q, want %q\n%s", k[0], k[1], got, v, client)
+		}
+	}
+	if firstSectionValue(client, "Peer", "PresharedKey") == firstSectionValue(added.PeerConfig, "Peer", "PresharedKey") {
+		t.Fatal("expected a fresh preshared key")
+	}
+}
+
+func TestSingleOctetPrefixAddressing(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{SubnetPrefix: "10", IPv6Prefix: "fd00:6900"})
+	res, err := mgr.AddVPN(ctx, "home")
+	if err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	vpn := readTestFile(t, res.ConfigPath)
+	if got := firstSectionValue(vpn, "Interface", "Address"); got != "10.1.0.1/16, fd00:6900:1::0:1/64" {
+		t.Fatalf("unexpected server address %q", got)
+	}
+
+	for h := 2; h <= 255; h++ {
+		vpn += fmt.Sprintf("\n[Peer]\nPublicKey = X%d\nAllowedIPs = 10.1.0.%d/32\n", h, h)
+	}
+	writeTestFile(t, res.ConfigPath, vpn)
+	peer, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if got := firstSectionValue(peer.PeerConfig, "Interface", "Address"); got != "10.1.1.0/32, fd00:6900:1::1:0/128" {
+		t.Fatalf("unexpected peer address %q", got)
+	}
+	if got := firstSectionValue(peer.PeerConfig, "Peer", "AllowedIPs"); got != "10.1.0.0/16, fd00:6900:1::/64" {
+		t.Fatalf("unexpected client allowed ips %q", got)
+	}
+
+	bad := newTestManager(t, Config{SubnetPrefix: "10", InterfaceMask: 24})
+	if _, err := bad.AddVPN(ctx, "home"); err == nil || !strings.Contains(err.Error(), "too narrow") {
+		t.Fatalf("expected mask validation error, got %v", err)
+	}
+}
+
+func TestAddPeerWithHostOctet(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	nas, err := mgr.AddPeerWithOptions(ctx, "home", "nas", AddPeerOptions{HostOctet: 50})
+	if err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	if got := firstSectionValue(nas.PeerConfig, "Interface", "Address"); got != "69.0.1.50/32" {
+		t.Fatalf("unexpected reserved address %q", got)
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "other", AddPeerOptions{HostOctet: 50}); err == nil || !strings.Contains(err.Error(), "already assigned") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+	for _, host := range []int{1, 255} {
+		if _, err := mgr.AddPeerWithOptions(ctx, "home", "other", AddPeerOptions{HostOctet: host}); err == nil || !strings.Contains(err.Error(), "outside the allowed range") {
+			t.Fatalf("expected range error for host %d, got %v", host, err)
+		}
+	}
+}
+
+func TestAutoAllocationSkipsPinnedHost(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "router", AddPeerOptions{HostOctet: 254}); err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "nas", AddPeerOptions{HostOctet: 2}); err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	res, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer after pinning .254 returned error: %v", err)
+	}
+	if got := firstSectionValue(res.PeerConfig, "Interface", "Address"); got != "69.0.1.3/32" {
+		t.Fatalf("expected the lowest free address, got %q", got)
+	}
+}
+
+func TestAddReportsPathsOfExistingFiles(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	cfg := mgr.Config()
+
+	vpn, err := mgr.AddVPN(ctx, "home")
+	if !errors.Is(err, ErrVPNExists) {
+		t.Fatalf("expected ErrVPNExists, got %v", err)
+	}
+	if vpn.ConfigPath != cfg.VPNConfigPath("home") || vpn.Interface != "bp-home" {
+		t.Fatalf("paths not reported for existing vpn: %#v", vpn)
+	}
+	peer, err := mgr.AddPeer(ctx, "home", "laptop")
+	if !errors.Is(err, ErrPeerExists) {
+		t.Fatalf("expected ErrPeerExists, got %v", err)
+	}
+	if peer.PeerConfigPath != cfg.PeerConfigPath("home", "laptop") || peer.PeerRef != (PeerRef{VPN: "home", Peer: "laptop"}) {
+		t.Fatalf("paths not reported for existing peer: %#v", peer)
+	}
+}
+
+func TestManagerPaths(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	mgr := NewManager(Config{WireGuardDir: dir}, Dependencies{})
+	for got, want := range map[string]string{
+		mgr.WireGuardDir():            dir,
+		mgr.PeersDir():                filepath.Join(dir, "peers"),
+		mgr.VPNPath("home"):           filepath.Join(dir, "bp-home.conf"),
+		mgr.PeerPath("home", "phone"): filepath.Join(dir, "peers", "bp-home-phone.conf"),
+	} {
+		if got != want {
+			t.Fatalf("path = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestAddPeersBatch(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	vpnPath := mgr.Config().VPNConfigPath("home")
+	before := readTestFile(t, vpnPath)
+
+	for _, names := range [][]string{{"alice", "alice"}, {"alice", "laptop"}, {"alice", "Bob"}, nil} {
+		if _, err := mgr.AddPeers(ctx, "home", names); err == nil {
+			t.Fatalf("AddPeers(%v) succeeded", names)
+		}
+		if readTestFile(t, vpnPath) != before {
+			t.Fatalf("AddPeers(%v) modified the vpn config", names)
+		}
+	}
+
+	results, err := mgr.AddPeers(ctx, "home", []string{"alice", "bob", "carol"})
+	if err != nil {
+		t.Fatalf("AddPeers returned error: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	vpnWrites, runtime := 0, 0
+	for i, res := range results {
+		want := fmt.Sprintf("69.0.1.%d/32", i+3)
+		if addr := firstSectionValue(res.PeerConfig, "Interface", "Address"); addr != want {
+			t.Fatalf("%s address = %q, want %q", res.Peer, addr, want)
+		}
+		if readTestFile(t, res.PeerConfigPath) != res.PeerConfig {
+			t.Fatalf("client file of %s does not match the result", res.Peer)
+		}
+		for _, c := range res.Changes {
+			if c.Path == vpnPath {
+				vpnWrites++
+			}
+		}
+		runtime += len(res.RuntimeActions)
+	}
+	if vpnWrites != 1 {
+		t.Fatalf("expected one vpn config write, got %d", vpnWrites)
+	}
+	if runtime != 2 {
+		t.Fatalf("expected a single restart (down, up), got %d runtime actions", runtime)
+	}
+	if got := strings.Count(readTestFile(t, vpnPath), "[Peer]"); got != 4 {
+		t.Fatalf("expected 4 peer blocks, got %d", got)
+	}
+}
+
+func TestAddPeerRollsBackOnClientWriteFailure(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	cfg := mgr.Config()
+	vpnPath := cfg.VPNConfigPath("home")
+	before := readTestFile(t, vpnPath)
+	failOn := cfg.PeerConfigPath("home", "bob")
+	mgr.write = func(path string, data []byte, perm os.FileMode) error {
+		if path == failOn {
+			return errors.New("no space left on device")
+		}
+		return writeFileAtomic(path, data, perm)
+	}
+
+	res, err := mgr.AddPeer(ctx, "home", "bob")
+	if err == nil || !strings.Contains(err.Error(), "no space left") {
+		t.Fatalf("expected write error, got %v", err)
+	}
+	if readTestFile(t, vpnPath) != before {
+		t.Fatalf("vpn config was not restored")
+	}
+	if len(res.Warnings) == 0 || !strings.Contains(res.Warnings[len(res.Warnings)-1], "rolled back") {
+		t.Fatalf("rollback not reported: %#v", res.Warnings)
+	}
+
+	if _, err := mgr.AddPeers(ctx, "home", []string{"alice", "bob", "carol"}); err == nil {
+		t.Fatal("expected AddPeers to fail")
+	}
+	if readTestFile(t, vpnPath) != before {
+		t.Fatalf("vpn config was not restored after AddPeers")
+	}
+	if peers, err := mgr.ListPeers(); err != nil || len(peers) != 0 {
+		t.Fatalf("client files left behind: %v, %v", peers, err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	sys := &FakeSystem{Commands: map[string]bool{}, Outputs: map[string]string{}}
+	mgr := NewManager(Config{WireGuardDir: dir, PublicInterface: "eth0", EndpointHost: "203.0.113.7"}, Dependencies{System: sys, Keys: &fakeKeys{}})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	laptop, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "phone"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	laptopPub := fakePub(firstSectionValue(laptop.PeerConfig, "Interface", "PrivateKey"))
+
+	st, err := mgr.Status(ctx, "home")
+	if err != nil {
+		t.Fatalf("Status returned error: %v", err)
+	}
+	if st.Up || !strings.Contains(st.Message, "not installed") || len(st.Peers) != 2 {
+		t.Fatalf("unexpected status without wg: %#v", st)
+	}
+
+	sys.Commands["wg"] = true
+	sys.Outputs["wg show bp-home dump"] = strings.Join([]string{
+		"SRVPRIV\tpub-SRV\t55107\toff",
+		laptopPub + "\tPSK\t198.51.100.4:40000\t69.0.1.2/32\t1700000000\t1024\t2048\t25",
+		"pub-STRANGER\t(none)\t(none)\t69.0.1.9/32\t0\t0\t0\toff",
+	}, "\n")
+	st, err = mgr.Status(ctx, "home")
+	if err != nil {
+		t.Fatalf("Status returned error: %v", err)
+	}
+	if !st.Up || st.PublicKey != "pub-SRV" || st.ListenPort != 55107 || len(st.Peers) != 3 {
+		t.Fatalf("unexpected status: %#v", st)
+	}
+	lp := st.Peers[0]
+	if lp.Peer != "laptop" || !lp.Live || lp.Endpoint != "198.51.100.4:40000" || lp.TransferRx != 1024 || lp.TransferTx != 2048 || lp.LatestHandshake.Unix() != 1700000000 {
+		t.Fatalf("unexpected laptop status: %#v", lp)
+	}
+	if st.Peers[1].Peer != "phone" || st.Peers[1].Live {
+		t.Fatalf("unexpected phone status: %#v", st.Peers[1])
+	}
+	if st.Peers[2].Peer != "" || st.Peers[2].Endpoint != "" || !st.Peers[2].LatestHandshake.IsZero() {
+		t.Fatalf("unexpected unknown peer status: %#v", st.Peers[2])
+	}
+	b, err := json.Marshal(st.Peers[2])
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v", err)
+	}
+	if strings.Contains(string(b), "latest_handshake") {
+		t.Fatalf("zero handshake was encoded: %s", b)
+	}
+}
+
+func TestAddPeerWarnsOnUnresolvableEndpoint(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	netw := fakeNetwork{hosts: map[string][]string{"vpn.example.com": {"203.0.113.7"}}}
+	newMgr := func(host string) *Manager {
+		return NewManager(Config{WireGuardDir: dir, PublicInterface: "eth0", EndpointHost: host}, Dependencies{System: &FakeSystem{}, Keys: &fakeKeys{}, Net: netw})
+	}
+	if _, err := newMgr("vpn.example.com").AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+
+	ok, err := newMgr("vpn.example.com").AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if len(ok.Report.Warnings) != 0 {
+		t.Fatalf("unexpected warnings: %#v", ok.Report.Warnings)
+	}
+
+	typo, err := newMgr("vpn.exmaple.com").AddPeer(ctx, "home", "phone")
+	if err != nil {
+		t.Fatalf("AddPeer should not fail on an unresolvable endpoint: %v", err)
+	}
+	if len(typo.Report.Warnings) != 1 || !strings.Contains(typo.Report.Warnings[0], `"vpn.exmaple.com" does not resolve`) {
+		t.Fatalf("expected resolve warning, got %#v", typo.Report.Warnings)
+	}
+	if !strings.Contains(typo.PeerConfig, "Endpoint = vpn.exmaple.com:") {
+		t.Fatalf("config should still be generated:\n%s", typo.PeerConfig)
+	}
+
+	if lit, err := newMgr("2001:db8::7").AddPeer(ctx, "home", "tablet"); err != nil || len(lit.Report.Warnings) != 0 {
+		t.Fatalf("ip literal should skip lookup: %v %#v", err, lit.Report.Warnings)
+	}
+}
+
+func TestExportHosts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{IPv6Prefix: "fd00:6900"})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	for _, ref := range []PeerRef{{"home", "laptop"}, {"home", "phone"}, {"work", "desk"}} {
+		if _, err := mgr.AddPeer(ctx, ref.VPN, ref.Peer); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+	}
+
+	home, err := mgr.ExportHosts("home")
+	if err != nil {
+		t.Fatalf("ExportHosts returned error: %v", err)
+	}
+	want := "69.0.1.2 laptop.home\nfd00:6900:1::2 laptop.home\n69.0.1.3 phone.home\nfd00:6900:1::3 phone.home\n"
+	if home != want {
+		t.Fatalf("unexpected hosts:\n%s", home)
+	}
+
+	all, err := mgr.ExportAllHosts()
+	if err != nil {
+		t.Fatalf("ExportAllHosts returned error: %v", err)
+	}
+	if all != want+"69.0.2.2 desk.work\nfd00:6900:2::2 desk.work\n" {
+		t.Fatalf("unexpected hosts:\n%s", all)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{BackupDir: t.TempDir()})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	cfg := mgr.Config()
+	vpnBefore := readTestFile(t, cfg.VPNConfigPath("home"))
+	peerBefore := readTestFile(t, cfg.PeerConfigPath("home", "laptop"))
+
+	rep, err := mgr.DeletePeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("DeletePeer returned error: %v", err)
+	}
+	var backupPath string
+	for _, c := range rep.Changes {
+		if c.Action == "backed-up" {
+			backupPath = c.Path
+		}
+	}
+	if backupPath == "" {
+		t.Fatalf("expected a backed-up change, got %#v", rep.Changes)
+	}
+	info, err := os.Stat(filepath.Join(backupPath, cfg.PeersSubdir, filepath.Base(cfg.PeerConfigPath("home", "laptop"))))
+	if err != nil {
+		t.Fatalf("backup of peer file missing: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("backup perms = %v, want 0600", info.Mode().Perm())
+	}
+
+	if _, err := mgr.RestoreBackup(filepath.Base(backupPath)); err != nil {
+		t.Fatalf("RestoreBackup returned error: %v", err)
+	}
+	if got := readTestFile(t, cfg.VPNConfigPath("home")); got != vpnBefore {
+		t.Fatalf("vpn config not restored:\n%s", got)
+	}
+	if got := readTestFile(t, cfg.PeerConfigPath("home", "laptop")); got != peerBefore {
+		t.Fatalf("peer config not restored:\n%s", got)
+	}
+
+	if _, err := mgr.RestoreBackup("../escape"); err == nil {
+		t.Fatal("expected invalid backup name error")
+	}
+}
+
+type hangingSystem struct{ FakeSystem }
+
+func (h *hangingSystem) Run(ctx context.Context, name string, args ...string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRuntimeCommandTimeout(t *testing.T) {
+	t.Parallel()
+
+	sys := &hangingSystem{FakeSystem{RootValue: true, Commands: map[string]bool{"systemctl": true}}}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", CommandTimeout: 20 * time.Millisecond}, Dependencies{System: sys, Keys: &fakeKeys{}})
+
+	res, err := mgr.AddVPN(context.Background(), "home")
+	if err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if len(res.RuntimeActions) == 0 {
+		t.Fatal("expected runtime actions")
+	}
+	for _, a := range res.RuntimeActions {
+		if a.Status != "suggested" || !strings.Contains(a.Message, "timed out after 20ms") {
+			t.Fatalf("expected timeout message, got %#v", a)
+		}
+	}
+}
+
+func TestNoRuntimeWritesFilesWithoutRunning(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sys := &FakeSystem{RootValue: true, Commands: map[string]bool{"systemctl": true, "wg-quick": true}}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", NoRuntime: true}, Dependencies{System: sys, Keys: &fakeKeys{}})
+
+	res, err := mgr.AddVPN(ctx, "home")
+	if err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	readTestFile(t, res.ConfigPath)
+	if _, err := mgr.DeleteVPN(ctx, "home"); err != nil {
+		t.Fatalf("DeleteVPN returned error: %v", err)
+	}
+	if calls := sys.Calls(); len(calls) != 0 {
+		t.Fatalf("runtime commands were executed: %v", calls)
+	}
+	if len(res.RuntimeActions) == 0 {
+		t.Fatal("expected runtime actions")
+	}
+	for _, a := range res.RuntimeActions {
+		if a.Status != "suggested" || a.Message != "runtime disabled by config" {
+			t.Fatalf("unexpected runtime action: %#v", a)
+		}
+	}
+}
+
+func TestReapplyRuntime(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, vpn := range []string{"home", "work"} {
+		writeTestFile(t, filepath.Join(dir, "bp-"+vpn+".conf"), "[Interface]\nPrivateKey = "+fakeKey(vpn)+"\n")
+	}
+	// bp-home is down, as after a reboot; bp-work is up.
+	up := map[string]string{"wg show bp-work": "interface: bp-work\n"}
+	sys := &FakeSystem{RootValue: true, Commands: map[string]bool{"systemctl": true, "wg": true}, Outputs: up}
+	mgr := NewManager(Config{WireGuardDir: dir, PublicInterface: "eth0"}, Dependencies{System: sys, Keys: &fakeKeys{}})
+
+	rep, err := mgr.ReapplyRuntime(ctx)
+	if err != nil {
+		t.Fatalf("ReapplyRuntime returned error: %v", err)
+	}
+	if len(rep.Changes) != 0 {
+		t.Fatalf("unexpected file changes: %#v", rep.Changes)
+	}
+	want := []string{
+		"wg show bp-home",
+		"systemctl enable --now wg-quick@bp-home",
+		"wg show bp-work",
+		"systemctl restart wg-quick@bp-work",
+	}
+	if got := sys.Calls(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	for _, a := range rep.RuntimeActions {
+		if a.Status != "executed" {
+			t.Fatalf("unexpected runtime action: %#v", a)
+		}
+	}
+
+	sys = &FakeSystem{RootValue: true, Commands: map[string]bool{"wg": true, "wg-quick": true}, Outputs: up}
+	mgr = NewManager(Config{WireGuardDir: dir, PublicInterface: "eth0"}, Dependencies{System: sys, Keys: &fakeKeys{}})
+	if _, err := mgr.ReapplyRuntime(ctx); err != nil {
+		t.Fatalf("ReapplyRuntime returned error: %v", err)
+	}
+	want = []string{
+		"wg show bp-home",
+		"wg-quick up bp-home",
+		"wg show bp-work",
+		"wg-quick down bp-work",
+		"wg-quick up bp-work",
+	}
+	if got := sys.Calls(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("wg-quick calls = %v, want %v", got, want)
+	}
+}
+
+func TestPeerConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	res, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+
+	conf, err := mgr.PeerConfig("home", "laptop")
+	if err != nil {
+		t.Fatalf("PeerConfig returned error: %v", err)
+	}
+	if conf != readTestFile(t, res.PeerConfigPath) {
+		t.Fatalf("PeerConfig does not match stored file:\n%s", conf)
+	}
+
+	if _, err := mgr.PeerConfig("home", "phone"); !errors.Is(err, ErrPeerNotFound) {
+		t.Fatalf("expected ErrPeerNotFound, got %v", err)
+	}
+	if _, err := mgr.PeerConfig("home", "Bad!"); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("expected ErrInvalidName, got %v", err)
+	}
+}
+
+func TestEndpointHostsFailover(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cfg := Config{
+		WireGuardDir:    t.TempDir(),
+		PublicInterface: "eth0",
+		EndpointHosts:   []string{"203.0.113.7", "198.51.100.9", "203.0.113.7"},
+	}
+	mgr := NewManager(cfg, Dependencies{System: &FakeSystem{}, Keys: &fakeKeys{}})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	res, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if !strings.Contains(res.PeerConfig, "Endpoint = 203.0.113.7:55107\n") {
+		t.Fatalf("expected first endpoint host as primary:\n%s", res.PeerConfig)
+	}
+	if !strings.Contains(res.PeerConfig, "# bp-endpoints: 203.0.113.7:55107, 198.51.100.9:55107\n") {
+		t.Fatalf("expected bp-endpoints comment:\n%s", res.PeerConfig)
+	}
+
+	single := NewManager(Config{EndpointHost: "203.0.113.7"}, Dependencies{})
+	conf := single.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107)
+	if strings.Contains(conf, "bp-endpoints") {
+		t.Fatalf("unexpected bp-endpoints comment without alternates:\n%s", conf)
+	}
+}
+
+func TestEndpointPortOverride(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{EndpointPort: 51820, MinPort: 55107, MaxPort: 55107, EndpointHosts: []string{"203.0.113.7", "198.51.100.9"}})
+	vpn, err := mgr.AddVPN(ctx, "home")
+	if err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if got := firstSectionValue(readTestFile(t, vpn.ConfigPath), "Interface", "ListenPort"); got != "55107" {
+		t.Fatalf("server ListenPort = %q, want 55107", got)
+	}
+	res, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if !strings.Contains(res.PeerConfig, "Endpoint = 203.0.113.7:51820\n") || !strings.Contains(res.PeerConfig, "# bp-endpoints: 203.0.113.7:51820, 198.51.100.9:51820\n") {
+		t.Fatalf("client endpoint does not use EndpointPort:\n%s", res.PeerConfig)
+	}
+}
+
+func TestMTU(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{MTU: 1420}, Dependencies{})
+	vpn, err := mgr.renderVPNConfig(mgr.cfg, "home", "bp-home", "PRIV", 55107, 1, "eth0")
+	if err != nil {
+		t.Fatalf("renderVPNConfig returned error: %v", err)
+	}
+	if !strings.Contains(vpn, "Address = 69.0.1.1/24\nMTU = 1420\nPostUp") {
+		t.Fatalf("expected MTU line in server interface:\n%s", vpn)
+	}
+	client := mgr.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107)
+	if !strings.Contains(client, "Address = 69.0.1.2/32\nMTU = 1420\n\n[Peer]") {
+		t.Fatalf("expected MTU line in client interface:\n%s", client)
+	}
+
+	off := NewManager(Config{}, Dependencies{})
+	if client := off.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107); strings.Contains(client, "MTU") {
+		t.Fatalf("unexpected MTU line:\n%s", client)
+	}
+
+	if err := (Config{MTU: 1420}).normalized().validate(); err != nil {
+		t.Fatalf("mtu 1420 rejected: %v", err)
+	}
+	for _, mtu := range []int{575, 1501, -1} {
+		if err := (Config{MTU: mtu}).normalized().validate(); !errors.Is(err, ErrValidation) {
+			t.Fatalf("mtu %d: expected ErrValidation, got %v", mtu, err)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{MTU: 1420, ServerTable: "off", ClientTable: "1234"}, Dependencies{})
+	vpn, err := mgr.renderVPNConfig(mgr.cfg, "home", "bp-home", "PRIV", 55107, 1, "eth0")
+	if err != nil {
+		t.Fatalf("renderVPNConfig returned error: %v", err)
+	}
+	if !strings.Contains(vpn, "MTU = 1420\nTable = off\nPostUp") {
+		t.Fatalf("expected Table line in server interface:\n%s", vpn)
+	}
+	client := mgr.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107)
+	if !strings.Contains(client, "MTU = 1420\nTable = 1234\n\n[Peer]") {
+		t.Fatalf("expected Table line in client interface:\n%s", client)
+	}
+	if err := ValidateWGConfig("[Interface]\nPrivateKey = " + placeholderKey + "\nTable = off\n"); err != nil {
+		t.Fatalf("ValidateWGConfig rejected Table = off: %v", err)
+	}
+	if err := ValidateWGConfig("[Interface]\nPrivateKey = " + placeholderKey + "\nTable = main\n"); err == nil {
+		t.Fatalf("expected ValidateWGConfig to reject Table = main")
+	}
+
+	off := NewManager(Config{}, Dependencies{})
+	if client := off.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107); strings.Contains(client, "Table") {
+		t.Fatalf("unexpected Table line:\n%s", client)
+	}
+
+	for _, table := range []string{"off", "auto", "51820"} {
+		if err := (Config{ServerTable: table, ClientTable: table}).normalized().validate(); err != nil {
+			t.Fatalf("table %q rejected: %v", table, err)
+		}
+	}
+	for _, cfg := range []Config{{ServerTable: "main"}, {ClientTable: "-1"}, {ClientTable: "4294967296"}} {
+		if err := cfg.normalized().validate(); !errors.Is(err, ErrValidation) {
+			t.Fatalf("%+v: expected ErrValidation, got %v", cfg, err)
+		}
+	}
+}
+
+func TestFwMark(t *testing.T) {
+	t.Parallel()
+
+	mgr := NewManager(Config{FwMark: "51820", ClientFwMark: "0xCA6C"}, Dependencies{})
+	vpn, err := mgr.renderVPNConfig(mgr.cfg, "home", "bp-home", "PRIV", 55107, 1, "eth0")
+	if err != nil {
+		t.Fatalf("renderVPNConfig returned error: %v", err)
+	}
+	if !strings.Contains(vpn, "Address = 69.0.1.1/24\nFwMark = 0xca6c\nPostUp") {
+		t.Fatalf("expected FwMark line in server interface:\n%s", vpn)
+	}
+	client := mgr.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107)
+	if !strings.Contains(client, "Address = 69.0.1.2/32\nFwMark = 0xca6c\n\n[Peer]") {
+		t.Fatalf("expected FwMark line in client interface:\n%s", client)
+	}
+
+	off := NewManager(Config{FwMark: "1"}, Dependencies{})
+	if client := off.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107); strings.Contains(client, "FwMark") {
+		t.Fatalf("unexpected client FwMark line:\n%s", client)
+	}
+
+	for _, cfg := range []Config{{FwMark: "0x100000000"}, {FwMark: "mark"}, {ClientFwMark: "-1"}} {
+		if err := cfg.normalized().validate(); !errors.Is(err, ErrValidation) {
+			t.Fatalf("%+v: expected ErrValidation, got %v", cfg, err)
+		}
+	}
+	if err := ValidateWGConfig("[Interface]\nPrivateKey = " + placeholderKey + "\nFwMark = 0x1ffffffff\n"); err == nil {
+		t.Fatalf("expected ValidateWGConfig to reject a 33-bit FwMark")
+	}
+}
+
+func TestAvailableHostOctets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "phone", AddPeerOptions{HostOctet: 10}); err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+
+	free, err := mgr.AvailableHostOctets("home")
+	if err != nil {
+		t.Fatalf("AvailableHostOctets returned error: %v", err)
+	}
+	if len(free) != 251 || free[0] != 3 || free[len(free)-1] != 254 {
+		t.Fatalf("unexpected free hosts: %v", free)
+	}
+	for _, h := range free {
+		if h == 10 {
+			t.Fatalf("host 10 is reserved but reported free: %v", free)
+		}
+	}
+
+	if _, err := mgr.AvailableHostOctets("work"); !errors.Is(err, ErrVPNNotFound) {
+		t.Fatalf("expected ErrVPNNotFound, got %v", err)
+	}
+}
+
+func TestDeleteAllPeers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sys := &FakeSystem{RootValue: true, Commands: map[string]bool{"systemctl": true}}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointHost: "203.0.113.7"}, Dependencies{System: sys, Keys: &fakeKeys{}})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	for _, ref := range []PeerRef{{"home", "laptop"}, {"home", "phone"}, {"work", "desk"}} {
+		if _, err := mgr.AddPeer(ctx, ref.VPN, ref.Peer); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+	}
+	before := len(sys.Calls())
+
+	rep, err := mgr.DeleteAllPeers(ctx, "home")
+	if err != nil {
+		t.Fatalf("DeleteAllPeers returned error: %v", err)
+	}
+	var deleted []string
+	for _, c := range rep.Changes {
+		if c.Action == "deleted" {
+			deleted = append(deleted, filepath.Base(c.Path))
+		}
+	}
+	if strings.Join(deleted, ",") != "bp-home-laptop.conf,bp-home-phone.conf" {
+		t.Fatalf("unexpected deleted files: %v", deleted)
+	}
+	if calls := sys.Calls()[before:]; len(calls) != 1 {
+		t.Fatalf("expected a single restart, got %q", calls)
+	}
+
+	vpnConf := readTestFile(t, mgr.Config().VPNConfigPath("home"))
+	if strings.Contains(vpnConf, "[Peer]") {
+		t.Fatalf("expected no peer blocks left:\n%s", vpnConf)
+	}
+	peers, err := mgr.ListPeers()
+	if err != nil {
+		t.Fatalf("ListPeers returned error: %v", err)
+	}
+	if len(peers) != 1 || peers[0] != (PeerRef{"work", "desk"}) {
+		t.Fatalf("unexpected remaining peers: %v", peers)
+	}
+}
+
+func TestDeleteVPNCascade(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	for _, cascade := range []bool{false, true} {
+		mgr := newTestManager(t, Config{})
+		if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+		if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+		peerPath := mgr.Config().PeerConfigPath("home", "laptop")
+
+		rep, err := mgr.DeleteVPNWithOptions(ctx, "home", DeleteVPNOptions{Cascade: cascade})
+		if err != nil {
+			t.Fatalf("DeleteVPNWithOptions returned error: %v", err)
+		}
+		_, statErr := os.Stat(peerPath)
+		if cascade {
+			if !errors.Is(statErr, os.ErrNotExist) {
+				t.Fatalf("cascade: expected peer file to be deleted, stat err %v", statErr)
+			}
+			if len(rep.Warnings) != 0 {
+				t.Fatalf("cascade: unexpected warnings %q", rep.Warnings)
+			}
+			found := false
+			for _, c := range rep.Changes {
+				found = found || (c.Action == "deleted" && c.Path == peerPath)
+			}
+			if !found {
+				t.Fatalf("cascade: peer file deletion not reported: %#v", rep.Changes)
+			}
+		} else {
+			if statErr != nil {
+				t.Fatalf("expected peer file to be kept: %v", statErr)
+			}
+			if len(rep.Warnings) != 1 || !strings.Contains(rep.Warnings[0], "1 peer file(s)") {
+				t.Fatalf("expected leftover peer warning, got %q", rep.Warnings)
+			}
+		}
+	}
+}
+
+func TestAddPeerWithoutPresharedKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{DisablePresharedKey: true})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	res, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	vpnPath := mgr.Config().VPNConfigPath("home")
+	for _, path := range []string{vpnPath, res.PeerConfigPath} {
+		if conf := readTestFile(t, path); strings.Contains(conf, "PresharedKey") {
+			t.Fatalf("unexpected PresharedKey in %s:\n%s", path, conf)
+		}
+	}
+
+	regen, err := mgr.RegenerateKeys(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("RegenerateKeys returned error: %v", err)
+	}
+	if strings.Contains(regen.PeerConfig, "PresharedKey") || strings.Contains(readTestFile(t, vpnPath), "PresharedKey") {
+		t.Fatalf("RegenerateKeys added a PresharedKey:\n%s", regen.PeerConfig)
+	}
+
+	if _, err := mgr.DeletePeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("DeletePeer returned error: %v", err)
+	}
+	if vpn := readTestFile(t, vpnPath); strings.Contains(vpn, "[Peer]") {
+		t.Fatalf("expected peer block to be removed:\n%s", vpn)
+	}
+}
+
+func TestAddPeerWithoutServerHeldKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cfg := Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointHost: "203.0.113.7", RequireClientPublicKey: true, ValidateBeforeWrite: true}
+	mgr := NewManager(cfg, Dependencies{System: &FakeSystem{}, Keys: &fakeKeys{}})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation without a public key, got %v", err)
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "laptop", AddPeerOptions{PublicKey: "short"}); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for a malformed key, got %v", err)
+	}
+
+	clientPub := fakeKey("client-pub")
+	res, err := mgr.AddPeerWithOptions(ctx, "home", "laptop", AddPeerOptions{PublicKey: clientPub})
+	if err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	if strings.Contains(res.PeerConfig, "\nPrivateKey =") || !strings.Contains(res.PeerConfig, "\n# PrivateKey = <paste your own>\n") {
+		t.Fatalf("client config should carry a key placeholder:\n%s", res.PeerConfig)
+	}
+	if got := readTestFile(t, res.PeerConfigPath); got != res.PeerConfig {
+		t.Fatalf("peer file differs from result:\n%s", got)
+	}
+	vpnConf := readTestFile(t, mgr.Config().VPNConfigPath("home"))
+	if !strings.Contains(vpnConf, "PublicKey = "+clientPub+"\n") {
+		t.Fatalf("server block does not use the client key:\n%s", vpnConf)
+	}
+	if ref, err := mgr.FindPeerByPublicKey(ctx, clientPub); err != nil || ref.Peer != "laptop" {
+		t.Fatalf("FindPeerByPublicKey = %v, %v", ref, err)
+	}
+	if _, err := mgr.RegenerateKeys(ctx, "home", "laptop"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected RegenerateKeys to be refused, got %v", err)
+	}
+}
+
+func TestAddPeerWithSuppliedKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	keys := &fakeKeys{}
+	cfg := Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointHost: "203.0.113.7"}
+	mgr := NewManager(cfg, Dependencies{System: &FakeSystem{}, Keys: keys})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "laptop", AddPeerOptions{PublicKey: fakeKey("pub"), PresharedKey: "bad"}); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for a malformed preshared key, got %v", err)
+	}
+
+	generated := keys.n.Load()
+	pub, psk := fakeKey("laptop-pub"), fakeKey("laptop-psk")
+	res, err := mgr.AddPeerWithOptions(ctx, "home", "laptop", AddPeerOptions{PublicKey: pub, PresharedKey: psk})
+	if err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	if keys.n.Load() != generated {
+		t.Fatal("a private key was generated for a peer with its own key")
+	}
+	if !strings.Contains(res.PeerConfig, "# PrivateKey = <paste your own>\n") || !strings.Contains(res.PeerConfig, "PresharedKey = "+psk+"\n") {
+		t.Fatalf("unexpected client config:\n%s", res.PeerConfig)
+	}
+	vpnConf := readTestFile(t, mgr.Config().VPNConfigPath("home"))
+	if !strings.Contains(vpnConf, "PublicKey = "+pub+"\nPresharedKey = "+psk+"\n") {
+		t.Fatalf("server block does not use the supplied keys:\n%s", vpnConf)
+	}
+}
+
+func TestAddPeerRejectsMalformedServerKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	vpnPath := mgr.Config().VPNConfigPath("home")
+	conf := readTestFile(t, vpnPath)
+	priv := firstSectionValue(conf, "Interface", "PrivateKey")
+	writeTestFile(t, vpnPath, strings.Replace(conf, priv, priv[:20], 1))
+
+	_, err := mgr.AddPeer(ctx, "home", "laptop")
+	if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "has malformed PrivateKey") || !strings.Contains(err.Error(), vpnPath) {
+		t.Fatalf("expected malformed PrivateKey error naming %s, got %v", vpnPath, err)
+	}
+}
+
+func TestMigrateAddressing(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{IPv6Prefix: "fd00:6900", ClientDNS: []string{"69.0.1.1"}})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "phone", AddPeerOptions{HostOctet: 10}); err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+
+	if _, err := mgr.MigrateAddressing(ctx, "home", "69.0", 2); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected collision with work, got %v", err)
+	}
+
+	rep, err := mgr.MigrateAddressing(ctx, "home", "10.8", 5)
+	if err != nil {
+		t.Fatalf("MigrateAddressing returned error: %v", err)
+	}
+	if len(rep.Warnings) != 0 {
+		t.Fatalf("unexpected warnings: %q", rep.Warnings)
+	}
+
+	cfg := mgr.Config()
+	vpn := readTestFile(t, cfg.VPNConfigPath("home"))
+	for _, want := range []string{
+		"# bp-managed: vpn=home,prefix=10.8\n",
+		"Address = 10.8.5.1/24, fd00:6900:5::1/64\n",
+		"-s 10.8.5.0/24 -o eth0 -j MASQUERADE",
+		"AllowedIPs = 10.8.5.2/32, fd00:6900:5::2/128\n",
+		"AllowedIPs = 10.8.5.10/32, fd00:6900:5::10/128\n",
+	} {
+		if !strings.Contains(vpn, want) {
+			t.Fatalf("expected %q in vpn config:\n%s", want, vpn)
+		}
+	}
+	if strings.Contains(vpn, "69.0.1.") || strings.Contains(vpn, "fd00:6900:1:") {
+		t.Fatalf("old addresses left in vpn config:\n%s", vpn)
+	}
+	phone := readTestFile(t, cfg.PeerConfigPath("home", "phone"))
+	for _, want := range []string{
+		"Address = 10.8.5.10/32, fd00:6900:5::10/128\n",
+		"DNS = 10.8.5.1\n",
+		"AllowedIPs = 10.8.5.0/24, fd00:6900:5::/64\n",
+	} {
+		if !strings.Contains(phone, want) {
+			t.Fatalf("expected %q in client config:\n%s", want, phone)
+		}
+	}
+	if work := readTestFile(t, cfg.VPNConfigPath("work")); !strings.Contains(work, "Address = 69.0.2.1/24") {
+		t.Fatalf("other vpn was touched:\n%s", work)
+	}
+
+	res, err := mgr.AddPeer(ctx, "home", "tablet")
+	if err != nil {
+		t.Fatalf("AddPeer after migration returned error: %v", err)
+	}
+	if addr := firstSectionValue(res.PeerConfig, "Interface", "Address"); !strings.HasPrefix(addr, "10.8.5.3/32") {
+		t.Fatalf("unexpected address after migration: %s", addr)
+	}
+}
+
+func TestPerVPNSubnetPrefix(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	for _, tc := range []struct{ vpn, prefix, addr string }{
+		{"work", "10.20", "Address = 10.20.1.1/24\n"},
+		{"lab", "10", "Address = 10.1.0.1/16\n"},
+	} {
+		if _, err := mgr.AddVPNWithOptions(ctx, tc.vpn, AddVPNOptions{SubnetPrefix: tc.prefix}); err != nil {
+			t.Fatalf("AddVPNWithOptions(%s) returned error: %v", tc.vpn, err)
+		}
+		conf := readTestFile(t, mgr.Config().VPNConfigPath(tc.vpn))
+		if !strings.HasPrefix(conf, "# bp-managed: vpn="+tc.vpn+",prefix="+tc.prefix+"\n") || !strings.Contains(conf, tc.addr) {
+			t.Fatalf("unexpected %s config:\n%s", tc.vpn, conf)
+		}
+	}
+	if _, err := mgr.AddVPNWithOptions(ctx, "other", AddVPNOptions{SubnetPrefix: "10", SubnetOctet: 20}); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected overlap with work, got %v", err)
+	}
+	if _, err := mgr.AddVPNWithOptions(ctx, "other", AddVPNOptions{SubnetPrefix: "10.x"}); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected invalid prefix error, got %v", err)
+	}
+
+	res, err := mgr.AddPeer(ctx, "work", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if addr := firstSectionValue(res.PeerConfig, "Interface", "Address"); addr != "10.20.1.2/32" {
+		t.Fatalf("unexpected work peer address: %s", addr)
+	}
+
+	// Changing the global prefix only affects vpns created afterwards.
+	cfg := mgr.Config()
+	cfg.SubnetPrefix = "10.8"
+	moved := NewManager(cfg, Dependencies{System: &FakeSystem{}, Keys: &fakeKeys{}})
+	res, err = moved.AddPeer(ctx, "home", "phone")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if addr := firstSectionValue(res.PeerConfig, "Interface", "Address"); addr != "69.0.1.2/32" {
+		t.Fatalf("unexpected home peer address: %s", addr)
+	}
+	if _, err := moved.AddVPN(ctx, "next"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if conf := readTestFile(t, cfg.VPNConfigPath("next")); !strings.Contains(conf, "Address = 10.8.1.1/24\n") {
+		t.Fatalf("unexpected next config:\n%s", conf)
+	}
+
+	// Configs without a recorded prefix fall back to Config.SubnetPrefix.
+	homePath := cfg.VPNConfigPath("home")
+	writeTestFile(t, homePath, strings.Replace(readTestFile(t, homePath), ",prefix=69.0", "", 1))
+	res, err = mgr.AddPeer(ctx, "home", "tablet")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if addr := firstSectionValue(res.PeerConfig, "Interface", "Address"); addr != "69.0.1.3/32" {
+		t.Fatalf("unexpected legacy peer address: %s", addr)
+	}
+}
+
+func TestMigrateAddressingToSingleOctetPrefix(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.MigrateAddressing(ctx, "home", "10", 7); err != nil {
+		t.Fatalf("MigrateAddressing returned error: %v", err)
+	}
+	cfg := mgr.Config()
+	if vpn := readTestFile(t, cfg.VPNConfigPath("home")); !strings.Contains(vpn, "Address = 10.7.0.1/16\n") || !strings.Contains(vpn, "AllowedIPs = 10.7.0.2/32\n") {
+		t.Fatalf("unexpected vpn config:\n%s", vpn)
+	}
+	if peer := readTestFile(t, cfg.PeerConfigPath("home", "laptop")); !strings.Contains(peer, "AllowedIPs = 10.7.0.0/16\n") {
+		t.Fatalf("unexpected client config:\n%s", peer)
+	}
+	if _, err := mgr.MigrateAddressing(ctx, "home", "10.1.2", 7); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for bad prefix, got %v", err)
+	}
+}
+
+func TestSaveConfigWarningAndStrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	cfg := mgr.Config()
+	path := cfg.VPNConfigPath("home")
+	conf, _ := replaceLine(readTestFile(t, path), "ListenPort = 55107", "ListenPort = 55107\nSaveConfig = true")
+	writeTestFile(t, path, conf)
+
+	res, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if len(res.Report.Warnings) != 1 || !strings.Contains(res.Report.Warnings[0], "SaveConfig = true") {
+		t.Fatalf("expected a SaveConfig warning, got %#v", res.Report.Warnings)
+	}
+	rep, err := mgr.DeletePeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("DeletePeer returned error: %v", err)
+	}
+	if len(rep.Warnings) != 1 || !strings.Contains(rep.Warnings[0], "SaveConfig = true") {
+		t.Fatalf("expected a SaveConfig warning, got %#v", rep.Warnings)
+	}
+
+	cfg.StripSaveConfig = true
+	mgr = NewManager(cfg, Dependencies{System: &FakeSystem{}, Keys: &fakeKeys{}})
+	res, err = mgr.AddPeer(ctx, "home", "phone")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if got := readTestFile(t, path); strings.Contains(got, "SaveConfig") {
+		t.Fatalf("SaveConfig not stripped:\n%s", got)
+	}
+	if len(res.Report.Warnings) != 1 || !strings.Contains(res.Report.Warnings[0], "removed SaveConfig") {
+		t.Fatalf("expected the strip to be reported, got %#v", res.Report.Warnings)
+	}
+}
+
+func TestDeletePeerMatchesByPublicKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	for _, peer := range []string{"laptop", "phone"} {
+		if _, err := mgr.AddPeer(ctx, "home", peer); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+	}
+	cfg := mgr.Config()
+	path := cfg.VPNConfigPath("home")
+	conf := readTestFile(t, path)
+	conf, _ = replaceLine(conf, peerMetaLine("home", "laptop"), "")
+	conf, _ = replaceLine(conf, "AllowedIPs = 69.0.1.2/32", "AllowedIPs = 69.0.1.20/32")
+	writeTestFile(t, path, conf)
+
+	rep, err := mgr.DeletePeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("DeletePeer returned error: %v", err)
+	}
+	if len(rep.Warnings) != 0 {
+		t.Fatalf("unexpected warnings: %#v", rep.Warnings)
+	}
+	blocks := peerBlocks(readTestFile(t, path))
+	if len(blocks) != 1 || blocks[0].Meta["peer"] != "phone" {
+		t.Fatalf("expected only phone to remain, got %#v", blocks)
+	}
+}
+
+func TestAddPeerIfNotExists(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	first, err := mgr.AddPeerWithOptions(ctx, "home", "laptop", AddPeerOptions{IfNotExists: true})
+	if err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	if len(first.Changes) == 0 || first.Changes[0].Action != "updated" {
+		t.Fatalf("expected the peer to be created, got %#v", first.Changes)
+	}
+	vpnBefore := readTestFile(t, mgr.Config().VPNConfigPath("home"))
+
+	again, err := mgr.AddPeerWithOptions(ctx, "home", "laptop", AddPeerOptions{IfNotExists: true})
+	if err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	if again.PeerConfig != first.PeerConfig || again.PeerRef != first.PeerRef || again.PeerConfigPath != first.PeerConfigPath {
+		t.Fatalf("existing peer not returned: %#v", again)
+	}
+	if len(again.Changes) != 1 || again.Changes[0] != (Change{Action: "unchanged", Path: first.PeerConfigPath}) || len(again.RuntimeActions) != 0 {
+		t.Fatalf("unexpected report: %#v", again.Report)
+	}
+	if readTestFile(t, mgr.Config().VPNConfigPath("home")) != vpnBefore {
+		t.Fatalf("vpn config was modified")
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); !errors.Is(err, ErrPeerExists) {
+		t.Fatalf("expected ErrPeerExists without IfNotExists, got %v", err)
+	}
+}
+
+func TestAddVPNIfNotExists(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	created, err := mgr.AddVPNWithOptions(ctx, "home", AddVPNOptions{IfNotExists: true})
+	if err != nil {
+		t.Fatalf("AddVPNWithOptions returned error: %v", err)
+	}
+	if created.ListenPort != 55107 || created.Address != "69.0.1.1/24" || created.Interface != "bp-home" {
+		t.Fatalf("unexpected result: %#v", created)
+	}
+	if len(created.Changes) == 0 || created.Changes[len(created.Changes)-1].Action != "created" {
+		t.Fatalf("expected the vpn to be created, got %#v", created.Changes)
+	}
+	conf := readTestFile(t, created.ConfigPath)
+
+	again, err := mgr.AddVPNWithOptions(ctx, "home", AddVPNOptions{IfNotExists: true, Port: 55200, SubnetOctet: 9})
+	if err != nil {
+		t.Fatalf("AddVPNWithOptions returned error: %v", err)
+	}
+	if again.VPN != "home" || again.Interface != created.Interface || again.ListenPort != created.ListenPort || again.Address != created.Address || again.ConfigPath != created.ConfigPath {
+		t.Fatalf("existing vpn not returned: %#v", again)
+	}
+	if len(again.Changes) != 1 || again.Changes[0] != (Change{Action: "unchanged", Path: created.ConfigPath}) || len(again.RuntimeActions) != 0 {
+		t.Fatalf("unexpected report: %#v", again.Report)
+	}
+	if readTestFile(t, created.ConfigPath) != conf {
+		t.Fatalf("vpn config was modified")
+	}
+	if _, err := mgr.AddVPN(ctx, "home"); !errors.Is(err, ErrVPNExists) {
+		t.Fatalf("expected ErrVPNExists without IfNotExists, got %v", err)
+	}
+}
+
+func TestNestedPeerLayout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	flat := newTestManager(t, Config{WireGuardDir: dir})
+	if _, err := flat.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	for _, peer := range []string{"laptop", "phone"} {
+		if _, err := flat.AddPeer(ctx, "home", peer); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+	}
+	laptop := readTestFile(t, flat.Config().PeerConfigPath("home", "laptop"))
+
+	nested := newTestManager(t, Config{WireGuardDir: dir, PeerLayout: PeerLayoutNested})
+	if got, want := nested.Config().PeerConfigPath("home", "laptop"), filepath.Join(dir, "peers", "home", "laptop.conf"); got != want {
+		t.Fatalf("PeerConfigPath = %q, want %q", got, want)
+	}
+	if _, err := nested.MigratePeerLayout(ctx, PeerLayoutNested); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for same layout, got %v", err)
+	}
+	rep, err := nested.MigratePeerLayout(ctx, PeerLayoutFlat)
+	if err != nil {
+		t.Fatalf("MigratePeerLayout returned error: %v", err)
+	}
+	if len(rep.Changes) != 4 {
+		t.Fatalf("expected two writes and two removals, got %#v", rep.Changes)
+	}
+	if got := readTestFile(t, nested.Config().PeerConfigPath("home", "laptop")); got != laptop {
+		t.Fatalf("migrated config differs:\n%s", got)
+	}
+	if _, err := os.Stat(flat.Config().PeerConfigPath("home", "laptop")); !os.IsNotExist(err) {
+		t.Fatalf("flat peer file still exists: %v", err)
+	}
+	peers, err := nested.ListPeers()
+	if err != nil {
+		t.Fatalf("ListPeers returned error: %v", err)
+	}
+	if fmtRefs(peers) != "home:laptop home:phone" {
+		t.Fatalf("ListPeers = %s", fmtRefs(peers))
+	}
+
+	if _, err := nested.AddPeer(ctx, "home", "tablet"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "peers", "home", "tablet.conf")); err != nil {
+		t.Fatalf("nested peer file missing: %v", err)
+	}
+	if _, err := nested.DeletePeer(ctx, "home", "phone"); err != nil {
+		t.Fatalf("DeletePeer returned error: %v", err)
+	}
+	if vpn := readTestFile(t, nested.Config().VPNConfigPath("home")); strings.Contains(vpn, "peer=phone") {
+		t.Fatalf("deleted peer still in vpn config:\n%s", vpn)
+	}
+
+	if _, err := flat.MigratePeerLayout(ctx, PeerLayoutNested); err != nil {
+		t.Fatalf("MigratePeerLayout back returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "peers", "home")); !os.IsNotExist(err) {
+		t.Fatalf("emptied vpn directory still exists: %v", err)
+	}
+	if peers, _ := flat.ListPeers(); fmtRefs(peers) != "home:laptop home:tablet" {
+		t.Fatalf("ListPeers after migrating back = %s", fmtRefs(peers))
+	}
+
+	if _, err := flat.MigratePeerLayout(ctx, "tree"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for unknown layout, got %v", err)
+	}
+	if err := (Config{WireGuardDir: dir, PeerLayout: "tree"}).Validate(); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected invalid layout to fail validation, got %v", err)
+	}
+}
+
+func TestSymlinkedPeersDir(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	target := filepath.Join(t.TempDir(), "volume")
+	if err := os.Mkdir(target, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(dir, "peers")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	real, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mgr := newTestManager(t, Config{WireGuardDir: dir})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	res, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(real, filepath.Base(res.PeerConfigPath))); err != nil {
+		t.Fatalf("peer file not written through the symlink: %v", err)
+	}
+	if !strings.Contains(strings.Join(res.Warnings, "\n"), "files are written to "+real) {
+		t.Fatalf("expected a warning naming %s, got %#v", real, res.Warnings)
+	}
+	if peers, _ := mgr.ListPeers(); fmtRefs(peers) != "home:laptop" {
+		t.Fatalf("ListPeers = %s", fmtRefs(peers))
+	}
+
+	if err := os.RemoveAll(target); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "phone"); err == nil || !strings.Contains(err.Error(), "cannot be resolved") {
+		t.Fatalf("expected dangling symlink error, got %v", err)
+	}
+}
+
+func TestVerifyKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	for _, peer := range []string{"laptop", "phone"} {
+		if _, err := mgr.AddPeer(ctx, "home", peer); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+	}
+	if _, err := mgr.AddPeerWithOptions(ctx, "home", "tablet", AddPeerOptions{PublicKey: fakeKey("own")}); err != nil {
+		t.Fatalf("AddPeerWithOptions returned error: %v", err)
+	}
+	if got, err := mgr.VerifyKeys(ctx, "home"); err != nil || len(got) != 0 {
+		t.Fatalf("VerifyKeys on fresh configs = %#v, %v", got, err)
+	}
+
+	cfg := mgr.Config()
+	vpnPath := cfg.VPNConfigPath("home")
+	serverPub := fakePub(parseINI(readTestFile(t, vpnPath)).First("Interface", "PrivateKey"))
+	laptopPath := cfg.PeerConfigPath("home", "laptop")
+	laptop, _ := setSectionValue(readTestFile(t, laptopPath), "Peer", "PublicKey", fakeKey("old-server"))
+	writeTestFile(t, laptopPath, laptop)
+	vpn, _ := setManagedPeerValues(readTestFile(t, vpnPath), peerMetaLine("home", "phone"), "PublicKey", fakeKey("stale"))
+	writeTestFile(t, vpnPath, vpn)
+	phonePub := fakePub(parseINI(readTestFile(t, cfg.PeerConfigPath("home", "phone"))).First("Interface", "PrivateKey"))
+
+	got, err := mgr.VerifyKeys(ctx, "home")
+	if err != nil {
+		t.Fatalf("VerifyKeys returned error: %v", err)
+	}
+	want := []KeyMismatch{
+		{Peer: "laptop", Kind: KeyMismatchServer, Path: laptopPath, Expected: serverPub, Found: fakeKey("old-server")},
+		{Peer: "phone", Kind: KeyMismatchPeer, Path: vpnPath, Expected: phonePub, Found: fakeKey("stale")},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("VerifyKeys = %#v, want %#v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("mismatch %d = %#v, want %#v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := mgr.VerifyKeys(ctx, "work"); !errors.Is(err, ErrVPNNotFound) {
+		t.Fatalf("expected ErrVPNNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package bypasser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// PrometheusMetrics renders gauges in the Prometheus text exposition format,
+// e.g. for a node_exporter textfile collector: the number of vpns, peers per
+// vpn, used and free ListenPorts in [MinPort, MaxPort] and, when wg is
+// installed, the age of each peer's latest handshake on interfaces that are up.
+func (m *Manager) PrometheusMetrics(ctx context.Context) (string, error) {
+	summaries, err := m.ListAll()
+	if err != nil {
+		return "", err
+	}
+	var vpns []VPNSummary
+	used := make(map[int]bool)
+	for _, s := range summaries {
+		if s.Orphaned {
+			continue
+		}
+		vpns = append(vpns, s)
+		if s.ListenPort >= m.cfg.MinPort && s.ListenPort <= m.cfg.MaxPort {
+			used[s.ListenPort] = true
+		}
+	}
+
+	var b strings.Builder
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	gauge("bp_vpns", "Number of VPNs configured.")
+	fmt.Fprintf(&b, "bp_vpns %d\n", len(vpns))
+	gauge("bp_vpn_peers", "Number of peers of each VPN.")
+	for _, s := range vpns {
+		fmt.Fprintf(&b, "bp_vpn_peers{vpn=%s} %d\n", promLabel(s.Name), len(s.Peers))
+	}
+	gauge("bp_ports_used", "ListenPorts in the configured range used by a VPN.")
+	fmt.Fprintf(&b, "bp_ports_used %d\n", len(used))
+	gauge("bp_ports_free", "ListenPorts in the configured range still free.")
+	fmt.Fprintf(&b, "bp_ports_free %d\n", m.cfg.MaxPort-m.cfg.MinPort+1-len(used))
+
+	if !m.sys.HasCommand("wg") {
+		return b.String(), nil
+	}
+	gauge("bp_peer_last_handshake_age_seconds", "Seconds since the latest handshake of each peer that completed one.")
+	now := m.now()
+	for _, s := range vpns {
+		st, err := m.Status(ctx, s.Name)
+		if err != nil {
+			return "", err
+		}
+		for _, p := range st.Peers {
+			if p.LatestHandshake.IsZero() {
+				continue
+			}
+			peer := p.Peer
+			if peer == "" {
+				peer = p.PublicKey
+			}
+			age := now.Sub(p.LatestHandshake).Seconds()
+			fmt.Fprintf(&b, "bp_peer_last_handshake_age_seconds{vpn=%s,peer=%s} %g\n", promLabel(s.Name), promLabel(peer), age)
+		}
+	}
+	return b.String(), nil
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(v string) string {
+	return `"` + promEscaper.Replace(v) + `"`
+}
@@ -0,0 +1,85 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Unix(1700000090, 0)
+	sys := &FakeSystem{
+		Commands: map[string]bool{},
+		Outputs:  map[string]string{},
+		Errors:   map[string]error{"wg show bp-work dump": errors.New("no such device")},
+	}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointHost: "203.0.113.7"},
+		Dependencies{System: sys, Keys: &fakeKeys{}, Clock: func() time.Time { return now }})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	laptop, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "phone"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+
+	want := `# HELP bp_vpns Number of VPNs configured.
+# TYPE bp_vpns gauge
+bp_vpns 2
+# HELP bp_vpn_peers Number of peers of each VPN.
+# TYPE bp_vpn_peers gauge
+bp_vpn_peers{vpn="home"} 2
+bp_vpn_peers{vpn="work"} 0
+# HELP bp_ports_used ListenPorts in the configured range used by a VPN.
+# TYPE bp_ports_used gauge
+bp_ports_used 2
+# HELP bp_ports_free ListenPorts in the configured range still free.
+# TYPE bp_ports_free gauge
+bp_ports_free 99
+`
+	got, err := mgr.PrometheusMetrics(ctx)
+	if err != nil {
+		t.Fatalf("PrometheusMetrics returned error: %v", err)
+	}
+	if got != want {
+		t.Fatalf("metrics without wg:\n%s\nwant:\n%s", got, want)
+	}
+
+	sys.Commands["wg"] = true
+	laptopPub := fakePub(firstSectionValue(laptop.PeerConfig, "Interface", "PrivateKey"))
+	sys.Outputs["wg show bp-home dump"] = strings.Join([]string{
+		"SRVPRIV\tpub-SRV\t55107\toff",
+		laptopPub + "\tPSK\t198.51.100.4:40000\t69.0.1.2/32\t1700000000\t1024\t2048\t25",
+		"pub-STRANGER\t(none)\t(none)\t69.0.1.9/32\t1700000030\t0\t0\toff",
+	}, "\n")
+	got, err = mgr.PrometheusMetrics(ctx)
+	if err != nil {
+		t.Fatalf("PrometheusMetrics returned error: %v", err)
+	}
+	want += `# HELP bp_peer_last_handshake_age_seconds Seconds since the latest handshake of each peer that completed one.
+# TYPE bp_peer_last_handshake_age_seconds gauge
+bp_peer_last_handshake_age_seconds{vpn="home",peer="laptop"} 90
+bp_peer_last_handshake_age_seconds{vpn="home",peer="pub-STRANGER"} 60
+`
+	if got != want {
+		t.Fatalf("metrics with wg:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPromLabelEscapes(t *testing.T) {
+	t.Parallel()
+
+	if got := promLabel("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Fatalf("promLabel = %s", got)
+	}
+}
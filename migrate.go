@@ -0,0 +1,260 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MigrateAddressing renumbers vpn onto newPrefix.newOctet, keeping every
+// peer's host number. The server Address, PostUp/PostDown rules, peer
+// AllowedIPs and each client's Address, AllowedIPs and DNS are rewritten.
+func (m *Manager) MigrateAddressing(ctx context.Context, vpn, newPrefix string, newOctet int) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("vpn", vpn); err != nil {
+		return rep, err
+	}
+	if err := validateSubnetPrefix(newPrefix); err != nil {
+		return rep, &kindError{kind: ErrValidation, err: err}
+	}
+	if newOctet < 1 || newOctet > 254 {
+		return rep, errorf(ErrValidation, "subnet octet %d is outside the allowed range 1-254", newOctet)
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	vpnPath := m.cfg.VPNConfigPath(vpn)
+	vpnBytes, err := os.ReadFile(vpnPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, vpnNotFound(vpn, vpnPath)
+		}
+		return rep, err
+	}
+	vpnDoc := parseINI(string(vpnBytes))
+	oldCfg := m.vpnConfig(vpnDoc)
+	oldOctet, _, err := parseBPAddress(oldCfg.SubnetPrefix, vpnDoc.First("Interface", "Address"))
+	if err != nil {
+		return rep, fmt.Errorf("vpn config %s: %w", vpnPath, err)
+	}
+
+	newCfg := oldCfg.withPrefix(newPrefix)
+	if err := newCfg.validate(); err != nil {
+		return rep, err
+	}
+	if newPrefix == oldCfg.SubnetPrefix && newOctet == oldOctet {
+		return rep, errorf(ErrValidation, "vpn %q already uses %s", vpn, oldCfg.meshCIDR4(oldOctet))
+	}
+	if err := m.checkSubnetFree(vpn, newCfg.meshCIDR4(newOctet)); err != nil {
+		return rep, err
+	}
+
+	hosts := []int{0, 1}
+	for h := range oldCfg.usedPeerHostOctets(vpnDoc, oldOctet) {
+		if h > newCfg.maxPeerHost() {
+			return rep, errorf(ErrValidation, "peer host %s does not fit in %s", oldCfg.ipv4Addr(oldOctet, h), newCfg.meshCIDR4(newOctet))
+		}
+		hosts = append(hosts, h)
+	}
+	addrs := make(map[string]string)
+	for _, h := range hosts {
+		addrs[oldCfg.ipv4Addr(oldOctet, h)] = newCfg.ipv4Addr(newOctet, h)
+		if oldCfg.IPv6Prefix != "" {
+			addrs[oldCfg.ipv6Addr(oldOctet, h)] = newCfg.ipv6Addr(newOctet, h)
+		}
+	}
+	cidrs := []string{oldCfg.meshCIDR4(oldOctet), newCfg.meshCIDR4(newOctet)}
+	if oldCfg.IPv6Prefix != "" {
+		oldNet, _, _ := strings.Cut(oldCfg.meshCIDR6(oldOctet), "/")
+		newNet, _, _ := strings.Cut(newCfg.meshCIDR6(newOctet), "/")
+		addrs[oldNet] = newNet
+		cidrs = append(cidrs, oldCfg.meshCIDR6(oldOctet), newCfg.meshCIDR6(newOctet))
+	}
+	rw := addressRewriter{
+		addrs:   addrs,
+		oldMask: oldCfg.InterfaceMask,
+		newMask: newCfg.InterfaceMask,
+		cidrs:   strings.NewReplacer(cidrs...),
+	}
+
+	peers, err := m.ListPeers()
+	if err != nil {
+		return rep, err
+	}
+	paths := []string{vpnPath}
+	for _, p := range peers {
+		if p.VPN == vpn {
+			paths = append(paths, m.cfg.PeerConfigPath(p.VPN, p.Peer))
+		}
+	}
+	if err := m.backup(&rep, "migrate-"+vpn, paths...); err != nil {
+		return rep, err
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return rep, err
+		}
+		content := rw.rewrite(string(b))
+		if path == vpnPath {
+			content = setVPNPrefix(content, vpn, newPrefix)
+		}
+		if err := m.writeFile(path, []byte(content), &rep); err != nil {
+			return rep, err
+		}
+	}
+
+	m.maybeVPNRestart(ctx, &rep, vpn)
+	m.updateInventory(&rep)
+	return rep, nil
+}
+
+// MigratePeerLayout moves the peer files stored in the from layout
+// (PeerLayoutFlat or PeerLayoutNested) to where Config.PeerLayout expects
+// them, e.g. run with BP_PEER_LAYOUT=nested and from = PeerLayoutFlat. No
+// file is moved if any destination already exists.
+func (m *Manager) MigratePeerLayout(ctx context.Context, from string) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	if from != PeerLayoutFlat && from != PeerLayoutNested {
+		return rep, errorf(ErrValidation, "invalid peer layout %q: use %s or %s", from, PeerLayoutFlat, PeerLayoutNested)
+	}
+	if from == m.cfg.PeerLayout {
+		return rep, errorf(ErrValidation, "peer files already use the %s layout", from)
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	src := m.cfg
+	src.PeerLayout = from
+	peers, err := src.listPeers()
+	if err != nil {
+		return rep, err
+	}
+	for _, p := range peers {
+		dst := m.cfg.PeerConfigPath(p.VPN, p.Peer)
+		if _, err := os.Stat(dst); err == nil {
+			return rep, errorf(ErrAlreadyExists, "cannot move %s: %s already exists", p.String(), dst)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return rep, err
+		}
+	}
+	for _, p := range peers {
+		srcPath := src.PeerConfigPath(p.VPN, p.Peer)
+		b, err := os.ReadFile(srcPath)
+		if err != nil {
+			return rep, err
+		}
+		if err := m.writeFile(m.cfg.PeerConfigPath(p.VPN, p.Peer), b, &rep); err != nil {
+			return rep, err
+		}
+		if err := m.removeFile(srcPath, &rep); err != nil {
+			return rep, err
+		}
+		if from == PeerLayoutNested && !m.cfg.DryRun {
+			// Drops peers/<vpn> once its last file has moved.
+			_ = os.Remove(filepath.Dir(srcPath))
+		}
+	}
+	return rep, nil
+}
+
+// checkSubnetFree fails when cidr overlaps the IPv4 subnet of any vpn but skip.
+func (m *Manager) checkSubnetFree(skip, cidr string) error {
+	_, want, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	entries, err := m.SubnetMap()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.VPN == skip || e.Network.IP.To4() == nil {
+			continue
+		}
+		if subnetsOverlap(e.Network, want) {
+			return errorf(ErrAlreadyExists, "subnet %s collides with vpn %q (%s)", cidr, e.VPN, e.CIDR)
+		}
+	}
+	return nil
+}
+
+// setVPNPrefix records prefix in the vpn header of content, adding the
+// header to configs that predate it.
+func setVPNPrefix(content, vpn, prefix string) string {
+	lines := splitLines(content)
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if isSectionHeader(line) && line != "[Interface]" {
+			break
+		}
+		if meta := parseManagedMeta(line); meta != nil && meta["peer"] == "" {
+			lines[i] = vpnMetaLine(vpn, prefix)
+			return strings.Join(lines, "\n")
+		}
+	}
+	return vpnMetaLine(vpn, prefix) + "\n" + content
+}
+
+type addressRewriter struct {
+	addrs            map[string]string
+	oldMask, newMask int
+	cidrs            *strings.Replacer
+}
+
+func (r addressRewriter) rewrite(content string) string {
+	lines := splitLines(content)
+	for i, raw := range lines {
+		key, val, ok := splitKV(strings.TrimSpace(raw))
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "address", "allowedips", "dns":
+			lines[i] = key + " = " + r.rewriteList(val)
+		case "postup", "postdown":
+			lines[i] = key + " = " + r.cidrs.Replace(val)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r addressRewriter) rewriteList(list string) string {
+	entries := strings.Split(list, ",")
+	for i, entry := range entries {
+		ip, mask, hasMask := strings.Cut(strings.TrimSpace(entry), "/")
+		newIP, ok := r.addrs[ip]
+		if !ok {
+			entries[i] = strings.TrimSpace(entry)
+			continue
+		}
+		if !hasMask {
+			entries[i] = newIP
+			continue
+		}
+		if n, err := strconv.Atoi(mask); err == nil && n == r.oldMask && !strings.Contains(ip, ":") {
+			mask = strconv.Itoa(r.newMask)
+		}
+		entries[i] = newIP + "/" + mask
+	}
+	return strings.Join(entries, ", ")
+}
@@ -0,0 +1,179 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MovePeer reassigns a peer to another VPN. The peer keeps its private key, so
+// only the client config has to be redistributed; it gets a new address in the
+// destination subnet and a freshly generated preshared key; its expiry and
+// labels are carried over.
+func (m *Manager) MovePeer(ctx context.Context, fromVPN, toVPN, peerName string) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("vpn", fromVPN); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("vpn", toVPN); err != nil {
+		return rep, err
+	}
+	if err := ValidateName("peer", peerName); err != nil {
+		return rep, err
+	}
+	if fromVPN == toVPN {
+		return rep, errorf(ErrValidation, "peer %q is already in vpn %q", peerName, toVPN)
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	from := PeerRef{VPN: fromVPN, Peer: peerName}
+	to := PeerRef{VPN: toVPN, Peer: peerName}
+	oldPeerPath := m.cfg.PeerConfigPath(fromVPN, peerName)
+	oldPeerBytes, err := os.ReadFile(oldPeerPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, peerNotFound(from, oldPeerPath)
+		}
+		return rep, err
+	}
+	newPeerPath := m.cfg.PeerConfigPath(toVPN, peerName)
+	if _, err := os.Stat(newPeerPath); err == nil {
+		return rep, peerExists(to, newPeerPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return rep, err
+	}
+
+	oldPeer := parseINI(string(oldPeerBytes))
+	peerPriv := oldPeer.First("Interface", "PrivateKey")
+	if peerPriv == "" {
+		return rep, fmt.Errorf("peer file %s is missing Interface.PrivateKey", oldPeerPath)
+	}
+	if !isValidWGKey(peerPriv) {
+		return rep, errorf(ErrValidation, "peer file %s has malformed PrivateKey: expected 32 base64-encoded bytes", oldPeerPath)
+	}
+	peerPub, err := m.keys.DerivePublicKey(ctx, peerPriv)
+	if err != nil {
+		return rep, err
+	}
+	oldAddr := normalizeCIDR(oldPeer.First("Interface", "Address"), m.cfg.PeerMask)
+	// The peer's expiry and labels move with it.
+	var extra []string
+	for _, line := range splitLines(string(oldPeerBytes)) {
+		if strings.HasPrefix(line, expiresPrefix) || strings.HasPrefix(line, labelsPrefix) {
+			extra = append(extra, line)
+		}
+	}
+
+	fromPath := m.cfg.VPNConfigPath(fromVPN)
+	fromBytes, err := os.ReadFile(fromPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, vpnNotFound(fromVPN, fromPath)
+		}
+		return rep, err
+	}
+	fromDoc := parseINI(string(fromBytes))
+	fromCfg := m.vpnConfig(fromDoc)
+	fromOctet, _, err := parseBPAddress(fromCfg.SubnetPrefix, fromDoc.First("Interface", "Address"))
+	if err != nil {
+		return rep, fmt.Errorf("vpn config %s: %w", fromPath, err)
+	}
+
+	toPath := m.cfg.VPNConfigPath(toVPN)
+	toBytes, err := os.ReadFile(toPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rep, vpnNotFound(toVPN, toPath)
+		}
+		return rep, err
+	}
+	toDoc := parseINI(string(toBytes))
+	serverPriv := toDoc.First("Interface", "PrivateKey")
+	if serverPriv == "" {
+		return rep, fmt.Errorf("vpn config %s is missing Interface.PrivateKey", toPath)
+	}
+	if !isValidWGKey(serverPriv) {
+		return rep, errorf(ErrValidation, "vpn config %s has malformed PrivateKey: expected 32 base64-encoded bytes", toPath)
+	}
+	serverPub, err := m.keys.DerivePublicKey(ctx, serverPriv)
+	if err != nil {
+		return rep, err
+	}
+	portStr := toDoc.First("Interface", "ListenPort")
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return rep, fmt.Errorf("invalid ListenPort %q in %s", portStr, toPath)
+	}
+	toCfg := m.vpnConfig(toDoc)
+	toOctet, _, err := parseBPAddress(toCfg.SubnetPrefix, toDoc.First("Interface", "Address"))
+	if err != nil {
+		return rep, fmt.Errorf("vpn config %s: %w", toPath, err)
+	}
+	host, err := toCfg.nextPeerHostOctet(toDoc, toOctet)
+	if err != nil {
+		return rep, err
+	}
+	psk, err := m.presharedKey(ctx)
+	if err != nil {
+		return rep, err
+	}
+
+	endpointHost := m.cfg.EndpointHost
+	if h, _, err := net.SplitHostPort(oldPeer.First("Peer", "Endpoint")); err == nil && h != "" {
+		endpointHost = h
+	}
+	if endpointHost == "" {
+		endpointHost = m.detectEndpointHost(ctx, &rep)
+	}
+
+	// A custom route list (e.g. a full tunnel) follows the peer; the default
+	// mesh CIDR is swapped for the destination's.
+	clientAllowed := oldPeer.First("Peer", "AllowedIPs")
+	if clientAllowed == "" || clientAllowed == fromCfg.meshCIDRs(fromOctet) {
+		clientAllowed = toCfg.meshCIDRs(toOctet)
+		if m.cfg.ClientAllowedIPs != "" {
+			clientAllowed = m.cfg.ClientAllowedIPs
+		}
+	}
+
+	peerAddr := toCfg.peerAddrs(toOctet, host)
+	meta := peerMetaLine(toVPN, peerName)
+	toDoc.Append(parseINI(insertAfterLine(m.renderServerPeerBlock(toVPN, peerName, peerPub, psk, peerAddr), meta, extra...)))
+	updatedTo := toDoc.String()
+	if err := m.writeFile(toPath, []byte(updatedTo), &rep); err != nil {
+		return rep, err
+	}
+	m.warnConfigSize(&rep, toPath, updatedTo)
+	clientConf := insertAfterLine(m.renderClientPeerConfig(toVPN, peerName, peerPriv, peerAddr, serverPub, psk, clientAllowed, endpointHost, port), meta, extra...)
+	if err := m.writeFile(newPeerPath, []byte(clientConf), &rep); err != nil {
+		return rep, err
+	}
+
+	if updatedFrom, removed := removePeerBlock(string(fromBytes), from, oldAddr, peerPub); removed {
+		if err := m.writeFile(fromPath, []byte(updatedFrom), &rep); err != nil {
+			return rep, err
+		}
+	} else {
+		rep.warnf("peer block for %s was not found in %s", from.String(), fromPath)
+	}
+	if err := m.removeFile(oldPeerPath, &rep); err != nil {
+		return rep, err
+	}
+
+	m.maybeVPNRestart(ctx, &rep, fromVPN)
+	m.maybeVPNRestart(ctx, &rep, toVPN)
+	m.updateInventory(&rep)
+	return rep, nil
+}
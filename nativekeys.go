@@ -0,0 +1,45 @@
+package bypasser
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// NativeKeyGenerator generates WireGuard keys in-process, producing the same
+// base64 encoding as wg genkey, wg pubkey and wg genpsk.
+type NativeKeyGenerator struct{}
+
+func (NativeKeyGenerator) GeneratePrivateKey(context.Context) (string, error) {
+	var k [32]byte
+	if _, err := rand.Read(k[:]); err != nil {
+		return "", err
+	}
+	// Clamp as wg genkey does (RFC 7748).
+	k[0] &= 248
+	k[31] = (k[31] & 127) | 64
+	return base64.StdEncoding.EncodeToString(k[:]), nil
+}
+
+func (NativeKeyGenerator) DerivePublicKey(_ context.Context, privateKey string) (string, error) {
+	priv, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil || len(priv) != 32 {
+		return "", fmt.Errorf("invalid private key: expected 32 base64-encoded bytes")
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), nil
+}
+
+func (NativeKeyGenerator) GeneratePresharedKey(context.Context) (string, error) {
+	var k [32]byte
+	if _, err := rand.Read(k[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(k[:]), nil
+}
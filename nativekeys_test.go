@@ -0,0 +1,120 @@
+package bypasser
+
+import (
+	"context"
+	"encoding/base64"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestNativeKeyGeneratorVector(t *testing.T) {
+	t.Parallel()
+
+	// RFC 7748 section 6.1, Alice.
+	priv := "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+	want := "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo="
+	got, err := NativeKeyGenerator{}.DerivePublicKey(context.Background(), priv)
+	if err != nil {
+		t.Fatalf("DerivePublicKey returned error: %v", err)
+	}
+	if got != want {
+		t.Fatalf("DerivePublicKey = %q, want %q", got, want)
+	}
+}
+
+func TestNativeKeyGeneratorFormat(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	g := NativeKeyGenerator{}
+	priv, err := g.GeneratePrivateKey(ctx)
+	if err != nil {
+		t.Fatalf("GeneratePrivateKey returned error: %v", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(priv)
+	if err != nil || len(raw) != 32 {
+		t.Fatalf("private key %q is not 32 base64 bytes", priv)
+	}
+	if raw[0]&7 != 0 || raw[31]&128 != 0 || raw[31]&64 == 0 {
+		t.Fatalf("private key %q is not clamped", priv)
+	}
+	psk, err := g.GeneratePresharedKey(ctx)
+	if err != nil {
+		t.Fatalf("GeneratePresharedKey returned error: %v", err)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(psk); err != nil || len(raw) != 32 {
+		t.Fatalf("preshared key %q is not 32 base64 bytes", psk)
+	}
+	if _, err := g.DerivePublicKey(ctx, "not-a-key"); err == nil {
+		t.Fatal("expected error for invalid private key")
+	}
+}
+
+func TestNativeKeyGeneratorMatchesWG(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("wg"); err != nil {
+		t.Skip("wg not installed")
+	}
+	ctx := context.Background()
+	cli := WGCLIKeyGenerator{}
+	native := NativeKeyGenerator{}
+
+	fromCLI, err := cli.GeneratePrivateKey(ctx)
+	if err != nil {
+		t.Fatalf("wg genkey: %v", err)
+	}
+	fromNative, err := native.GeneratePrivateKey(ctx)
+	if err != nil {
+		t.Fatalf("GeneratePrivateKey returned error: %v", err)
+	}
+	for _, priv := range []string{fromCLI, fromNative} {
+		a, err := cli.DerivePublicKey(ctx, priv)
+		if err != nil {
+			t.Fatalf("wg pubkey: %v", err)
+		}
+		b, err := native.DerivePublicKey(ctx, priv)
+		if err != nil {
+			t.Fatalf("DerivePublicKey returned error: %v", err)
+		}
+		if a != b {
+			t.Fatalf("public keys differ for %s: wg %q, native %q", priv, a, b)
+		}
+	}
+}
+
+func TestNewManagerFallsBackToNativeKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sys := &FakeSystem{}
+	cfg := Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointHost: "203.0.113.7"}
+	mgr, err := NewManagerWithError(cfg, Dependencies{System: sys})
+	if err != nil {
+		t.Fatalf("NewManagerWithError returned error: %v", err)
+	}
+	if _, ok := mgr.keys.(NativeKeyGenerator); !ok {
+		t.Fatalf("keys = %T, want NativeKeyGenerator", mgr.keys)
+	}
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	for _, call := range sys.Calls() {
+		if strings.HasPrefix(call, "wg ") {
+			t.Fatalf("unexpected wg call %q", call)
+		}
+	}
+
+	keys := &fakeKeys{}
+	mgr, err = NewManagerWithError(cfg, Dependencies{System: sys, Keys: keys})
+	if err != nil {
+		t.Fatalf("NewManagerWithError returned error: %v", err)
+	}
+	if mgr.keys != keys {
+		t.Fatalf("explicit Dependencies.Keys was not used")
+	}
+}
@@ -0,0 +1,62 @@
+package bypasser
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const obfsPrefix = "# bp-obfs:"
+
+// obfuscationEndpoint resolves Config.ObfuscationEndpoint for a vpn on port:
+// "127.0.0.1:51820" is used as is, a bare "127.0.0.1" gets port.
+func obfuscationEndpoint(value string, port int) (string, error) {
+	host, portStr, err := net.SplitHostPort(value)
+	if err != nil {
+		host = value
+		portStr = strconv.Itoa(port)
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	n, err := strconv.Atoi(portStr)
+	if net.ParseIP(host) == nil || err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid obfuscation endpoint %q: expected a local ip address with an optional port", value)
+	}
+	return formatEndpoint(host, n), nil
+}
+
+// obfsLine records the real server endpoint behind an obfuscated client.
+func (c Config) obfsLine(server string) string {
+	line := obfsPrefix + " "
+	if c.ObfuscationTool != "" {
+		line += "tool=" + c.ObfuscationTool + ","
+	}
+	return line + "server=" + server
+}
+
+// obfsServer returns the server of a "# bp-obfs:" comment in content.
+func obfsServer(content string) string {
+	for _, raw := range splitLines(content) {
+		if meta := parseCommentMap(raw, obfsPrefix); meta != nil {
+			return meta["server"]
+		}
+	}
+	return ""
+}
+
+// setObfsServer replaces the server of the "# bp-obfs:" comment in content.
+func setObfsServer(content, server string) string {
+	lines := splitLines(content)
+	for i, raw := range lines {
+		meta := parseCommentMap(raw, obfsPrefix)
+		if meta == nil {
+			continue
+		}
+		line := obfsPrefix + " "
+		if meta["tool"] != "" {
+			line += "tool=" + meta["tool"] + ","
+		}
+		lines[i] = line + "server=" + server
+	}
+	return strings.Join(lines, "\n")
+}
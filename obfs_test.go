@@ -0,0 +1,62 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestObfuscationEndpoint(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{
+		ObfuscationEndpoint: "127.0.0.1",
+		ObfuscationTool:     "udp2raw",
+		ObfuscationPostUp:   "udp2raw -s -l 0.0.0.0:4096 -r 127.0.0.1:{{.Port}} &",
+		ObfuscationPostDown: "pkill -f 'udp2raw -s -l 0.0.0.0:4096';",
+	})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	res, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if !strings.Contains(res.PeerConfig, "Endpoint = 127.0.0.1:55107\n# bp-obfs: tool=udp2raw,server=203.0.113.7:55107\n") {
+		t.Fatalf("client not pointed at the local tunnel:\n%s", res.PeerConfig)
+	}
+	vpn := parseINI(readTestFile(t, mgr.Config().VPNConfigPath("home")))
+	if up := vpn.First("Interface", "PostUp"); !strings.HasSuffix(up, "; udp2raw -s -l 0.0.0.0:4096 -r 127.0.0.1:55107 &") {
+		t.Fatalf("PostUp hook missing: %q", up)
+	}
+	if down := vpn.First("Interface", "PostDown"); !strings.HasSuffix(down, " pkill -f 'udp2raw -s -l 0.0.0.0:4096';") {
+		t.Fatalf("PostDown hook missing: %q", down)
+	}
+
+	if _, err := mgr.SetEndpoint(ctx, "home", "198.51.100.9"); err != nil {
+		t.Fatalf("SetEndpoint returned error: %v", err)
+	}
+	conf := readTestFile(t, mgr.Config().PeerConfigPath("home", "laptop"))
+	if !strings.Contains(conf, "Endpoint = 127.0.0.1:55107\n# bp-obfs: tool=udp2raw,server=198.51.100.9:55107\n") {
+		t.Fatalf("SetEndpoint did not update the tunnel server:\n%s", conf)
+	}
+
+	pinned := newTestManager(t, Config{ObfuscationEndpoint: "[::1]:51820"})
+	if conf := pinned.renderClientPeerConfig("home", "laptop", "PRIV", "69.0.1.2/32", "SERVER", "PSK", "69.0.1.0/24", "203.0.113.7", 55107); !strings.Contains(conf, "Endpoint = [::1]:51820\n# bp-obfs: server=203.0.113.7:55107\n") {
+		t.Fatalf("pinned obfuscation endpoint not used:\n%s", conf)
+	}
+
+	for _, cfg := range []Config{
+		{ObfuscationEndpoint: "localhost:51820"},
+		{ObfuscationEndpoint: "127.0.0.1:70000"},
+		{ObfuscationTool: "udp2raw --fast"},
+		{ObfuscationPostUp: "{{.Port"},
+	} {
+		cfg.WireGuardDir = t.TempDir()
+		if err := cfg.Validate(); !errors.Is(err, ErrValidation) {
+			t.Fatalf("Validate(%+v): expected ErrValidation, got %v", cfg, err)
+		}
+	}
+}
@@ -2,53 +2,14 @@ package bypasser
 
 import (
 	"fmt"
+	"net"
+	"slices"
 	"strconv"
 	"strings"
 )
 
 func firstSectionValue(content, sectionName, key string) string {
-	section := ""
-	for _, raw := range strings.Split(content, "\n") {
-		line := strings.TrimSpace(raw)
-		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
-			continue
-		}
-		if isSectionHeader(line) {
-			section = strings.TrimSpace(strings.Trim(line, "[]"))
-			continue
-		}
-		if section != sectionName {
-			continue
-		}
-		k, v, ok := splitKV(line)
-		if ok && strings.EqualFold(k, key) {
-			return v
-		}
-	}
-	return ""
-}
-
-func allSectionValues(content, sectionName, key string) []string {
-	var out []string
-	section := ""
-	for _, raw := range strings.Split(content, "\n") {
-		line := strings.TrimSpace(raw)
-		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
-			continue
-		}
-		if isSectionHeader(line) {
-			section = strings.TrimSpace(strings.Trim(line, "[]"))
-			continue
-		}
-		if section != sectionName {
-			continue
-		}
-		k, v, ok := splitKV(line)
-		if ok && strings.EqualFold(k, key) {
-			out = append(out, v)
-		}
-	}
-	return out
+	return parseINI(content).First(sectionName, key)
 }
 
 func splitKV(line string) (key, val string, ok bool) {
@@ -68,8 +29,13 @@ func isSectionHeader(line string) bool {
 	return strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]")
 }
 
-func parseBPAddress(prefix, addr string) (vpnOctet, hostOctet int, err error) {
-	base := addr
+// parseBPAddress splits addr into its vpn octet and host number; with a
+// single-octet prefix the host spans the last two octets (x.y -> x*256+y).
+func parseBPAddress(prefix, addr string) (vpnOctet, host int, err error) {
+	base := strings.TrimSpace(addr)
+	if i := strings.Index(base, ","); i >= 0 {
+		base = strings.TrimSpace(base[:i])
+	}
 	if i := strings.Index(base, "/"); i >= 0 {
 		base = base[:i]
 	}
@@ -79,18 +45,21 @@ func parseBPAddress(prefix, addr string) (vpnOctet, hostOctet int, err error) {
 	}
 	rest := strings.TrimPrefix(base, want)
 	parts := strings.Split(rest, ".")
-	if len(parts) != 2 {
+	if len(parts) != 1+prefixHostOctets(prefix) {
 		return 0, 0, fmt.Errorf("invalid address %q", addr)
 	}
 	vpnOctet, err = strconv.Atoi(parts[0])
 	if err != nil {
 		return 0, 0, fmt.Errorf("invalid vpn octet in %q", addr)
 	}
-	hostOctet, err = strconv.Atoi(parts[1])
-	if err != nil {
-		return 0, 0, fmt.Errorf("invalid host octet in %q", addr)
+	for _, p := range parts[1:] {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || n > 255 {
+			return 0, 0, fmt.Errorf("invalid host octet in %q", addr)
+		}
+		host = host<<8 | n
 	}
-	return vpnOctet, hostOctet, nil
+	return vpnOctet, host, nil
 }
 
 func normalizeCIDR(addr string, mask int) string {
@@ -100,86 +69,288 @@ func normalizeCIDR(addr string, mask int) string {
 	return fmt.Sprintf("%s/%d", addr, mask)
 }
 
-func removePeerBlock(content string, ref PeerRef, allowedIP string) (string, bool) {
+func normalizeConfig(content string) string {
 	lines := strings.Split(content, "\n")
 	out := make([]string, 0, len(lines))
+	for _, raw := range lines {
+		line := strings.TrimRight(raw, " \t\r")
+		if line == "" && (len(out) == 0 || out[len(out)-1] == "") {
+			continue
+		}
+		out = append(out, line)
+	}
+	trimmed := strings.TrimRight(strings.Join(out, "\n"), "\n")
+	if trimmed != "" {
+		trimmed += "\n"
+	}
+	return trimmed
+}
+
+func formatEndpoint(host string, port int) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// removePeerBlock drops the [Peer] blocks of ref: those with its managed
+// comment, its allowedIP or, when the other two were edited away, its
+// publicKey. Empty allowedIP or publicKey never match.
+func removePeerBlock(content string, ref PeerRef, allowedIP, publicKey string) (string, bool) {
+	doc := parseINI(content)
 	removed := false
+	for _, sec := range doc.SectionsNamed("Peer") {
+		if peerSectionMatches(sec, ref, allowedIP, publicKey) {
+			removed = doc.RemoveSection(sec) || removed
+		}
+	}
 
-	for i := 0; i < len(lines); {
-		line := strings.TrimSpace(lines[i])
-		if line != "[Peer]" {
-			out = append(out, lines[i])
-			i++
-			continue
+	trimmed := strings.TrimRight(doc.String(), "\n")
+	if trimmed != "" {
+		trimmed += "\n"
+	}
+	return trimmed, removed
+}
+
+func peerSectionMatches(sec *INISection, ref PeerRef, allowedIP, publicKey string) bool {
+	if meta := sectionMeta(sec); meta != nil {
+		if meta["vpn"] == ref.VPN && meta["peer"] == ref.Peer {
+			return true
+		}
+	}
+	allowedIP, publicKey = strings.TrimSpace(allowedIP), strings.TrimSpace(publicKey)
+	for _, e := range sec.Entries {
+		if strings.EqualFold(e.Key, "AllowedIPs") && allowedIP != "" && e.Value == allowedIP {
+			return true
 		}
+		if strings.EqualFold(e.Key, "PublicKey") && publicKey != "" && e.Value == publicKey {
+			return true
+		}
+	}
+	return false
+}
 
-		metaLine := ""
-		if i > 0 {
-			prev := strings.TrimSpace(lines[i-1])
-			if strings.HasPrefix(prev, "# bp-managed:") {
-				metaLine = prev
-			}
+// sectionMeta returns the parsed "# bp-managed:" comment attached to sec, if any.
+func sectionMeta(sec *INISection) map[string]string {
+	for _, c := range sec.Comments() {
+		if meta := parseManagedMeta(c); meta != nil {
+			return meta
 		}
+	}
+	return nil
+}
 
-		j := i + 1
-		for j < len(lines) && !isSectionHeader(strings.TrimSpace(lines[j])) {
-			j++
+func peerMetaLine(vpn, peer string) string {
+	return fmt.Sprintf("# bp-managed: vpn=%s,peer=%s", vpn, peer)
+}
+
+func vpnMetaLine(vpn, prefix string) string {
+	return fmt.Sprintf("# bp-managed: vpn=%s,prefix=%s", vpn, prefix)
+}
+
+// vpnPrefix returns the subnet prefix recorded in a vpn config's header, or
+// "" for configs written before it was recorded.
+func vpnPrefix(doc *INIDocument) string {
+	return vpnMeta(doc)["prefix"]
+}
+
+// vpnMeta returns the "# bp-managed: vpn=" header of a vpn config, or nil.
+func vpnMeta(doc *INIDocument) map[string]string {
+	for _, sec := range doc.Sections {
+		lines := sec.Comments()
+		if sec.Name == "" {
+			lines = sec.lines
+		} else if sec.Name != "Interface" {
+			break
 		}
-		block := lines[i:j]
-		if peerBlockMatches(block, metaLine, ref, allowedIP) {
-			removed = true
-			if metaLine != "" && len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == metaLine {
-				out = out[:len(out)-1]
-			}
-			if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
-				out = out[:len(out)-1]
+		for _, c := range lines {
+			if meta := parseManagedMeta(c); meta != nil && meta["peer"] == "" {
+				return meta
 			}
+		}
+	}
+	return nil
+}
+
+// insertAfterLine adds extra lines after the first line of content equal to
+// line.
+func insertAfterLine(content, line string, extra ...string) string {
+	if len(extra) == 0 {
+		return content
+	}
+	lines := splitLines(content)
+	for i, raw := range lines {
+		if strings.TrimSpace(raw) == line {
+			return strings.Join(slices.Insert(lines, i+1, extra...), "\n")
+		}
+	}
+	return content
+}
+
+func replaceLine(content, old, new string) (string, bool) {
+	lines := strings.Split(content, "\n")
+	replaced := false
+	for i, raw := range lines {
+		if strings.TrimSpace(raw) == old {
+			lines[i] = new
+			replaced = true
+		}
+	}
+	return strings.Join(lines, "\n"), replaced
+}
+
+func splitLines(content string) []string {
+	return strings.Split(content, "\n")
+}
 
-			// Preserve trailing blank/comment lines that actually belong to the next peer block.
-			tailStart := j
-			for k := j - 1; k > i; k-- {
-				t := strings.TrimSpace(lines[k])
-				if t == "" || strings.HasPrefix(t, "#") || strings.HasPrefix(t, ";") {
-					tailStart = k
-					continue
-				}
-				break
+func sectionRange(lines []string, header int) int {
+	end := header + 1
+	for end < len(lines) && !isSectionHeader(strings.TrimSpace(lines[end])) {
+		end++
+	}
+	return end
+}
+
+func findSection(lines []string, sectionName string) (start, end int, ok bool) {
+	for i, raw := range lines {
+		if strings.TrimSpace(raw) == "["+sectionName+"]" {
+			return i, sectionRange(lines, i), true
+		}
+	}
+	return 0, 0, false
+}
+
+func findManagedPeerBlock(lines []string, meta string) (start, end int, ok bool) {
+	for i, raw := range lines {
+		if strings.TrimSpace(raw) != meta {
+			continue
+		}
+		for j := i + 1; j < len(lines); j++ {
+			t := strings.TrimSpace(lines[j])
+			if t == "" || strings.HasPrefix(t, "#") || strings.HasPrefix(t, ";") {
+				continue
 			}
-			if tailStart < j {
-				out = append(out, lines[tailStart:j]...)
+			if t == "[Peer]" {
+				return j, sectionRange(lines, j), true
 			}
+			break
+		}
+	}
+	return 0, 0, false
+}
 
-			i = j
+// setValueInRange replaces the first key in lines[start:end], or inserts it after
+// the last key line of the range, and returns the (possibly grown) slice.
+func setValueInRange(lines []string, start, end int, key, value string) []string {
+	last := start
+	for i := start + 1; i < end; i++ {
+		k, _, ok := splitKV(strings.TrimSpace(lines[i]))
+		if !ok {
 			continue
 		}
+		if strings.EqualFold(k, key) {
+			lines[i] = key + " = " + value
+			return lines
+		}
+		last = i
+	}
+	out := make([]string, 0, len(lines)+1)
+	out = append(out, lines[:last+1]...)
+	out = append(out, key+" = "+value)
+	return append(out, lines[last+1:]...)
+}
 
-		out = append(out, lines[i])
-		i++
+// removeValueInRange drops every key line in lines[start:end].
+func removeValueInRange(lines []string, start, end int, key string) []string {
+	out := make([]string, 0, len(lines))
+	for i, raw := range lines {
+		if i > start && i < end {
+			if k, _, ok := splitKV(strings.TrimSpace(raw)); ok && strings.EqualFold(k, key) {
+				continue
+			}
+		}
+		out = append(out, raw)
 	}
+	return out
+}
 
-	trimmed := strings.Join(out, "\n")
-	trimmed = strings.TrimRight(trimmed, "\n")
-	if trimmed != "" {
-		trimmed += "\n"
+// saveConfigEnabled reports whether the [Interface] of content sets
+// SaveConfig = true.
+func saveConfigEnabled(content string) bool {
+	return strings.EqualFold(firstSectionValue(content, "Interface", "SaveConfig"), "true")
+}
+
+func removeSectionValue(content, sectionName, key string) string {
+	lines := splitLines(content)
+	start, end, ok := findSection(lines, sectionName)
+	if !ok {
+		return content
 	}
-	return trimmed, removed
+	return strings.Join(removeValueInRange(lines, start, end, key), "\n")
 }
 
-func peerBlockMatches(block []string, metaLine string, ref PeerRef, allowedIP string) bool {
-	if metaLine != "" &&
-		strings.Contains(metaLine, "vpn="+ref.VPN) &&
-		strings.Contains(metaLine, "peer="+ref.Peer) {
-		return true
+func removeManagedPeerValue(content, meta, key string) string {
+	lines := splitLines(content)
+	start, end, ok := findManagedPeerBlock(lines, meta)
+	if !ok {
+		return content
 	}
+	return strings.Join(removeValueInRange(lines, start, end, key), "\n")
+}
 
-	for _, raw := range block {
-		k, v, ok := splitKV(strings.TrimSpace(raw))
-		if !ok || !strings.EqualFold(k, "AllowedIPs") {
-			continue
+func setSectionValue(content, sectionName, key, value string) (string, bool) {
+	lines := splitLines(content)
+	start, end, ok := findSection(lines, sectionName)
+	if !ok {
+		return content, false
+	}
+	return strings.Join(setValueInRange(lines, start, end, key, value), "\n"), true
+}
+
+func setManagedPeerValues(content, meta string, kv ...string) (string, bool) {
+	lines := splitLines(content)
+	for i := 0; i+1 < len(kv); i += 2 {
+		start, end, ok := findManagedPeerBlock(lines, meta)
+		if !ok {
+			return content, false
 		}
-		if strings.TrimSpace(v) == strings.TrimSpace(allowedIP) {
-			return true
+		lines = setValueInRange(lines, start, end, kv[i], kv[i+1])
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+type peerBlock struct {
+	Meta       map[string]string
+	PublicKey  string
+	AllowedIPs string
+}
+
+func parseManagedMeta(line string) map[string]string {
+	return parseCommentMap(line, "# bp-managed:")
+}
+
+// parseCommentMap parses a "<prefix> k=v,k2=v2" comment line, or returns nil
+// when line does not start with prefix.
+func parseCommentMap(line, prefix string) map[string]string {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), prefix)
+	if !ok {
+		return nil
+	}
+	meta := make(map[string]string)
+	for _, part := range strings.Split(rest, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && k != "" {
+			meta[k] = v
 		}
 	}
-	return false
+	return meta
+}
+
+func peerBlocks(content string) []peerBlock {
+	var out []peerBlock
+	for _, sec := range parseINI(content).SectionsNamed("Peer") {
+		b := peerBlock{Meta: sectionMeta(sec)}
+		b.PublicKey, _ = sec.Get("PublicKey")
+		b.AllowedIPs, _ = sec.Get("AllowedIPs")
+		out = append(out, b)
+	}
+	return out
 }
@@ -31,7 +31,7 @@ PublicKey = BBB
 AllowedIPs = 69.0.1.3/32
 `
 
-	out, removed := removePeerBlock(in, PeerRef{VPN: "home", Peer: "laptop"}, "69.0.1.2/32")
+	out, removed := removePeerBlock(in, PeerRef{VPN: "home", Peer: "laptop"}, "69.0.1.2/32", "")
 	if !removed {
 		t.Fatal("expected peer block to be removed")
 	}
@@ -45,3 +45,43 @@ AllowedIPs = 69.0.1.3/32
 		t.Fatal("expected other peer block to remain")
 	}
 }
+
+func TestNormalizeConfigIsIdempotent(t *testing.T) {
+	t.Parallel()
+
+	in := "\n# bp-managed: vpn=home  \n[Interface]\t\nPrivateKey = AAA\n\n\n\n# bp-managed: vpn=home,peer=laptop\n[Peer]\r\nPublicKey = BBB   \n\n\n"
+	want := "# bp-managed: vpn=home\n[Interface]\nPrivateKey = AAA\n\n# bp-managed: vpn=home,peer=laptop\n[Peer]\nPublicKey = BBB\n"
+
+	once := normalizeConfig(in)
+	if once != want {
+		t.Fatalf("unexpected normalized config:\n%q\nwant:\n%q", once, want)
+	}
+	if twice := normalizeConfig(once); twice != once {
+		t.Fatalf("normalizeConfig is not idempotent:\n%q\n%q", once, twice)
+	}
+}
+
+func TestParseBPAddressHostOffset(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		prefix, addr string
+		vpn, host    int
+	}{
+		{"69.0", "69.0.3.7/32", 3, 7},
+		{"10", "10.3.0.7/32", 3, 7},
+		{"10", "10.3.2.1/32, fd00::1/128", 3, 513},
+	}
+	for _, c := range cases {
+		vpn, host, err := parseBPAddress(c.prefix, c.addr)
+		if err != nil {
+			t.Fatalf("parseBPAddress(%q, %q) returned error: %v", c.prefix, c.addr, err)
+		}
+		if vpn != c.vpn || host != c.host {
+			t.Fatalf("parseBPAddress(%q, %q) = %d, %d; want %d, %d", c.prefix, c.addr, vpn, host, c.vpn, c.host)
+		}
+	}
+	if _, _, err := parseBPAddress("10", "10.3.7/32"); err == nil {
+		t.Fatal("expected error for two-octet address under a one-octet prefix")
+	}
+}
@@ -0,0 +1,137 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// publicIPTimeout bounds the request to Config.PublicIPService.
+const publicIPTimeout = 3 * time.Second
+
+// metadataTimeout bounds the whole cloud metadata lookup; off-cloud the
+// link-local address simply never answers.
+const metadataTimeout = 2 * time.Second
+
+// metadataProvider fetches the public IPv4 address from one cloud's
+// instance metadata service at 169.254.169.254.
+type metadataProvider struct {
+	name  string
+	fetch func(ctx context.Context, c *http.Client) (string, error)
+}
+
+var metadataProviders = []metadataProvider{
+	{"aws", func(ctx context.Context, c *http.Client) (string, error) {
+		// IMDSv2 needs a session token first.
+		token, err := httpText(ctx, c, http.MethodPut, "http://169.254.169.254/latest/api/token",
+			map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+		if err != nil {
+			return "", err
+		}
+		return httpText(ctx, c, http.MethodGet, "http://169.254.169.254/latest/meta-data/public-ipv4",
+			map[string]string{"X-aws-ec2-metadata-token": token})
+	}},
+	{"gcp", func(ctx context.Context, c *http.Client) (string, error) {
+		return httpText(ctx, c, http.MethodGet, "http://169.254.169.254/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip",
+			map[string]string{"Metadata-Flavor": "Google"})
+	}},
+	{"azure", func(ctx context.Context, c *http.Client) (string, error) {
+		return httpText(ctx, c, http.MethodGet, "http://169.254.169.254/metadata/instance/network/interface/0/ipv4/ipAddress/0/publicIpAddress?api-version=2021-02-01&format=text",
+			map[string]string{"Metadata": "true"})
+	}},
+}
+
+// httpClient sends requests through the manager's Network, so tests and
+// network namespaces see the same dialer as every other probe.
+func (m *Manager) httpClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{DialContext: m.net.DialContext}}
+}
+
+// cloudMetadataIP asks every known metadata service at once and returns the
+// first public address reported; only the provider hosting us answers.
+func (m *Manager) cloudMetadataIP(ctx context.Context) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+
+	type answer struct {
+		provider string
+		ip       net.IP
+		err      error
+	}
+	client := m.httpClient()
+	answers := make(chan answer, len(metadataProviders))
+	for _, p := range metadataProviders {
+		go func() {
+			body, err := p.fetch(ctx, client)
+			ip := net.ParseIP(body)
+			if err == nil && (ip == nil || ip.To4() == nil) {
+				err = fmt.Errorf("unexpected address %q", body)
+			}
+			answers <- answer{p.name, ip, err}
+		}()
+	}
+	var errs []error
+	for range metadataProviders {
+		a := <-answers
+		if a.err == nil {
+			m.log.Debug("server ip read from cloud metadata", "provider", a.provider, "ip", a.ip)
+			return a.ip, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", a.provider, a.err))
+	}
+	return nil, fmt.Errorf("no cloud metadata service answered: %w", errors.Join(errs...))
+}
+
+// httpText performs one request and returns the trimmed body of a 200 reply.
+func httpText(ctx context.Context, c *http.Client, method, url string, header map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// httpPublicIP asks Config.PublicIPService for the address it sees us from.
+func (m *Manager) httpPublicIP(ctx context.Context) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, publicIPTimeout)
+	defer cancel()
+	body, err := httpText(ctx, m.httpClient(), http.MethodGet, m.cfg.PublicIPService, nil)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(body)
+	if ip == nil {
+		return nil, fmt.Errorf("public ip service %s returned %q, not an ip address", m.cfg.PublicIPService, body)
+	}
+	m.log.Debug("server ip read from public ip service", "service", m.cfg.PublicIPService, "ip", ip)
+	return ip, nil
+}
+
+// cgnatNet is the RFC 6598 shared address space carriers use for NAT.
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP reports whether ip is reachable from the internet: a global
+// unicast address outside RFC 1918 / unique local ranges and carrier-grade NAT.
+func isPublicIP(ip net.IP) bool {
+	return ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnatNet.Contains(ip)
+}
@@ -0,0 +1,137 @@
+package bypasser
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// httpNetwork sends every TCP dial to srv and everything else to fakeNetwork.
+type httpNetwork struct {
+	fakeNetwork
+	srv *httptest.Server
+}
+
+func (n httpNetwork) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if strings.HasPrefix(network, "tcp") {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", n.srv.Listener.Addr().String())
+	}
+	return n.fakeNetwork.DialContext(ctx, network, address)
+}
+
+func TestDetectServerIPFromCloudMetadata(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip" && r.Header.Get("Metadata-Flavor") == "Google" {
+			w.Write([]byte("34.1.2.3\n"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	local := fakeNetwork{local: map[string]net.IP{"udp4": net.ParseIP("10.0.0.5")}}
+
+	cfg := Config{WireGuardDir: t.TempDir(), EndpointFamily: EndpointFamilyV4, EndpointSource: EndpointSourceCloudMetadata}
+	mgr := NewManager(cfg, Dependencies{System: &FakeSystem{}, Net: httpNetwork{local, srv}})
+	got, err := mgr.detectServerIP(context.Background())
+	if err != nil {
+		t.Fatalf("detectServerIP returned error: %v", err)
+	}
+	if got != "34.1.2.3" {
+		t.Fatalf("detectServerIP = %q, want the metadata address", got)
+	}
+
+	// Off-cloud nothing answers and the local detection is used.
+	empty := httptest.NewServer(http.NotFoundHandler())
+	defer empty.Close()
+	mgr = NewManager(cfg, Dependencies{System: &FakeSystem{}, Net: httpNetwork{local, empty}})
+	if got, err := mgr.detectServerIP(context.Background()); err != nil || got != "10.0.0.5" {
+		t.Fatalf("detectServerIP = %q, %v; want the local fallback", got, err)
+	}
+}
+
+func TestDetectServerIPFromHTTPService(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	reply := "198.51.100.20"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(reply))
+	}))
+	defer srv.Close()
+	detect := func(local string) (string, error) {
+		cfg := Config{WireGuardDir: t.TempDir(), EndpointFamily: EndpointFamilyV4, EndpointSource: EndpointSourceHTTP, PublicIPService: "http://ip.example.com/"}
+		netw := httpNetwork{fakeNetwork{local: map[string]net.IP{"udp4": net.ParseIP(local)}}, srv}
+		return NewManager(cfg, Dependencies{System: &FakeSystem{}, Net: netw}).detectServerIP(context.Background())
+	}
+
+	if got, err := detect("192.168.1.10"); err != nil || got != "198.51.100.20" {
+		t.Fatalf("detectServerIP = %q, %v; want the service address", got, err)
+	}
+	if got, err := detect("203.0.113.9"); err != nil || got != "203.0.113.9" || calls.Load() != 1 {
+		t.Fatalf("detectServerIP = %q, %v after %d calls; want the public local address without a call", got, err, calls.Load())
+	}
+	reply = "<html>rate limited</html>"
+	if got, err := detect("192.168.1.10"); err != nil || got != "192.168.1.10" {
+		t.Fatalf("detectServerIP = %q, %v; want the local fallback", got, err)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	t.Parallel()
+
+	for addr, want := range map[string]bool{
+		"10.0.0.1":        false,
+		"10.255.255.254":  false,
+		"172.16.0.1":      false,
+		"172.31.255.254":  false,
+		"172.32.0.1":      true,
+		"192.168.1.10":    false,
+		"192.169.0.1":     true,
+		"100.64.0.1":      false,
+		"100.127.255.254": false,
+		"100.128.0.1":     true,
+		"127.0.0.1":       false,
+		"169.254.1.1":     false,
+		"203.0.113.7":     true,
+		"fd00::1":         false,
+		"2001:db8::1":     true,
+	} {
+		if got := isPublicIP(net.ParseIP(addr)); got != want {
+			t.Fatalf("isPublicIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestAddPeerWarnsOnPrivateEndpoint(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	netw := fakeNetwork{local: map[string]net.IP{"udp4": net.ParseIP("100.72.3.4")}}
+	cfg := Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointFamily: EndpointFamilyV4}
+	mgr := NewManager(cfg, Dependencies{System: &FakeSystem{}, Keys: &fakeKeys{}, Net: netw})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	res, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	if !strings.Contains(res.PeerConfig, "Endpoint = 100.72.3.4:55107\n") {
+		t.Fatalf("unexpected endpoint:\n%s", res.PeerConfig)
+	}
+	found := false
+	for _, w := range res.Warnings {
+		found = found || strings.Contains(w, "detected private IP 100.72.3.4; set BP_ENDPOINT_HOST")
+	}
+	if !found {
+		t.Fatalf("expected a private IP warning, got %#v", res.Warnings)
+	}
+}
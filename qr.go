@@ -0,0 +1,23 @@
+package bypasser
+
+import (
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+func EncodeQR(conf string) (string, error) {
+	if strings.TrimSpace(conf) == "" {
+		return "", fmt.Errorf("cannot encode empty config as qr code")
+	}
+	q, err := qrcode.New(conf, qrcode.Low)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode config as qr code (%d bytes): %w", len(conf), err)
+	}
+	return q.ToSmallString(false), nil
+}
+
+func (r AddPeerResult) QRCode() (string, error) {
+	return EncodeQR(r.PeerConfig)
+}
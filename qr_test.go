@@ -0,0 +1,22 @@
+package bypasser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeQR(t *testing.T) {
+	t.Parallel()
+
+	code, err := EncodeQR("[Interface]\nPrivateKey = AAA\n")
+	if err != nil {
+		t.Fatalf("EncodeQR returned error: %v", err)
+	}
+	if !strings.Contains(code, "█") {
+		t.Fatalf("expected block characters in qr output, got %q", code)
+	}
+
+	if _, err := EncodeQR(strings.Repeat("x", 4000)); err == nil {
+		t.Fatal("expected error for config exceeding qr capacity")
+	}
+}
@@ -0,0 +1,163 @@
+package bypasser
+
+import (
+	"context"
+	"slices"
+)
+
+type ReconcileOptions struct {
+	// Prune deletes vpns (with their peer files) and peers that are not in
+	// the desired inventory; without it they are left alone.
+	Prune bool
+}
+
+// Reconcile converges the WireGuard directory to desired: missing vpns and
+// peers are created, existing ones are left untouched. See
+// ReconcileWithOptions.
+func (m *Manager) Reconcile(ctx context.Context, desired Inventory) (Report, error) {
+	return m.ReconcileWithOptions(ctx, desired, ReconcileOptions{})
+}
+
+// ReconcileWithOptions creates the vpns and named peers of desired that do
+// not exist yet and, with opts.Prune, deletes those it does not list. A
+// desired ListenPort pins the port of a new vpn and a desired PublicKey adds
+// the peer with its own key (AddPeerOptions.PublicKey). Existing vpns and
+// peers are matched by name only and never rewritten.
+//
+// The whole run holds the lock once; each vpn that changed is restarted (or
+// enabled, when new) once at the end of its own changes.
+func (m *Manager) ReconcileWithOptions(ctx context.Context, desired Inventory, opts ReconcileOptions) (Report, error) {
+	var rep Report
+	if err := m.cfg.validate(); err != nil {
+		return rep, err
+	}
+	wanted := make(map[string]bool)
+	for _, v := range desired.VPNs {
+		if err := ValidateName("vpn", v.Name); err != nil {
+			return rep, err
+		}
+		if wanted[v.Name] {
+			return rep, errorf(ErrValidation, "vpn %q is listed twice", v.Name)
+		}
+		wanted[v.Name] = true
+		if _, err := m.addVPNConfig(AddVPNOptions{Port: v.ListenPort}); err != nil {
+			return rep, err
+		}
+		seen := make(map[string]bool)
+		for _, p := range v.Peers {
+			if p.Name == "" {
+				continue
+			}
+			if err := ValidateName("peer", p.Name); err != nil {
+				return rep, err
+			}
+			if seen[p.Name] {
+				return rep, errorf(ErrValidation, "peer %q is listed twice in vpn %q", p.Name, v.Name)
+			}
+			seen[p.Name] = true
+			if err := m.checkClientKeyOptions(AddPeerOptions{PublicKey: p.PublicKey}); err != nil {
+				return rep, err
+			}
+		}
+	}
+
+	if err := m.ensureDir(m.cfg.WireGuardDir, &rep); err != nil {
+		return rep, err
+	}
+	if err := m.ensureDir(m.cfg.PeersDir(), &rep); err != nil {
+		return rep, err
+	}
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	vpns, err := m.ListVPNs()
+	if err != nil {
+		return rep, err
+	}
+	refs, err := m.ListPeers()
+	if err != nil {
+		return rep, err
+	}
+	peers := make(map[string][]string)
+	for _, ref := range refs {
+		peers[ref.VPN] = append(peers[ref.VPN], ref.Peer)
+	}
+
+	changed := false
+	if opts.Prune {
+		for _, vpn := range vpns {
+			if wanted[vpn] {
+				continue
+			}
+			if err := m.deleteVPN(ctx, vpn, DeleteVPNOptions{Cascade: true}, &rep); err != nil {
+				return rep, err
+			}
+			changed = true
+		}
+	}
+
+	for _, v := range desired.VPNs {
+		created := false
+		if !slices.Contains(vpns, v.Name) {
+			var res AddVPNResult
+			created, err = m.addVPN(ctx, v.Name, AddVPNOptions{Port: v.ListenPort}, m.cfg, &res)
+			rep.Changes = append(rep.Changes, res.Changes...)
+			rep.Warnings = append(rep.Warnings, res.Warnings...)
+			if err != nil {
+				return rep, err
+			}
+		}
+
+		modified := false
+		listed := make(map[string]bool)
+		var reqs []peerRequest
+		for _, p := range v.Peers {
+			if p.Name == "" {
+				rep.warnf("skipping unnamed peer %s of vpn %q", p.PublicKey, v.Name)
+				continue
+			}
+			listed[p.Name] = true
+			if !slices.Contains(peers[v.Name], p.Name) {
+				reqs = append(reqs, peerRequest{name: p.Name, opts: AddPeerOptions{PublicKey: p.PublicKey}})
+			}
+		}
+		if opts.Prune {
+			for _, peer := range peers[v.Name] {
+				if listed[peer] {
+					continue
+				}
+				if err := m.deletePeer(ctx, v.Name, peer, &rep); err != nil {
+					return rep, err
+				}
+				modified = true
+			}
+		}
+		if len(reqs) > 0 {
+			results, err := m.addPeers(ctx, v.Name, reqs, &rep)
+			if err != nil {
+				return rep, err
+			}
+			for _, res := range results {
+				rep.Changes = append(rep.Changes, res.Changes...)
+				rep.Warnings = append(rep.Warnings, res.Warnings...)
+			}
+			modified = true
+		}
+
+		switch {
+		case created:
+			m.maybeVPNEnable(ctx, &rep, v.Name)
+		case modified:
+			m.maybeVPNRestart(ctx, &rep, v.Name)
+		}
+		changed = changed || created || modified
+	}
+
+	if changed {
+		m.updateInventory(&rep)
+	}
+	return rep, nil
+}
@@ -0,0 +1,91 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sys := &FakeSystem{RootValue: true, Commands: map[string]bool{"systemctl": true}}
+	mgr := NewManager(Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointHost: "203.0.113.7"}, Dependencies{System: sys, Keys: &fakeKeys{}})
+	for _, vpn := range []string{"home", "old"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+	for _, peer := range []string{"laptop", "phone"} {
+		if _, err := mgr.AddPeer(ctx, "home", peer); err != nil {
+			t.Fatalf("AddPeer returned error: %v", err)
+		}
+	}
+	cfg := mgr.Config()
+	laptop := readTestFile(t, cfg.PeerConfigPath("home", "laptop"))
+
+	desired := Inventory{VPNs: []InventoryVPN{
+		{Name: "home", Peers: []InventoryPeer{{Name: "laptop"}, {Name: "tablet"}}},
+		{Name: "work", ListenPort: 55200, Peers: []InventoryPeer{{Name: "desk"}, {Name: "byo", PublicKey: fakeKey("byo")}}},
+	}}
+	calls := len(sys.Calls())
+	if _, err := mgr.Reconcile(ctx, desired); err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+	want := []string{"systemctl restart wg-quick@bp-home", "systemctl enable --now wg-quick@bp-work"}
+	if got := sys.Calls()[calls:]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	peers, err := mgr.ListPeers()
+	if err != nil {
+		t.Fatalf("ListPeers returned error: %v", err)
+	}
+	if got := fmtRefs(peers); got != "home:laptop home:phone home:tablet work:byo work:desk" {
+		t.Fatalf("peers = %s", got)
+	}
+	if readTestFile(t, cfg.PeerConfigPath("home", "laptop")) != laptop {
+		t.Fatalf("existing peer was rewritten")
+	}
+	work := readTestFile(t, cfg.VPNConfigPath("work"))
+	if firstSectionValue(work, "Interface", "ListenPort") != "55200" || !strings.Contains(work, "PublicKey = "+fakeKey("byo")) {
+		t.Fatalf("work not created as desired:\n%s", work)
+	}
+
+	calls = len(sys.Calls())
+	rep, err := mgr.Reconcile(ctx, desired)
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+	if len(rep.Changes) != 0 || len(sys.Calls()) != calls {
+		t.Fatalf("second run was not a no-op: %#v", rep)
+	}
+
+	if _, err := mgr.ReconcileWithOptions(ctx, desired, ReconcileOptions{Prune: true}); err != nil {
+		t.Fatalf("ReconcileWithOptions returned error: %v", err)
+	}
+	want = []string{"systemctl disable --now wg-quick@bp-old", "systemctl restart wg-quick@bp-home"}
+	if got := sys.Calls()[calls:]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	if vpns, _ := mgr.ListVPNs(); strings.Join(vpns, " ") != "home work" {
+		t.Fatalf("vpns = %v", vpns)
+	}
+	if peers, _ := mgr.ListPeers(); fmtRefs(peers) != "home:laptop home:tablet work:byo work:desk" {
+		t.Fatalf("peers = %s", fmtRefs(peers))
+	}
+
+	dup := Inventory{VPNs: []InventoryVPN{{Name: "home"}, {Name: "home"}}}
+	if _, err := mgr.Reconcile(ctx, dup); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for a duplicate vpn, got %v", err)
+	}
+}
+
+func fmtRefs(refs []PeerRef) string {
+	s := make([]string, len(refs))
+	for i, r := range refs {
+		s[i] = r.String()
+	}
+	return strings.Join(s, " ")
+}
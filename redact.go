@@ -0,0 +1,33 @@
+package bypasser
+
+import "strings"
+
+const redacted = "<redacted>"
+
+// redactKeys replaces the values of the given keys (matched case-insensitively,
+// comments excluded) with "<redacted>".
+func redactKeys(content string, keys ...string) string {
+	lines := splitLines(content)
+	for i, raw := range lines {
+		if strings.HasPrefix(strings.TrimSpace(raw), "#") {
+			continue
+		}
+		key, _, ok := splitKV(raw)
+		if !ok {
+			continue
+		}
+		for _, k := range keys {
+			if strings.EqualFold(key, k) {
+				lines[i] = key + " = " + redacted
+				break
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RedactConfig masks the PrivateKey, PresharedKey and PublicKey values of a
+// WireGuard config, e.g. before printing it to a shared terminal or a CI log.
+func RedactConfig(content string) string {
+	return redactKeys(content, "PrivateKey", "PresharedKey", "PublicKey")
+}
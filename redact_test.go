@@ -0,0 +1,41 @@
+package bypasser
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRedactConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	res, err := mgr.AddPeer(ctx, "home", "laptop")
+	if err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+	doc := parseINI(res.PeerConfig)
+	out := RedactConfig(res.PeerConfig)
+	for _, secret := range []string{doc.First("Interface", "PrivateKey"), doc.First("Peer", "PresharedKey"), doc.First("Peer", "PublicKey")} {
+		if secret == "" || strings.Contains(out, secret) {
+			t.Fatalf("%q not redacted:\n%s", secret, out)
+		}
+	}
+	for _, line := range []string{"PrivateKey = <redacted>\n", "PublicKey = <redacted>\n", "PresharedKey = <redacted>\n", "Endpoint = 203.0.113.7:55107\n", "# bp-managed: vpn=home,peer=laptop\n"} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("expected %q in redacted config:\n%s", line, out)
+		}
+	}
+	if readTestFile(t, res.PeerConfigPath) != res.PeerConfig {
+		t.Fatalf("peer file does not hold the real config")
+	}
+
+	in := "[Interface]\n# PrivateKey = <paste your own>\nprivatekey=abc\n"
+	if got, want := RedactConfig(in), "[Interface]\n# PrivateKey = <paste your own>\nprivatekey = <redacted>\n"; got != want {
+		t.Fatalf("RedactConfig = %q, want %q", got, want)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 )
 
 type System interface {
@@ -23,6 +25,12 @@ type KeyGenerator interface {
 	GeneratePresharedKey(ctx context.Context) (string, error)
 }
 
+type Network interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
 type ExecSystem struct{}
 
 func (ExecSystem) IsRoot() bool {
@@ -79,6 +87,24 @@ func (ExecSystem) OutputInput(ctx context.Context, input, name string, args ...s
 	return strings.TrimSpace(stdout.String()), nil
 }
 
+type StdNetwork struct{}
+
+func (StdNetwork) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: 2 * time.Second}
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (StdNetwork) ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	var lc net.ListenConfig
+	return lc.ListenPacket(ctx, network, address)
+}
+
+func (StdNetwork) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
 type WGCLIKeyGenerator struct {
 	System System
 }
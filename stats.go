@@ -0,0 +1,62 @@
+package bypasser
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+type ConfigStats struct {
+	Path             string `json:"path"`
+	Size             int64  `json:"size"`
+	PeerBlocks       int    `json:"peer_blocks"`
+	AverageBlockSize int64  `json:"average_block_size"`
+}
+
+func (m *Manager) ConfigStats(vpn string) (ConfigStats, error) {
+	if err := ValidateName("vpn", vpn); err != nil {
+		return ConfigStats{}, err
+	}
+	path := m.cfg.VPNConfigPath(vpn)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ConfigStats{}, vpnNotFound(vpn, path)
+		}
+		return ConfigStats{}, err
+	}
+	return configStats(path, string(b)), nil
+}
+
+func configStats(path, content string) ConfigStats {
+	st := ConfigStats{Path: path, Size: int64(len(content))}
+	var blockBytes int64
+	inPeer := false
+	for _, raw := range strings.SplitAfter(content, "\n") {
+		line := strings.TrimSpace(raw)
+		if isSectionHeader(line) {
+			inPeer = line == "[Peer]"
+			if inPeer {
+				st.PeerBlocks++
+			}
+		}
+		if inPeer {
+			blockBytes += int64(len(raw))
+		}
+	}
+	if st.PeerBlocks > 0 {
+		st.AverageBlockSize = blockBytes / int64(st.PeerBlocks)
+	}
+	return st
+}
+
+func (m *Manager) warnConfigSize(rep *Report, path, content string) {
+	if m.cfg.ConfigSizeWarnBytes <= 0 {
+		return
+	}
+	st := configStats(path, content)
+	if st.Size <= m.cfg.ConfigSizeWarnBytes {
+		return
+	}
+	rep.warnf("vpn config %s is %d bytes with %d peer blocks (threshold %d); consider splitting peers across more VPNs", path, st.Size, st.PeerBlocks, m.cfg.ConfigSizeWarnBytes)
+}
@@ -0,0 +1,109 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type WGStatus struct {
+	VPN        string `json:"vpn"`
+	Interface  string `json:"interface"`
+	Up         bool   `json:"up"`
+	Message    string `json:"message,omitempty"` // why Up is false, e.g. wg missing or interface down
+	PublicKey  string `json:"public_key,omitempty"`
+	ListenPort int    `json:"listen_port,omitempty"`
+	// Peers lists every peer known from the vpn config, joined by public key
+	// with the live state; unknown live peers are included with an empty Peer.
+	Peers []PeerStatus `json:"peers"`
+}
+
+type PeerStatus struct {
+	Peer            string    `json:"peer,omitempty"`
+	PublicKey       string    `json:"public_key"`
+	Endpoint        string    `json:"endpoint,omitempty"`
+	AllowedIPs      string    `json:"allowed_ips,omitempty"`
+	LatestHandshake time.Time `json:"latest_handshake,omitzero"`
+	TransferRx      int64     `json:"transfer_rx"`
+	TransferTx      int64     `json:"transfer_tx"`
+	Live            bool      `json:"live"` // reported by wg show
+}
+
+// Status reads the live state of a vpn with "wg show <iface> dump". A missing
+// wg binary or a down interface is reported in WGStatus.Message, not as an error.
+func (m *Manager) Status(ctx context.Context, vpn string) (WGStatus, error) {
+	st := WGStatus{VPN: vpn, Interface: m.cfg.InterfaceName(vpn)}
+	if err := ValidateName("vpn", vpn); err != nil {
+		return st, err
+	}
+
+	path := m.cfg.VPNConfigPath(vpn)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return st, vpnNotFound(vpn, path)
+		}
+		return st, err
+	}
+	byKey := make(map[string]int)
+	for _, block := range peerBlocks(string(b)) {
+		if block.PublicKey == "" {
+			continue
+		}
+		ps := PeerStatus{PublicKey: block.PublicKey, AllowedIPs: block.AllowedIPs}
+		if block.Meta != nil {
+			ps.Peer = block.Meta["peer"]
+		}
+		byKey[ps.PublicKey] = len(st.Peers)
+		st.Peers = append(st.Peers, ps)
+	}
+
+	if !m.sys.HasCommand("wg") {
+		st.Message = "wg is not installed"
+		return st, nil
+	}
+	cmd := m.netnsCommand("wg", "show", st.Interface, "dump")
+	cmdCtx, cancel := m.commandContext(ctx)
+	defer cancel()
+	out, err := m.sys.Output(cmdCtx, cmd[0], cmd[1:]...)
+	if err != nil {
+		st.Message = fmt.Sprintf("interface %s is not up: %v", st.Interface, err)
+		return st, nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if iface := strings.Split(lines[0], "\t"); len(iface) >= 3 {
+		st.PublicKey = iface[1]
+		st.ListenPort, _ = strconv.Atoi(iface[2])
+	}
+	st.Up = true
+	for _, line := range lines[1:] {
+		f := strings.Split(line, "\t")
+		if len(f) < 7 {
+			continue
+		}
+		i, ok := byKey[f[0]]
+		if !ok {
+			i = len(st.Peers)
+			st.Peers = append(st.Peers, PeerStatus{PublicKey: f[0]})
+		}
+		ps := &st.Peers[i]
+		ps.Live = true
+		if f[2] != "(none)" {
+			ps.Endpoint = f[2]
+		}
+		if f[3] != "(none)" {
+			ps.AllowedIPs = f[3]
+		}
+		if secs, _ := strconv.ParseInt(f[4], 10, 64); secs > 0 {
+			ps.LatestHandshake = time.Unix(secs, 0)
+		}
+		ps.TransferRx, _ = strconv.ParseInt(f[5], 10, 64)
+		ps.TransferTx, _ = strconv.ParseInt(f[6], 10, 64)
+	}
+	return st, nil
+}
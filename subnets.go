@@ -0,0 +1,68 @@
+package bypasser
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// SubnetEntry is one network of a vpn's [Interface] Address.
+type SubnetEntry struct {
+	VPN     string     `json:"vpn"`
+	CIDR    string     `json:"cidr"`
+	Network *net.IPNet `json:"-"`
+}
+
+// SubnetOverlap is a pair of vpns whose networks intersect.
+type SubnetOverlap struct {
+	A SubnetEntry `json:"a"`
+	B SubnetEntry `json:"b"`
+}
+
+// SubnetMap returns the mesh networks (IPv4 and IPv6) of every vpn, in vpn
+// order. Address entries that do not parse as CIDRs are skipped.
+func (m *Manager) SubnetMap() ([]SubnetEntry, error) {
+	vpns, err := m.ListVPNs()
+	if err != nil {
+		return nil, err
+	}
+	var out []SubnetEntry
+	for _, vpn := range vpns {
+		b, err := os.ReadFile(m.cfg.VPNConfigPath(vpn))
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range strings.Split(firstSectionValue(string(b), "Interface", "Address"), ",") {
+			_, network, err := net.ParseCIDR(strings.TrimSpace(addr))
+			if err != nil {
+				continue
+			}
+			out = append(out, SubnetEntry{VPN: vpn, CIDR: network.String(), Network: network})
+		}
+	}
+	return out, nil
+}
+
+// DetectOverlaps reports every pair of vpns whose networks intersect, e.g. a
+// "10" /16 vpn swallowing a "10.8" /24 one; such vpns route unpredictably.
+func (m *Manager) DetectOverlaps() ([]SubnetOverlap, error) {
+	entries, err := m.SubnetMap()
+	if err != nil {
+		return nil, err
+	}
+	var out []SubnetOverlap
+	for i, a := range entries {
+		for _, b := range entries[i+1:] {
+			if a.VPN != b.VPN && subnetsOverlap(a.Network, b.Network) {
+				out = append(out, SubnetOverlap{A: a, B: b})
+			}
+		}
+	}
+	return out, nil
+}
+
+// subnetsOverlap reports whether a and b share any address; CIDR blocks
+// either nest or are disjoint, so checking both network addresses suffices.
+func subnetsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
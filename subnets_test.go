@@ -0,0 +1,61 @@
+package bypasser
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSubnetMapAndDetectOverlaps(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mgr := newTestManager(t, Config{IPv6Prefix: "fd69"})
+	for _, vpn := range []string{"home", "work"} {
+		if _, err := mgr.AddVPN(ctx, vpn); err != nil {
+			t.Fatalf("AddVPN returned error: %v", err)
+		}
+	}
+
+	entries, err := mgr.SubnetMap()
+	if err != nil {
+		t.Fatalf("SubnetMap returned error: %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		if e.Network == nil || e.Network.String() != e.CIDR {
+			t.Fatalf("entry network does not match cidr: %#v", e)
+		}
+		got = append(got, e.VPN+" "+e.CIDR)
+	}
+	if len(got) != 4 || got[0] != "home 69.0.1.0/24" || got[2] != "work 69.0.2.0/24" || !strings.Contains(got[1], ":") {
+		t.Fatalf("unexpected subnet map: %v", got)
+	}
+	if overlaps, err := mgr.DetectOverlaps(); err != nil || len(overlaps) != 0 {
+		t.Fatalf("DetectOverlaps = %#v, %v; want none", overlaps, err)
+	}
+
+	cfg := mgr.Config()
+	writeTestFile(t, cfg.VPNConfigPath("wide"), "[Interface]\nAddress = 69.0.0.1/16\nListenPort = 55200\nPrivateKey = "+fakeKey("wide")+"\n")
+	overlaps, err := mgr.DetectOverlaps()
+	if err != nil {
+		t.Fatalf("DetectOverlaps returned error: %v", err)
+	}
+	if len(overlaps) != 2 || overlaps[0].A.VPN != "home" || overlaps[0].B.VPN != "wide" || overlaps[1].A.VPN != "wide" || overlaps[1].B.VPN != "work" {
+		t.Fatalf("unexpected overlaps: %#v", overlaps)
+	}
+
+	diags, err := mgr.Doctor(ctx)
+	if err != nil {
+		t.Fatalf("Doctor returned error: %v", err)
+	}
+	found := 0
+	for _, d := range diags {
+		if d.Severity == SeverityError && strings.Contains(d.Message, "overlaps") {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Fatalf("expected two overlap diagnostics, got %#v", diags)
+	}
+}
@@ -3,52 +3,113 @@ package bypasser
 import (
 	"fmt"
 	"regexp"
+	"time"
 )
 
 type Change struct {
-	Action string
-	Path   string
+	Action string `json:"action"`
+	Path   string `json:"path"`
 }
 
 type RuntimeAction struct {
-	Description string
-	Command     string
-	Status      string // "executed" or "suggested"
-	Message     string
+	Description string `json:"description"`
+	Command     string `json:"command"`
+	Status      string `json:"status"` // "executed", "suggested" or "planned" (dry run)
+	Message     string `json:"message,omitempty"`
 }
 
 type Report struct {
-	Changes        []Change
-	RuntimeActions []RuntimeAction
-	Warnings       []string
+	Changes        []Change        `json:"changes,omitempty"`
+	RuntimeActions []RuntimeAction `json:"runtime_actions,omitempty"`
+	Warnings       []string        `json:"warnings,omitempty"`
 }
 
 type AddVPNResult struct {
 	Report
-	VPN        string
-	Interface  string
-	ConfigPath string
+	VPN        string `json:"vpn"`
+	Interface  string `json:"interface"`
+	ListenPort int    `json:"listen_port,omitempty"`
+	Address    string `json:"address,omitempty"`
+	ConfigPath string `json:"config_path"`
 }
 
 type PeerRef struct {
-	VPN  string
-	Peer string
+	VPN  string `json:"vpn"`
+	Peer string `json:"peer"`
 }
 
 func (p PeerRef) String() string { return p.VPN + ":" + p.Peer }
 
+type AddVPNOptions struct {
+	// Port pins the ListenPort instead of auto-assigning the next free one.
+	Port int
+	// SubnetOctet pins the vpn's third address octet, e.g. 10 for 69.0.10.0/24.
+	SubnetOctet int
+	// SubnetPrefix numbers this vpn under another prefix than
+	// Config.SubnetPrefix; it is recorded in the vpn config.
+	SubnetPrefix string
+	// IfNotExists returns an existing vpn's details, reported as
+	// "unchanged", instead of failing with ErrVPNExists.
+	IfNotExists bool
+}
+
+type DeleteVPNOptions struct {
+	// Cascade also deletes the vpn's peer files instead of warning about them.
+	Cascade bool
+}
+
+type AddPeerOptions struct {
+	// AllowedIPs overrides the client's routed networks (Config.ClientAllowedIPs, or the mesh CIDR).
+	AllowedIPs string
+	// HostOctet requests a fixed host number in the vpn subnet, e.g. 50 for 69.0.1.50.
+	HostOctet int
+	// PublicKey is the client's own public key. When set no private key is
+	// generated and the client config carries a placeholder for it instead.
+	PublicKey string
+	// PresharedKey is used instead of a generated one, even when
+	// Config.DisablePresharedKey is set.
+	PresharedKey string
+	// IfNotExists returns an existing peer's client config, reported as
+	// "unchanged", instead of failing with ErrPeerExists.
+	IfNotExists bool
+	// ExpiresAt, when set, is recorded as "# bp-expires:" so PruneExpired
+	// deletes the peer once it has passed.
+	ExpiresAt time.Time
+	// Labels tag the peer for PeerLabels and ListPeersMatching, e.g.
+	// {"team": "eng"}; they are recorded as "# bp-labels: team=eng".
+	Labels map[string]string
+}
+
 type AddPeerResult struct {
 	Report
 	PeerRef
-	PeerConfigPath string
-	PeerConfig     string
+	PeerConfigPath string `json:"peer_config_path"`
+	PeerConfig     string `json:"peer_config"`
+}
+
+type VPNInfo struct {
+	Name       string    `json:"name"`
+	Interface  string    `json:"interface"`
+	ListenPort int       `json:"listen_port"`
+	Address    string    `json:"address"`
+	PublicKey  string    `json:"public_key"`
+	Peers      []PeerRef `json:"peers"`
+}
+
+type VPNSummary struct {
+	Name       string   `json:"name"`
+	Interface  string   `json:"interface"`
+	ListenPort int      `json:"listen_port,omitempty"`
+	Address    string   `json:"address,omitempty"`
+	Peers      []string `json:"peers"`
+	Orphaned   bool     `json:"orphaned,omitempty"` // peer files exist but the vpn config does not
 }
 
 var nameRE = regexp.MustCompile(`^[a-z0-9]+$`)
 
 func ValidateName(kind, name string) error {
 	if !nameRE.MatchString(name) {
-		return fmt.Errorf("invalid %s name %q: use only lowercase letters and numbers", kind, name)
+		return errorf(ErrInvalidName, "invalid %s name %q: use only lowercase letters and numbers", kind, name)
 	}
 	return nil
 }
@@ -62,7 +123,7 @@ func ParsePeerRef(s string) (PeerRef, error) {
 		p.VPN = s[:i]
 		p.Peer = s[i+1:]
 		if p.VPN == "" || p.Peer == "" {
-			return PeerRef{}, fmt.Errorf("invalid peer name %q: expected vpn:peer", s)
+			return PeerRef{}, errorf(ErrInvalidName, "invalid peer name %q: expected vpn:peer", s)
 		}
 		if err := ValidateName("vpn", p.VPN); err != nil {
 			return PeerRef{}, err
@@ -72,7 +133,7 @@ func ParsePeerRef(s string) (PeerRef, error) {
 		}
 		return p, nil
 	}
-	return PeerRef{}, fmt.Errorf("invalid peer name %q: expected vpn:peer", s)
+	return PeerRef{}, errorf(ErrInvalidName, "invalid peer name %q: expected vpn:peer", s)
 }
 
 func (r *Report) addChange(action, path string) {
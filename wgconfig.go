@@ -0,0 +1,93 @@
+package bypasser
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ValidateWGConfig checks that content is a structurally valid wg-quick
+// config: one [Interface] with a PrivateKey, [Peer] sections with a
+// PublicKey, 32-byte base64 keys, a numeric ListenPort and CIDR Address and
+// AllowedIPs lists. It returns the first problem found.
+func ValidateWGConfig(content string) error {
+	doc, err := ParseINI(content)
+	if err != nil {
+		return err
+	}
+	interfaces := 0
+	for _, sec := range doc.Sections {
+		switch sec.Name {
+		case "":
+			if len(sec.Entries) > 0 {
+				return fmt.Errorf("key %q appears before any section", sec.Entries[0].Key)
+			}
+			continue
+		case "Interface":
+			interfaces++
+			if _, ok := sec.Get("PrivateKey"); !ok {
+				return fmt.Errorf("[Interface] is missing PrivateKey")
+			}
+		case "Peer":
+			if _, ok := sec.Get("PublicKey"); !ok {
+				return fmt.Errorf("[Peer] is missing PublicKey")
+			}
+		default:
+			return fmt.Errorf("unknown section [%s]", sec.Name)
+		}
+		for _, e := range sec.Entries {
+			if err := validateWGValue(e.Key, e.Value); err != nil {
+				return fmt.Errorf("[%s] %s: %w", sec.Name, e.Key, err)
+			}
+		}
+	}
+	if interfaces != 1 {
+		return fmt.Errorf("expected exactly one [Interface] section, found %d", interfaces)
+	}
+	return nil
+}
+
+func validateWGValue(key, value string) error {
+	switch strings.ToLower(key) {
+	case "privatekey", "publickey", "presharedkey":
+		if !isValidWGKey(value) {
+			return fmt.Errorf("expected a base64-encoded 32-byte key")
+		}
+	case "listenport":
+		if n, err := strconv.Atoi(value); err != nil || n < 0 || n > 65535 {
+			return fmt.Errorf("invalid port %q", value)
+		}
+	case "address", "allowedips":
+		if err := validateCIDRList(value); err != nil {
+			return err
+		}
+	case "endpoint":
+		if _, port, err := net.SplitHostPort(value); err != nil {
+			return err
+		} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q", port)
+		}
+	case "fwmark":
+		if value != "off" {
+			if _, err := parseFwMark(value); err != nil {
+				return err
+			}
+		}
+	case "table":
+		if !validTable(value) {
+			return fmt.Errorf("invalid table %q", value)
+		}
+	case "mtu", "persistentkeepalive":
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("invalid number %q", value)
+		}
+	}
+	return nil
+}
+
+func isValidWGKey(s string) bool {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(b) == 32
+}
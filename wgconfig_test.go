@@ -0,0 +1,82 @@
+package bypasser
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testWGKey = "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+
+func TestValidateWGConfig(t *testing.T) {
+	t.Parallel()
+
+	valid := `# bp-managed: vpn=home
+[Interface]
+PrivateKey = ` + testWGKey + `
+ListenPort = 55107
+Address = 69.0.1.1/24, fd00:6900:1::1/64
+
+[Peer]
+PublicKey = ` + testWGKey + `
+PresharedKey = ` + testWGKey + `
+AllowedIPs = 69.0.1.2/32
+Endpoint = [2001:db8::7]:55107
+`
+	if err := ValidateWGConfig(valid); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+
+	tests := []struct {
+		name, old, new, want string
+	}{
+		{"duplicate interface", "\n[Peer]", "\n[Interface]\nPrivateKey = " + testWGKey + "\n[Peer]", "exactly one [Interface]"},
+		{"missing private key", "PrivateKey = ", "#PrivateKey = ", "missing PrivateKey"},
+		{"short key", "PublicKey = " + testWGKey, "PublicKey = AAAA", "PublicKey"},
+		{"bad base64", "PresharedKey = " + testWGKey, "PresharedKey = not base64!", "PresharedKey"},
+		{"port", "ListenPort = 55107", "ListenPort = high", "ListenPort"},
+		{"address", "69.0.1.1/24", "69.0.1.1", "Address"},
+		{"allowed ips", "69.0.1.2/32", "69.0.1.2/33", "AllowedIPs"},
+		{"endpoint", "[2001:db8::7]:55107", "2001:db8::7", "Endpoint"},
+		{"unknown section", "[Peer]", "[Peers]", "unknown section"},
+		{"malformed line", "[Peer]", "[Peer]\ngarbage", "garbage"},
+	}
+	for _, tt := range tests {
+		err := ValidateWGConfig(strings.Replace(valid, tt.old, tt.new, 1))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%s: expected error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestValidateBeforeWrite(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cfg := Config{WireGuardDir: t.TempDir(), PublicInterface: "eth0", EndpointHost: "203.0.113.7", ValidateBeforeWrite: true}
+	mgr := NewManager(cfg, Dependencies{System: &FakeSystem{}, Keys: NativeKeyGenerator{}})
+	if _, err := mgr.AddVPN(ctx, "home"); err != nil {
+		t.Fatalf("AddVPN returned error: %v", err)
+	}
+	if _, err := mgr.AddPeer(ctx, "home", "laptop"); err != nil {
+		t.Fatalf("AddPeer returned error: %v", err)
+	}
+
+	bad := NewManager(cfg, Dependencies{System: &FakeSystem{}, Keys: malformedKeys{}})
+	if _, err := bad.AddVPN(ctx, "work"); !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation for malformed keys, got %v", err)
+	}
+	if _, err := os.Stat(cfg.VPNConfigPath("work")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no config to be written, stat err %v", err)
+	}
+}
+
+type malformedKeys struct{}
+
+func (malformedKeys) GeneratePrivateKey(context.Context) (string, error) { return "PRIV", nil }
+func (malformedKeys) DerivePublicKey(context.Context, string) (string, error) {
+	return "PUB", nil
+}
+func (malformedKeys) GeneratePresharedKey(context.Context) (string, error) { return "PSK", nil }